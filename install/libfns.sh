@@ -841,7 +841,8 @@ net.core.wmem_max = 16777216' | ${SB_SUDO} tee /etc/sysctl.d/60-shipbuilder.conf
     ${SB_SUDO} apt-add-repository --yes "${ppa}"
     abortIfNonZero $? "command 'apt-add-repository --yes ${ppa}'"
 
-    required="haproxy ntp"
+    # NB: socat is used to query the HAProxy stats socket for autoscaling.
+    required="haproxy ntp socat"
     echo "info: installing required packages: ${required}"
     ${SB_SUDO} apt update
     abortIfNonZero $? "updating apt"
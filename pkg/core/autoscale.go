@@ -0,0 +1,499 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	AutoscaleUp    = "up"
+	AutoscaleDown  = "down"
+	AutoscaleHold  = "hold"
+	AutoscaleError = "error"
+
+	defaultAutoscaleCooldown = 5 * time.Minute
+	maxAutoscaleEvents       = 500 // Number of autoscaler decisions retained per app.
+
+	haProxyStatsCommand = `echo "show stat" | sudo socat stdio /run/haproxy/admin.sock`
+)
+
+// AutoscalePolicy describes how the autoscaler sizes a process type.  Each
+// target which is set yields a desired number of dynos, and the largest wins,
+// bounded by Min and Max.
+type AutoscalePolicy struct {
+	Min                int
+	Max                int
+	TargetRequestRate  int           `json:",omitempty"` // Requests per second per dyno, from HAProxy stats (web only).
+	TargetQueueDepth   int           `json:",omitempty"` // Requests queued by HAProxy per dyno (web only).
+	TargetFreeMemoryMb int           `json:",omitempty"` // Free memory to maintain on nodes running the process type's dynos.
+	Cooldown           time.Duration `json:",omitempty"` // Minimum time between scaling actions, defaults to 5m.
+}
+
+// AutoscaleMetrics are the observations a policy is evaluated against.
+type AutoscaleMetrics struct {
+	HasStats     bool // Whether HAProxy stats were available.
+	RequestRate  int  // Requests per second to the app across all load-balancers.
+	QueueDepth   int  // Requests queued across all load-balancers.
+	HasMemory    bool // Whether any nodes are running the process type's dynos.
+	FreeMemoryMb int  // Least free memory of the nodes running the process type's dynos.
+}
+
+// AutoscaleEvent records a decision made by the autoscaler.
+type AutoscaleEvent struct {
+	Ts      time.Time `json:"ts"`
+	Process string    `json:"process"`
+	Action  string    `json:"action"` // One of "up", "down", "hold" or "error".
+	From    int       `json:"from"`
+	To      int       `json:"to"`
+	Reason  string    `json:"reason"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// autoscaledProcesses returns the process types of an app which have an
+// autoscale policy, in sorted order.
+func (app *Application) autoscaledProcesses() []string {
+	processes := []string{}
+	for process := range app.Autoscale {
+		processes = append(processes, process)
+	}
+	sort.Strings(processes)
+	return processes
+}
+
+// ParseAutoscalePolicy applies key=value settings, e.g. min=2, max=10,
+// rate=50, queue=10, memory=512MB and cooldown=5m, on top of an existing
+// policy.  An empty value removes a target.
+func ParseAutoscalePolicy(policy AutoscalePolicy, args map[string]string) (AutoscalePolicy, error) {
+	for _, key := range sortedStringKeys(args) {
+		var (
+			value = strings.TrimSpace(args[key])
+			n     int
+			err   error
+		)
+		switch key {
+		case "min", "max", "rate", "queue":
+			if len(value) > 0 {
+				if n, err = strconv.Atoi(strings.TrimSuffix(value, "/s")); err == nil && n < 0 {
+					err = fmt.Errorf("must not be negative")
+				}
+			}
+			switch key {
+			case "min":
+				policy.Min = n
+			case "max":
+				policy.Max = n
+			case "rate":
+				policy.TargetRequestRate = n
+			case "queue":
+				policy.TargetQueueDepth = n
+			}
+
+		case "memory":
+			if len(value) > 0 {
+				n, err = parseMemoryMb(value)
+			}
+			policy.TargetFreeMemoryMb = n
+
+		case "cooldown":
+			var d time.Duration
+			if len(value) > 0 {
+				if d, err = time.ParseDuration(value); err == nil && d < 0 {
+					err = fmt.Errorf("must not be negative")
+				}
+			}
+			policy.Cooldown = d
+
+		default:
+			return policy, fmt.Errorf("unrecognized autoscale setting %q, must be one of: min, max, rate, queue, memory, cooldown", key)
+		}
+		if err != nil {
+			return policy, fmt.Errorf("invalid autoscale %v %q: %s", key, value, err)
+		}
+	}
+	return policy, nil
+}
+
+// Validate checks the policy is usable.
+func (policy AutoscalePolicy) Validate() error {
+	if policy.Max < 1 {
+		return fmt.Errorf("autoscale max must be at least 1")
+	}
+	if policy.Min > policy.Max {
+		return fmt.Errorf("autoscale min=%v must not exceed max=%v", policy.Min, policy.Max)
+	}
+	if policy.TargetRequestRate == 0 && policy.TargetQueueDepth == 0 && policy.TargetFreeMemoryMb == 0 {
+		return fmt.Errorf("at least one autoscale target is required: rate, queue or memory")
+	}
+	return nil
+}
+
+// CooldownDuration returns the minimum time between scaling actions.
+func (policy AutoscalePolicy) CooldownDuration() time.Duration {
+	if policy.Cooldown > 0 {
+		return policy.Cooldown
+	}
+	return defaultAutoscaleCooldown
+}
+
+func (policy AutoscalePolicy) String() string {
+	s := fmt.Sprintf("min=%v max=%v", policy.Min, policy.Max)
+	if policy.TargetRequestRate > 0 {
+		s += fmt.Sprintf(" rate=%v/s", policy.TargetRequestRate)
+	}
+	if policy.TargetQueueDepth > 0 {
+		s += fmt.Sprintf(" queue=%v", policy.TargetQueueDepth)
+	}
+	if policy.TargetFreeMemoryMb > 0 {
+		s += fmt.Sprintf(" memory=%vMB", policy.TargetFreeMemoryMb)
+	}
+	return s + fmt.Sprintf(" cooldown=%v", policy.CooldownDuration())
+}
+
+// Evaluate returns the desired number of dynos given the current number and
+// the observed metrics, along with the reasoning.
+//
+// The request rate target tracks in both directions.  The queue depth target
+// scales up when more than the target number of requests per dyno are queued
+// and down by one when nothing is queued.  The free memory target only ever
+// scales up, by one dyno at a time.
+func (policy AutoscalePolicy) Evaluate(current int, metrics AutoscaleMetrics) (int, string) {
+	var (
+		desired = -1
+		reasons = []string{}
+		want    = func(n int, reason string) {
+			if n > desired {
+				desired = n
+			}
+			reasons = append(reasons, reason)
+		}
+	)
+	if policy.TargetRequestRate > 0 && metrics.HasStats {
+		n := ceilDiv(metrics.RequestRate, policy.TargetRequestRate)
+		want(n, fmt.Sprintf("request rate %v/s calls for %v dynos at %v/s each", metrics.RequestRate, n, policy.TargetRequestRate))
+	}
+	if policy.TargetQueueDepth > 0 && metrics.HasStats {
+		if metrics.QueueDepth > policy.TargetQueueDepth*current {
+			n := ceilDiv(metrics.QueueDepth, policy.TargetQueueDepth)
+			want(n, fmt.Sprintf("queue depth %v calls for %v dynos at %v each", metrics.QueueDepth, n, policy.TargetQueueDepth))
+		} else if metrics.QueueDepth == 0 {
+			want(current-1, "no requests queued")
+		} else {
+			want(current, fmt.Sprintf("queue depth %v is within target", metrics.QueueDepth))
+		}
+	}
+	if policy.TargetFreeMemoryMb > 0 && metrics.HasMemory {
+		if metrics.FreeMemoryMb < policy.TargetFreeMemoryMb {
+			want(current+1, fmt.Sprintf("free memory %vMB is below %vMB", metrics.FreeMemoryMb, policy.TargetFreeMemoryMb))
+		} else {
+			reasons = append(reasons, fmt.Sprintf("free memory %vMB is within target", metrics.FreeMemoryMb))
+		}
+	}
+	if desired == -1 {
+		desired = current
+		if len(reasons) == 0 {
+			reasons = append(reasons, "no metrics available")
+		}
+	}
+	if desired < policy.Min {
+		desired = policy.Min
+		reasons = append(reasons, fmt.Sprintf("bounded by min=%v", policy.Min))
+	} else if desired > policy.Max {
+		desired = policy.Max
+		reasons = append(reasons, fmt.Sprintf("bounded by max=%v", policy.Max))
+	}
+	return desired, strings.Join(reasons, "; ")
+}
+
+func ceilDiv(a int, b int) int {
+	return (a + b - 1) / b
+}
+
+// haProxyBackendStats are the current stats of an HAProxy backend.
+type haProxyBackendStats struct {
+	Rate  int // Sessions per second over the last second.
+	Queue int // Queued requests not yet assigned to a server.
+}
+
+// parseHAProxyStats parses the CSV output of the HAProxy `show stat' command
+// into stats per backend.
+func parseHAProxyStats(output string) (map[string]haProxyBackendStats, error) {
+	rows, err := parseHAProxyStatsRows(output)
+	if err != nil {
+		return nil, err
+	}
+	stats := map[string]haProxyBackendStats{}
+	for _, row := range rows {
+		if row["svname"] != "BACKEND" {
+			continue
+		}
+		s := haProxyBackendStats{}
+		s.Rate, _ = strconv.Atoi(row["rate"])
+		s.Queue, _ = strconv.Atoi(row["qcur"])
+		stats[row["pxname"]] = s
+	}
+	return stats, nil
+}
+
+// parseHAProxyStatsRows parses the CSV output of the HAProxy `show stat'
+// command into rows of column name to value.
+func parseHAProxyStatsRows(output string) ([]map[string]string, error) {
+	var (
+		rows    = []map[string]string{}
+		columns = []string{}
+	)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			columns = strings.Split(strings.TrimSpace(strings.TrimPrefix(line, "#")), ",")
+			continue
+		}
+		if len(columns) == 0 {
+			return nil, fmt.Errorf("missing HAProxy stats header line")
+		}
+		row := map[string]string{}
+		for i, field := range strings.Split(line, ",") {
+			if i < len(columns) {
+				row[columns[i]] = field
+			}
+		}
+		rows = append(rows, row)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("missing HAProxy stats header line")
+	}
+	return rows, nil
+}
+
+// haProxyStats collects backend stats from all load-balancers and sums them.
+func (server *Server) haProxyStats(loadBalancers []string) (map[string]haProxyBackendStats, error) {
+	if len(loadBalancers) == 0 {
+		return nil, fmt.Errorf("no load-balancers configured")
+	}
+	totals := map[string]haProxyBackendStats{}
+	for _, host := range loadBalancers {
+		output, err := RemoteCommand(host, haProxyStatsCommand)
+		if err != nil {
+			return nil, fmt.Errorf("querying HAProxy stats on load-balancer %v: %s", host, err)
+		}
+		stats, err := parseHAProxyStats(output)
+		if err != nil {
+			return nil, fmt.Errorf("parsing HAProxy stats from load-balancer %v: %s", host, err)
+		}
+		for backend, s := range stats {
+			total := totals[backend]
+			total.Rate += s.Rate
+			total.Queue += s.Queue
+			totals[backend] = total
+		}
+	}
+	return totals, nil
+}
+
+// autoscaleMetrics gathers the metrics for a process type of an app.
+func (server *Server) autoscaleMetrics(app *Application, process string, cfg *Config, stats map[string]haProxyBackendStats) AutoscaleMetrics {
+	metrics := AutoscaleMetrics{}
+	if s, ok := stats[app.Name]; ok && process == "web" {
+		metrics.HasStats = true
+		metrics.RequestRate = s.Rate
+		metrics.QueueDepth = s.Queue
+	}
+	process = normalizeAppProcessName(process)
+	for _, node := range cfg.Nodes {
+		status := server.getNodeStatus(node)
+		if status.Err != nil {
+			continue
+		}
+		for _, container := range status.Containers {
+			dyno, err := status.Dyno(container)
+			if err != nil || dyno.State != DYNO_STATE_RUNNING || dyno.Application != app.Name || !sameProcess(dyno.Process, process) {
+				continue
+			}
+			if !metrics.HasMemory || status.FreeMemoryMb < metrics.FreeMemoryMb {
+				metrics.FreeMemoryMb = status.FreeMemoryMb
+			}
+			metrics.HasMemory = true
+			break
+		}
+	}
+	return metrics
+}
+
+// autoscale periodically evaluates the autoscaling policies of all apps.
+func (server *Server) autoscale() {
+	for range time.Tick(AUTOSCALE_INTERVAL_SECONDS * time.Second) {
+		if err := server.autoscaleApps(); err != nil {
+			log.Errorf("[autoscale] %s", err)
+		}
+	}
+}
+
+func (server *Server) autoscaleApps() error {
+	cfg, err := server.getConfig(true)
+	if err != nil {
+		return err
+	}
+	apps := []*Application{}
+	for _, app := range cfg.Applications {
+		if len(app.Autoscale) > 0 && len(app.LastDeploy) > 0 {
+			apps = append(apps, app)
+		}
+	}
+	if len(apps) == 0 {
+		return nil
+	}
+
+	// NB: Stats only drive the web process type, so missing stats are not fatal.
+	stats, err := server.haProxyStats(cfg.LoadBalancersUsing(LBDriverHAProxy))
+	if err != nil {
+		log.Warnf("[autoscale] Request rate and queue depth targets will be skipped: %s", err)
+	}
+	for _, app := range apps {
+		server.autoscaleApp(app, cfg, stats)
+	}
+	return nil
+}
+
+// autoscaleApp evaluates the policies of an app, rescales it if necessary and
+// records the decisions in the app's autoscale event log.
+func (server *Server) autoscaleApp(app *Application, cfg *Config, stats map[string]haProxyBackendStats) {
+	var (
+		now     = time.Now()
+		events  = []AutoscaleEvent{}
+		changes = map[string]string{}
+		logger  = NewLogger(os.Stdout, fmt.Sprintf("[autoscale:%v] ", app.Name))
+	)
+	defer func() {
+		if err := appendAutoscaleEvents(app.Name, events...); err != nil {
+			log.WithField("app", app.Name).Errorf("Problem recording autoscale events: %s", err)
+		}
+	}()
+
+	previous, err := ListAutoscaleEvents(app.Name)
+	if err != nil {
+		log.WithField("app", app.Name).Errorf("Problem reading autoscale events: %s", err)
+	}
+
+	for _, process := range app.autoscaledProcesses() {
+		var (
+			policy          = app.Autoscale[process]
+			current         = app.Processes[process]
+			desired, reason = policy.Evaluate(current, server.autoscaleMetrics(app, process, cfg, stats))
+			event           = AutoscaleEvent{Ts: now, Process: process, Action: AutoscaleHold, From: current, To: current, Reason: reason}
+		)
+		if desired != current {
+			if last := lastAutoscaleAction(previous, process); now.Sub(last) < policy.CooldownDuration() {
+				event.Reason = fmt.Sprintf("cooling down until %v, wanted %v dynos: %v", last.Add(policy.CooldownDuration()).Format(time.RFC3339), desired, reason)
+			} else {
+				event.To = desired
+				if desired > current {
+					event.Action = AutoscaleUp
+				} else {
+					event.Action = AutoscaleDown
+				}
+				changes[process] = strconv.Itoa(desired)
+			}
+		}
+		events = append(events, event)
+	}
+
+	if len(changes) == 0 {
+		return
+	}
+
+	// Don't interfere with deploys or other commands which are running.
+	if !tryLockApp(app.Name) {
+		err = fmt.Errorf("another command is running for the app")
+	} else {
+		defer unlockApp(app.Name)
+		fmt.Fprintf(logger, "Rescaling: %v\n", changes)
+		err = server.rescale(logger, app.Name, false, changes)
+	}
+	if err != nil {
+		log.WithField("app", app.Name).Errorf("Autoscale rescale failed: %s", err)
+		for i := range events {
+			if _, ok := changes[events[i].Process]; ok {
+				events[i].Action = AutoscaleError
+				events[i].Error = err.Error()
+			}
+		}
+	}
+}
+
+// lastAutoscaleAction returns when the autoscaler last successfully scaled a
+// process type.
+func lastAutoscaleAction(events []AutoscaleEvent, process string) time.Time {
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Process == process && (events[i].Action == AutoscaleUp || events[i].Action == AutoscaleDown) {
+			return events[i].Ts
+		}
+	}
+	return time.Time{}
+}
+
+func autoscaleEventsPath(applicationName string) string {
+	return filepath.Join(AUTOSCALE_EVENTS_DIRECTORY, applicationName+".json")
+}
+
+// ListAutoscaleEvents returns the recorded autoscaler decisions for an app,
+// oldest first.
+func ListAutoscaleEvents(applicationName string) ([]AutoscaleEvent, error) {
+	events := []AutoscaleEvent{}
+	data, err := ioutil.ReadFile(autoscaleEventsPath(applicationName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return events, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, fmt.Errorf("decoding autoscale events file %q: %s", autoscaleEventsPath(applicationName), err)
+	}
+	return events, nil
+}
+
+// appendAutoscaleEvents records autoscaler decisions for an app, retaining
+// only the most recent maxAutoscaleEvents.
+func appendAutoscaleEvents(applicationName string, events ...AutoscaleEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	existing, err := ListAutoscaleEvents(applicationName)
+	if err != nil {
+		return err
+	}
+	existing = append(existing, events...)
+	if len(existing) > maxAutoscaleEvents {
+		existing = existing[len(existing)-maxAutoscaleEvents:]
+	}
+	data, err := json.Marshal(existing)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(AUTOSCALE_EVENTS_DIRECTORY, os.FileMode(int(0700))); err != nil {
+		return fmt.Errorf("creating autoscale events directory: %s", err)
+	}
+	if err := ioutil.WriteFile(autoscaleEventsPath(applicationName), data, os.FileMode(int(0600))); err != nil {
+		return fmt.Errorf("writing autoscale events file %q: %s", autoscaleEventsPath(applicationName), err)
+	}
+	return nil
+}
+
+// DeleteAutoscaleEvents removes the autoscale event log for an app.
+func DeleteAutoscaleEvents(applicationName string) error {
+	if err := os.Remove(autoscaleEventsPath(applicationName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
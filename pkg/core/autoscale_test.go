@@ -0,0 +1,118 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAutoscalePolicyEvaluate(t *testing.T) {
+	testCases := []struct {
+		policy   AutoscalePolicy
+		current  int
+		metrics  AutoscaleMetrics
+		expected int
+	}{
+		{
+			policy:   AutoscalePolicy{Min: 1, Max: 10, TargetRequestRate: 50},
+			current:  2,
+			metrics:  AutoscaleMetrics{HasStats: true, RequestRate: 230},
+			expected: 5,
+		},
+		{
+			policy:   AutoscalePolicy{Min: 2, Max: 10, TargetRequestRate: 50},
+			current:  5,
+			metrics:  AutoscaleMetrics{HasStats: true, RequestRate: 10},
+			expected: 2,
+		},
+		{
+			policy:   AutoscalePolicy{Min: 1, Max: 4, TargetRequestRate: 50},
+			current:  2,
+			metrics:  AutoscaleMetrics{HasStats: true, RequestRate: 1000},
+			expected: 4,
+		},
+		{
+			// Stats unavailable, hold.
+			policy:   AutoscalePolicy{Min: 1, Max: 10, TargetRequestRate: 50},
+			current:  3,
+			expected: 3,
+		},
+		{
+			policy:   AutoscalePolicy{Min: 1, Max: 10, TargetQueueDepth: 10},
+			current:  2,
+			metrics:  AutoscaleMetrics{HasStats: true, QueueDepth: 45},
+			expected: 5,
+		},
+		{
+			policy:   AutoscalePolicy{Min: 1, Max: 10, TargetQueueDepth: 10},
+			current:  3,
+			metrics:  AutoscaleMetrics{HasStats: true},
+			expected: 2,
+		},
+		{
+			// The largest desired number of dynos wins.
+			policy:   AutoscalePolicy{Min: 1, Max: 10, TargetRequestRate: 50, TargetFreeMemoryMb: 512},
+			current:  3,
+			metrics:  AutoscaleMetrics{HasStats: true, RequestRate: 50, HasMemory: true, FreeMemoryMb: 256},
+			expected: 4,
+		},
+		{
+			policy:   AutoscalePolicy{Min: 1, Max: 10, TargetFreeMemoryMb: 512},
+			current:  3,
+			metrics:  AutoscaleMetrics{HasMemory: true, FreeMemoryMb: 2048},
+			expected: 3,
+		},
+	}
+
+	for i, testCase := range testCases {
+		if actual, reason := testCase.policy.Evaluate(testCase.current, testCase.metrics); actual != testCase.expected {
+			t.Errorf("[i=%v] Expected desired=%v but actual=%v (reason=%v)", i, testCase.expected, actual, reason)
+		}
+	}
+}
+
+func TestParseAutoscalePolicy(t *testing.T) {
+	policy, err := ParseAutoscalePolicy(AutoscalePolicy{Min: 2, Max: 2}, map[string]string{"max": "10", "rate": "50/s", "memory": "1GB", "cooldown": "90s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := AutoscalePolicy{Min: 2, Max: 10, TargetRequestRate: 50, TargetFreeMemoryMb: 1024, Cooldown: 90 * time.Second}
+	if policy != expected {
+		t.Errorf("Expected policy=%+v but actual=%+v", expected, policy)
+	}
+	if err := policy.Validate(); err != nil {
+		t.Errorf("Unexpected validation error: %s", err)
+	}
+
+	if _, err := ParseAutoscalePolicy(policy, map[string]string{"cpu": "50%"}); err == nil {
+		t.Errorf("Expected error for unrecognized setting")
+	}
+	if policy, _ = ParseAutoscalePolicy(policy, map[string]string{"min": "12"}); policy.Validate() == nil {
+		t.Errorf("Expected validation error for min > max")
+	}
+	if err := (AutoscalePolicy{Min: 1, Max: 3}).Validate(); err == nil {
+		t.Errorf("Expected validation error for policy without targets")
+	}
+}
+
+func TestParseHAProxyStats(t *testing.T) {
+	output := `# pxname,svname,qcur,qmax,scur,smax,slim,stot,bin,bout,dreq,dresp,ereq,econ,eresp,wretr,wredis,status,weight,act,bck,chkfail,chkdown,lastchg,downtime,qlimit,pid,iid,sid,throttle,lbtot,tracked,type,rate,
+frontend,FRONTEND,,,3,10,2000,120,0,0,0,0,0,,,,,OPEN,,,,,,,,,1,2,0,,,,0,12,
+myapp,node1-10001,0,0,1,5,,60,0,0,,0,,0,0,0,0,UP,1,1,0,0,0,100,0,,1,3,1,,60,,2,6,
+myapp,BACKEND,7,9,2,8,200,120,0,0,0,0,,0,0,0,0,UP,2,2,0,,0,100,0,,1,3,0,,120,,1,11,
+`
+	stats, err := parseHAProxyStats(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (haProxyBackendStats{Rate: 11, Queue: 7}); stats["myapp"] != expected {
+		t.Errorf("Expected myapp stats=%+v but actual=%+v", expected, stats["myapp"])
+	}
+	if len(stats) != 1 {
+		t.Errorf("Expected only backend rows but actual=%+v", stats)
+	}
+
+	if _, err := parseHAProxyStats("Unknown command.\n"); err == nil || !strings.Contains(err.Error(), "header") {
+		t.Errorf("Expected missing header error but actual=%v", err)
+	}
+}
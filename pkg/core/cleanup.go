@@ -3,8 +3,10 @@ package core
 import (
 	"fmt"
 	"os"
-	"regexp"
-	"strings"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 func (server *Server) numDynosAtVersion(applicationName, version string, hostStatusMap *map[string]NodeStatus) (int, error) {
@@ -47,7 +49,7 @@ func (server *Server) pruneDynos(nodeStatus NodeStatus, hostStatusMap *map[strin
 		appsByName[app.Name] = app
 		for process, _ := range app.Processes {
 			//fmt.Fprintf(logger, "Existing app found, name=%v version=%v\n", app.Name, app.LastDeploy)
-			appMap[Key{app.Name, app.LastDeploy, process}] = true
+			appMap[Key{app.Name, app.LastDeploy, normalizeAppProcessName(process)}] = true
 		}
 	}
 
@@ -62,7 +64,16 @@ func (server *Server) pruneDynos(nodeStatus NodeStatus, hostStatusMap *map[strin
 	for _, dyno := range dynos {
 		destroy := false
 
-		if dyno.State == DYNO_STATE_STOPPED {
+		if dyno.Process == DYNO_PROCESS_RUN {
+			// One-off dynos are destroyed by the run itself once its command
+			// exits, unless the server lost track of the run, e.g. by restarting
+			// mid-run.
+			if !activeRuns.Contains(dyno.Container) {
+				fmt.Fprintf(logger, "one-off dyno %q isn't supervised by any run, terminating it\n", dyno.Container)
+				destroy = true
+			}
+
+		} else if dyno.State == DYNO_STATE_STOPPED {
 			// Cleanup old stopped dynos which haven't already been reclaimed.
 			app, ok := appsByName[dyno.Application]
 			if ok {
@@ -81,7 +92,7 @@ func (server *Server) pruneDynos(nodeStatus NodeStatus, hostStatusMap *map[strin
 			}
 
 		} else if dyno.State == DYNO_STATE_RUNNING {
-			key := Key{dyno.Application, dyno.Version, dyno.Process}
+			key := Key{dyno.Application, dyno.Version, normalizeAppProcessName(dyno.Process)}
 			_, ok := appMap[key]
 			if !ok {
 				// Verify that the app has some dynos running at the current version.
@@ -120,11 +131,13 @@ func (server *Server) pruneDynos(nodeStatus NodeStatus, hostStatusMap *map[strin
 		}
 
 		if destroy {
-			// TODO: Add LB config check to ensure that dyno.Node + "-" + dyno.Port does
-			// not appear anywhere in the haproxy config.
 			fmt.Fprintf(logger, "Cleaning up trash name=%v version=%v\n", dyno.Application, dyno.Version)
+			gracePeriod := DEFAULT_SHUTDOWN_GRACE_PERIOD_SECONDS * time.Second
+			if app, ok := appsByName[dyno.Application]; ok {
+				gracePeriod = app.ShutdownGracePeriod()
+			}
 			go func(dyno Dyno) {
-				dyno.Shutdown(e)
+				dyno.Shutdown(e, gracePeriod)
 			}(dyno)
 		}
 	}
@@ -133,10 +146,10 @@ func (server *Server) pruneDynos(nodeStatus NodeStatus, hostStatusMap *map[strin
 }
 
 // dynoRoutingActive determines if a Dyno has active routes defined in the
-// current load-balancer configuration.
+// current load-balancer configuration.  When the routes of any load-balancer
+// can't be determined the dyno is assumed to be in use, an unreachable
+// load-balancer may still be sending it traffic.
 func (server *Server) dynoRoutingActive(dyno *Dyno) (bool, error) {
-	// LB config check to ensure that dyno.Node + "-" + dyno.Port does not appear
-	// anywhere in the haproxy config.
 	// Non-web dynos have nothing to do with the load-balancer.
 	if dyno.Process != "web" {
 		return false, nil
@@ -153,14 +166,26 @@ func (server *Server) dynoRoutingActive(dyno *Dyno) (bool, error) {
 		return false, nil
 	}
 
-	lbConfig, err := server.GetActiveLoadBalancerConfig()
+	port, err := strconv.Atoi(dyno.Port)
 	if err != nil {
 		return true, err
 	}
-
-	expr := regexp.MustCompile(` backend ` + dyno.Application + ` ([^b]|b[^a]|ba[^c]|bac[^k]|back[^e]|backe[^n]|backen[^d])* ` + dyno.Host + `-` + dyno.Port)
-	inUse := expr.MatchString(strings.Replace(lbConfig, "\n", " ", -1))
-	return inUse, nil
+	active, failed, err := server.ActiveLoadBalancerRoutes()
+	if err != nil {
+		return true, err
+	}
+	if len(failed) > 0 || len(active) == 0 {
+		for host, err := range failed {
+			log.Warnf("Routes of load-balancer %v unknown, keeping dyno %v: %s", host, dyno.Container, err)
+		}
+		return true, nil
+	}
+	for _, routes := range active {
+		if routes.RoutesTo(dyno.Application, dyno.Host, port) {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 // appStateHealth returns true if the current set of running dynos encapsulates
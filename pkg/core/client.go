@@ -22,14 +22,16 @@ const (
 	STDERR_FD = 2
 )
 
-type Client struct{}
+type Client struct {
+	Upload io.Reader // Content to stream to the server upon receiving an UploadRequest.
+}
 
 func fail(format string, args ...interface{}) {
 	fmt.Printf("\033[%vm%v\033[0m\n", RED, fmt.Sprintf(format, args...))
 	os.Exit(1)
 }
 
-func (*Client) send(msg Message, disableTunnel bool) error {
+func (client *Client) send(msg Message, disableTunnel bool) error {
 	log.WithField("msg", fmt.Sprintf("%+v", msg)).Debug("CLIENT DEBUG")
 	// Open a tunnel if necessary
 	/*if terminal.IsTerminal(STDOUT_FD) {
@@ -77,6 +79,10 @@ func (*Client) send(msg Message, disableTunnel bool) error {
 				os.Exit(1)
 			}
 			Send(conn, Message{ReadLineResponse, response})
+		case UploadRequest:
+			if err := client.upload(conn); err != nil {
+				return err
+			}
 		case Hijack:
 			ec := make(chan error, 1)
 			go func() {
@@ -101,6 +107,31 @@ func (*Client) send(msg Message, disableTunnel bool) error {
 	return nil
 }
 
+// upload streams the client Upload content to the server as a series of
+// UploadData messages, terminated by an empty one.
+func (client *Client) upload(conn net.Conn) error {
+	if client.Upload == nil {
+		return Errorf(conn, "client has no content to upload")
+	}
+	buf := make([]byte, uploadChunkSize)
+	for {
+		n, err := client.Upload.Read(buf)
+		if n > 0 {
+			if sendErr := Send(conn, Message{UploadData, string(buf[:n])}); sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			Errorf(conn, "reading upload content: %s", err)
+			return err
+		}
+	}
+	return Send(conn, Message{UploadData, ""})
+}
+
 // RemoteExec takes a Shipbuilder server method name and corresponding args, and
 // invokes it remotely.
 func (client *Client) RemoteExec(methodName string, args ...interface{}) error {
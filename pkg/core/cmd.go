@@ -192,6 +192,18 @@ func init() {
 		),
 		global("health", "apps:health", "Apps_Health"),
 
+		////////////////////////////////////////////////////////////////////////
+		// autoscale:*
+		reader("autoscale", "autoscale:show", "Autoscale_Show",
+			required("app"),
+		),
+		writer("autoscale:set", "autoscale:set", "Autoscale_Set",
+			required("app"), required("process"), mapped("args"),
+		),
+		writer("autoscale:disable", "autoscale:disable", "Autoscale_Disable",
+			required("app"), list("processTypes"),
+		),
+
 		////////////////////////////////////////////////////////////////////////
 		// config:*
 		reader("config", "config:list", "Config_List",
@@ -212,12 +224,57 @@ func init() {
 		reader("run", "console", "Console",
 			required("app"), list("args"),
 		),
+		reader("run:detached", "run:detached", "Run_Detached",
+			required("app"), list("args"),
+		),
+		reader("run:list", "runs", "Run_List",
+			required("app"),
+		),
+
+		////////////////////////////////////////////////////////////////////////
+		// scheduler:*
+		reader("scheduler", "scheduler:list", "Scheduler_List",
+			required("app"),
+		),
+		writer("scheduler:add", "scheduler:add", "Scheduler_Add",
+			required("app"), required("schedule"), list("args"),
+		),
+		writer("scheduler:remove", "scheduler:remove", "Scheduler_Remove",
+			required("app"), list("ids"),
+		),
+
+		////////////////////////////////////////////////////////////////////////
+		// constraints:*
+		reader("constraints", "constraints:list", "Constraints_List",
+			required("app"),
+		),
+		writer("constraints:add", "constraints:add", "Constraints_Add",
+			required("app"), list("args"), mapped("labels"),
+		),
+		writer("constraints:remove", "constraints:remove", "Constraints_Remove",
+			required("app"), list("args"), mapped("labels"),
+		),
 
 		////////////////////////////////////////////////////////////////////////
 		// deploy
 		writer("deploy", "deploy", "Deploy",
 			required("app"), required("revision"),
 		),
+		writer("deploy:archive", "deploy:archive", "Deploy_Archive",
+			required("app"), required("filename"),
+		),
+		writer("deploy:resume", "deploy:resume", "Deploy_Resume",
+			required("app"),
+		),
+
+		////////////////////////////////////////////////////////////////////////
+		// deploys:*
+		reader("deploys", "deploys:list", "Deploys_List",
+			required("app"),
+		),
+		reader("deploys:log", "deploys:log", "Deploys_Log",
+			required("app"), required("version"),
+		),
 
 		////////////////////////////////////////////////////////////////////////
 		// domains:*
@@ -253,13 +310,14 @@ func init() {
 		////////////////////////////////////////////////////////////////////////
 		// lb:*
 		global("lb:add", "lb:add", "LoadBalancer_Add",
-			list("addresses"),
+			optional("driver", ""), list("addresses"),
 		),
 		global("lb", "lb:list", "LoadBalancer_List"),
 		global("lb:remove", "lb:remove", "LoadBalancer_Remove",
 			list("addresses"),
 		),
 		global("lb:sync", "lb:sync", "LoadBalancer_Sync"),
+		global("lb:diff", "lb:diff", "LoadBalancer_Diff"),
 
 		////////////////////////////////////////////////////////////////////////
 		// logger
@@ -267,6 +325,18 @@ func init() {
 			required("host"), required("app"), required("process"),
 		),
 
+		////////////////////////////////////////////////////////////////////////
+		// limits:*
+		reader("limits", "limits:list", "Limits_List",
+			required("app"),
+		),
+		writer("limits:set", "limits:set", "Limits_Set",
+			required("app"), required("process"), mapped("args"),
+		),
+		writer("limits:remove", "limits:remove", "Limits_Remove",
+			required("app"), list("processTypes"),
+		),
+
 		////////////////////////////////////////////////////////////////////////
 		// logs:*
 		reader("logs", "logs:get", "Logs_Get",
@@ -297,6 +367,33 @@ func init() {
 		reader("nodes:remove", "nodes:remove", "Node_Remove",
 			list("addresses"),
 		),
+		reader("nodes:label", "nodes:label", "Node_Label",
+			required("host"), mapped("labels"),
+		),
+		reader("nodes:ports", "nodes:ports", "Node_Ports",
+			required("host"),
+		),
+		reader("nodes:cordon", "nodes:cordon", "Node_Cordon",
+			list("addresses"),
+		),
+		reader("nodes:uncordon", "nodes:uncordon", "Node_Uncordon",
+			list("addresses"),
+		),
+		reader("nodes:drain", "nodes:drain", "Node_Drain",
+			required("host"),
+		),
+
+		////////////////////////////////////////////////////////////////////////
+		// reconcile:*
+		writer("reconcile:on", "reconcile:on", "Reconcile_On",
+			required("app"),
+		),
+		writer("reconcile:off", "reconcile:off", "Reconcile_Off",
+			required("app"),
+		),
+		reader("reconcile", "reconcile:status", "Reconcile_Status",
+			required("app"),
+		),
 
 		////////////////////////////////////////////////////////////////////////
 		// pre/post-receive
@@ -319,6 +416,15 @@ func init() {
 			required("app"),
 		),
 
+		////////////////////////////////////////////////////////////////////////
+		// placement:*
+		reader("placement", "placement:get", "Placement_Get",
+			required("app"),
+		),
+		writer("placement:set", "placement:set", "Placement_Set",
+			required("app"), required("strategy"),
+		),
+
 		////////////////////////////////////////////////////////////////////////
 		// ps:*
 		reader("ps", "ps:list", "Ps_List",
@@ -328,7 +434,7 @@ func init() {
 			required("app"), mapped("args"),
 		),
 		writer("ps:restart", "ps:restart", "Ps_Restart",
-			required("app"), list("processTypes"),
+			required("app"), optional("rolling", ""), optional("interval", ""), list("names"),
 		),
 		writer("ps:start", "ps:start", "Ps_Start",
 			required("app"), list("processTypes"),
@@ -336,6 +442,12 @@ func init() {
 		reader("ps:status", "ps:status", "Ps_Status",
 			required("app"), list("processTypes"),
 		),
+		reader("ps:stats", "ps:stats", "Ps_Stats",
+			required("app"),
+		),
+		reader("ps:exec", "exec", "Ps_Exec",
+			required("app"), required("dyno"), optional("tty", ""), list("args"),
+		),
 		writer("ps:stop", "ps:stop", "Ps_Stop",
 			required("app"), list("processTypes"),
 		),
@@ -193,6 +193,20 @@ func (server *Server) Apps_Destroy(conn net.Conn, applicationName string, force
 			return err
 		}
 
+		fmt.Fprint(dimLogger, "Deleting app deploy logs\n")
+		if err := DeleteDeployLogs(applicationName); err != nil {
+			return err
+		}
+		if err := DeleteDeployState(applicationName); err != nil {
+			return err
+		}
+		if err := DeleteAutoscaleEvents(applicationName); err != nil {
+			return err
+		}
+		if err := DeleteRunRecords(applicationName); err != nil {
+			return err
+		}
+
 		return Send(conn, Message{Log, "Application destroyed\n"})
 	})
 }
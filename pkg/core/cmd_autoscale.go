@@ -0,0 +1,90 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+const autoscaleShowEvents = 20 // Number of recent autoscaler decisions shown by autoscale:show.
+
+func (server *Server) Autoscale_Show(conn net.Conn, applicationName string) error {
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+		titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+		fmt.Fprintf(titleLogger, "=== Autoscale policies for %v\n", applicationName)
+		for _, process := range app.autoscaledProcesses() {
+			fmt.Fprintf(dimLogger, "%v: %v (currently %v dynos)\n", process, app.Autoscale[process], app.Processes[process])
+		}
+
+		events, err := ListAutoscaleEvents(applicationName)
+		if err != nil {
+			return err
+		}
+		if len(events) > autoscaleShowEvents {
+			events = events[len(events)-autoscaleShowEvents:]
+		}
+		fmt.Fprintf(titleLogger, "\n=== Recent autoscale decisions\n")
+		for _, event := range events {
+			line := fmt.Sprintf("%v %v %v %v->%v: %v", event.Ts.Format(time.RFC3339), event.Process, event.Action, event.From, event.To, event.Reason)
+			if len(event.Error) > 0 {
+				line += fmt.Sprintf(" (error: %v)", event.Error)
+			}
+			fmt.Fprintf(dimLogger, "%v\n", line)
+		}
+		return nil
+	})
+}
+
+// e.g. autoscale:set -amyApp web min=2 max=10 rate=50 queue=10 memory=512MB cooldown=5m
+func (server *Server) Autoscale_Set(conn net.Conn, applicationName string, processType string, args map[string]string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("one or more settings are required, e.g. min=2 max=10 rate=50 queue=10 memory=512MB cooldown=5m")
+	}
+	return server.WithPersistentApplication(applicationName, func(app *Application, cfg *Config) error {
+		if _, ok := app.Processes[processType]; !ok {
+			return fmt.Errorf("unrecognized process type: %v", processType)
+		}
+		policy, ok := app.Autoscale[processType]
+		if !ok {
+			// Start out from the current scale.
+			policy = AutoscalePolicy{Min: app.Processes[processType], Max: app.Processes[processType]}
+		}
+		policy, err := ParseAutoscalePolicy(policy, args)
+		if err != nil {
+			return err
+		}
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+		if processType != "web" && (policy.TargetRequestRate > 0 || policy.TargetQueueDepth > 0) {
+			return fmt.Errorf("request rate and queue depth targets are only available for the web process type")
+		}
+		if app.Autoscale == nil {
+			app.Autoscale = map[string]AutoscalePolicy{}
+		}
+		app.Autoscale[processType] = policy
+		titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+		fmt.Fprintf(titleLogger, "=== Setting autoscale policy for %v %v\n", applicationName, processType)
+		fmt.Fprintf(dimLogger, "%v: %v\n", processType, policy)
+		return nil
+	})
+}
+
+func (server *Server) Autoscale_Disable(conn net.Conn, applicationName string, processTypes []string) error {
+	return server.WithPersistentApplication(applicationName, func(app *Application, cfg *Config) error {
+		if len(processTypes) == 0 {
+			processTypes = app.autoscaledProcesses()
+		}
+		titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+		for _, processType := range processTypes {
+			fmt.Fprintf(titleLogger, "=== Disabling autoscaling for %v %v\n", applicationName, processType)
+			if _, ok := app.Autoscale[processType]; !ok {
+				fmt.Fprintf(dimLogger, "Autoscaling was not enabled for %v\n", processType)
+				continue
+			}
+			delete(app.Autoscale, processType)
+			fmt.Fprintf(dimLogger, "%v will remain at %v dynos\n", processType, app.Processes[processType])
+		}
+		return nil
+	})
+}
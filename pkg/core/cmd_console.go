@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os/exec"
 	"regexp"
 
 	"github.com/kr/pty"
@@ -63,31 +64,35 @@ func (server *Server) Console(conn net.Conn, applicationName string, args []stri
 			e.DestroyContainer(containerName)
 		}()
 
-		// Setup a pseudo terminal.
-		c := e.AttachContainer(containerName, args...)
-		f, err := pty.Start(c)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
+		return attachPty(conn, e.AttachContainer(containerName, args...))
+	})
+}
 
-		ch := make(chan error, 1)
+// attachPty runs a command in a pseudo terminal connected to a hijacked
+// connection, until either end completes.
+func attachPty(conn net.Conn, c *exec.Cmd) error {
+	f, err := pty.Start(c)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-		// Read the output.
-		go func() {
-			_, err := io.Copy(conn, f)
-			ch <- err
-		}()
-		// Send the input.
-		go func() {
-			_, err := io.Copy(f, conn)
-			ch <- err
-		}()
+	ch := make(chan error, 1)
 
-		// Wait for either end to complete
-		<-ch
-		return nil
-	})
+	// Read the output.
+	go func() {
+		_, err := io.Copy(conn, f)
+		ch <- err
+	}()
+	// Send the input.
+	go func() {
+		_, err := io.Copy(f, conn)
+		ch <- err
+	}()
+
+	// Wait for either end to complete
+	<-ch
+	return nil
 }
 
 func RandomAlphaNumericString(numSourceBytes int) string {
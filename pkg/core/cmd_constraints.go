@@ -0,0 +1,121 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+func (server *Server) Constraints_List(conn net.Conn, applicationName string) error {
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+		titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+		fmt.Fprintf(titleLogger, "=== Placement constraints for %v\n", applicationName)
+		for _, constraint := range app.Constraints {
+			fmt.Fprintf(dimLogger, "%v\n", constraint)
+		}
+		return nil
+	})
+}
+
+// e.g. constraints:add -amyApp worker requires disk=ssd, or for all process
+// types: constraints:add -amyApp avoids zone=a
+func (server *Server) Constraints_Add(conn net.Conn, applicationName string, args []string, labels map[string]string) error {
+	constraints, err := parseConstraintsArgs(args, labels)
+	if err != nil {
+		return err
+	}
+	return server.WithPersistentApplication(applicationName, func(app *Application, cfg *Config) error {
+		for _, constraint := range constraints {
+			if len(constraint.Process) > 0 && !app.hasProcess(constraint.Process) {
+				return fmt.Errorf("unrecognized process type: %v", constraint.Process)
+			}
+		}
+		titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+		fmt.Fprintf(titleLogger, "=== Adding placement constraints for %v\n", applicationName)
+	OUTER:
+		for _, constraint := range constraints {
+			for _, existing := range app.Constraints {
+				if existing == constraint {
+					fmt.Fprintf(dimLogger, "Constraint already exists: %v\n", constraint)
+					continue OUTER
+				}
+			}
+			app.Constraints = append(app.Constraints, constraint)
+			fmt.Fprintf(dimLogger, "Added constraint: %v\n", constraint)
+		}
+		// Warn up-front rather than waiting for the next deploy to fail.
+		for process, numDynos := range app.Processes {
+			if numDynos <= 0 {
+				continue
+			}
+			if _, err := eligibleNodes(cfg.Nodes, app.Constraints, process); err != nil {
+				fmt.Fprintf(dimLogger, "Warning: %s\n", err)
+			}
+		}
+		fmt.Fprintf(dimLogger, "Constraints apply to dynos started from now on, redeploy to apply to running dynos\n")
+		return nil
+	})
+}
+
+func (server *Server) Constraints_Remove(conn net.Conn, applicationName string, args []string, labels map[string]string) error {
+	constraints, err := parseConstraintsArgs(args, labels)
+	if err != nil {
+		return err
+	}
+	return server.WithPersistentApplication(applicationName, func(app *Application, cfg *Config) error {
+		titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+		fmt.Fprintf(titleLogger, "=== Removing placement constraints for %v\n", applicationName)
+		for _, constraint := range constraints {
+			var (
+				found = false
+				keep  = []PlacementConstraint{}
+			)
+			for _, existing := range app.Constraints {
+				if existing == constraint {
+					found = true
+				} else {
+					keep = append(keep, existing)
+				}
+			}
+			app.Constraints = keep
+			if found {
+				fmt.Fprintf(dimLogger, "Removed constraint: %v\n", constraint)
+			} else {
+				fmt.Fprintf(dimLogger, "Constraint not found: %v\n", constraint)
+			}
+		}
+		return nil
+	})
+}
+
+// parseConstraintsArgs parses `[process] requires|avoids key[=value]...'
+// arguments.  Labels with values arrive separately as they are key=value
+// pairs.
+func parseConstraintsArgs(args []string, labels map[string]string) ([]PlacementConstraint, error) {
+	process := ""
+	if len(args) > 0 && args[0] != ConstraintRequires && args[0] != ConstraintAvoids {
+		process, args = args[0], args[1:]
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing constraint operator, must be one of: %v, %v", ConstraintRequires, ConstraintAvoids)
+	}
+	op, keys := args[0], args[1:]
+	exprs := append([]string{}, keys...)
+	for _, key := range sortedStringKeys(labels) {
+		exprs = append(exprs, key+"="+labels[key])
+	}
+	if len(exprs) == 0 {
+		return nil, fmt.Errorf("one or more node labels are required, e.g. disk=ssd")
+	}
+	sort.Strings(exprs)
+
+	constraints := []PlacementConstraint{}
+	for _, expr := range exprs {
+		constraint, err := ParsePlacementConstraint(process, op, expr)
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, constraint)
+	}
+	return constraints, nil
+}
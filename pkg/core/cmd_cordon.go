@@ -0,0 +1,181 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/gigawattio/errorlib"
+)
+
+// e.g. nodes:cordon node1.example.com node2.example.com
+func (server *Server) Node_Cordon(conn net.Conn, addresses []string) error {
+	return server.setNodesCordoned(conn, addresses, true)
+}
+
+// e.g. nodes:uncordon node1.example.com
+func (server *Server) Node_Uncordon(conn net.Conn, addresses []string) error {
+	return server.setNodesCordoned(conn, addresses, false)
+}
+
+func (server *Server) setNodesCordoned(conn net.Conn, addresses []string, cordoned bool) error {
+	if len(addresses) == 0 {
+		return fmt.Errorf("one or more node addresses are required")
+	}
+	addresses = replaceLocalhostWithSystemIp(&addresses)
+
+	titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+
+	if cordoned {
+		fmt.Fprintf(titleLogger, "=== Cordoning Nodes\n\n")
+	} else {
+		fmt.Fprintf(titleLogger, "=== Uncordoning Nodes\n\n")
+	}
+
+	return server.WithPersistentConfig(func(cfg *Config) error {
+		for _, address := range addresses {
+			node := findNode(cfg.Nodes, address)
+			if node == nil {
+				return fmt.Errorf("unrecognized node: %v", address)
+			}
+			node.Cordoned = cordoned
+			if cordoned {
+				fmt.Fprintf(dimLogger, "Cordoned node: %v (no new dynos will be placed on it)\n", node.Host)
+			} else {
+				fmt.Fprintf(dimLogger, "Uncordoned node: %v\n", node.Host)
+			}
+		}
+		return nil
+	})
+}
+
+// Node_Drain cordons a node, starts replacements elsewhere for the dynos
+// running on it, drains the node's dynos from the load-balancers, and then
+// shuts them down.
+//
+// e.g. nodes:drain node1.example.com
+func (server *Server) Node_Drain(conn net.Conn, address string) error {
+	address = replaceLocalhostWithSystemIp(&[]string{address})[0]
+
+	titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+
+	fmt.Fprintf(titleLogger, "=== Draining Node %v\n\n", address)
+
+	var node *Node
+	err := server.WithPersistentConfig(func(cfg *Config) error {
+		if node = findNode(cfg.Nodes, address); node == nil {
+			return fmt.Errorf("unrecognized node: %v", address)
+		}
+		node.Cordoned = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(dimLogger, "Cordoned node: %v (no new dynos will be placed on it)\n", node.Host)
+
+	nodeStatus := server.getNodeStatus(node)
+	if nodeStatus.Err != nil {
+		return fmt.Errorf("unable to determine the dynos running on node %v: %s", node.Host, nodeStatus.Err)
+	}
+	dynos, err := NodeStatusToDynos(&nodeStatus)
+	if err != nil {
+		return err
+	}
+	if len(dynos) == 0 {
+		fmt.Fprintf(titleLogger, "No dynos are running on node %v, drain complete\n", node.Host)
+		return nil
+	}
+
+	cfg, err := server.getConfig(true)
+	if err != nil {
+		return err
+	}
+
+	// Start replacements for the node's dynos which are running the current
+	// version of an app.
+	errs := []error{}
+	for _, app := range cfg.Applications {
+		replacements := drainReplacements(app, dynos)
+		if len(replacements) == 0 {
+			continue
+		}
+		fmt.Fprintf(titleLogger, "Starting replacement dynos for app %v: %v\n", app.Name, replacements)
+		if err := server.startReplacementDynos(dimLogger, app.Name, replacements); err != nil {
+			errs = append(errs, fmt.Errorf("starting replacement dynos for app %v: %s", app.Name, err))
+		}
+	}
+	if err := errorlib.Merge(errs); err != nil {
+		fmt.Fprintf(titleLogger, "Aborting drain, the node's dynos have been left running\n")
+		return err
+	}
+
+	e := &Executor{Logger: dimLogger}
+
+	// The node's web dynos are drained together, so the longest of the apps'
+	// drain timeouts applies.
+	var (
+		drainTimeout = DEFAULT_DRAIN_TIMEOUT_SECONDS * time.Second
+		gracePeriods = map[string]time.Duration{}
+	)
+	for _, app := range cfg.Applications {
+		if timeout := app.DrainTimeout(); timeout > drainTimeout {
+			drainTimeout = timeout
+		}
+		gracePeriods[app.Name] = app.ShutdownGracePeriod()
+	}
+
+	fmt.Fprintf(titleLogger, "Removing node %v from the load-balancers\n", node.Host)
+	server.drainDynos(dimLogger, cfg.LoadBalancersUsing(LBDriverHAProxy), dynos, drainTimeout)
+	if err := server.SyncLoadBalancers(e, []Dyno{}, dynos); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(titleLogger, "Shutting down %v dyno(s) on node %v\n", len(dynos), node.Host)
+	for _, dyno := range dynos {
+		gracePeriod, ok := gracePeriods[dyno.Application]
+		if !ok {
+			gracePeriod = DEFAULT_SHUTDOWN_GRACE_PERIOD_SECONDS * time.Second
+		}
+		if err := dyno.Shutdown(e, gracePeriod); err != nil {
+			errs = append(errs, fmt.Errorf("shutting down dyno %v: %s", dyno.Container, err))
+		}
+	}
+	if err := errorlib.Merge(errs); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(titleLogger, "Drain of node %v complete, use nodes:remove to remove it or nodes:uncordon to return it to service\n", node.Host)
+	return nil
+}
+
+// drainReplacements returns the number of replacement dynos needed per app
+// process type for the dynos being drained.  Only dynos running the app's
+// latest deployed version are replaced.
+func drainReplacements(app *Application, dynos []Dyno) map[string]int {
+	replacements := map[string]int{}
+	for _, dyno := range dynos {
+		if dyno.Application != app.Name || dyno.Version != app.LastDeploy || dyno.State != DYNO_STATE_RUNNING {
+			continue
+		}
+		for process, numDynos := range app.Processes {
+			if numDynos > 0 && sameProcess(process, dyno.Process) {
+				replacements[process]++
+				break
+			}
+		}
+	}
+	return replacements
+}
+
+// startReplacementDynos starts additional dynos for an app at its latest
+// version without altering its configured scale.
+func (server *Server) startReplacementDynos(logger io.Writer, applicationName string, replacements map[string]int) error {
+	if !tryLockApp(applicationName) {
+		return fmt.Errorf("app %v is busy, try again once the running operation has finished", applicationName)
+	}
+	defer unlockApp(applicationName)
+
+	return server.startAdditionalDynos(logger, applicationName, replacements)
+}
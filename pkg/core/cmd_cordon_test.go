@@ -0,0 +1,39 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDrainReplacements(t *testing.T) {
+	app := &Application{
+		Name:       "myapp",
+		LastDeploy: "v4",
+		Processes:  map[string]int{"web": 2, "background_worker": 1},
+	}
+	dynos := []Dyno{}
+	for _, container := range []string{
+		"myapp-v4-web-10001-running",
+		"myapp-v4-web-10002-running",
+		"myapp-v4-backgroundWorker-10003-running",
+		"myapp-v3-web-10004-running",  // Old version, not replaced.
+		"other-v4-web-10005-running",  // Other app.
+		"myapp-v4-cron-10006-running", // No longer scaled.
+	} {
+		dyno, err := ContainerToDyno("node-a", container)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dynos = append(dynos, dyno)
+	}
+
+	expected := map[string]int{"web": 2, "background_worker": 1}
+	if actual := drainReplacements(app, dynos); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected replacements=%v but actual=%v", expected, actual)
+	}
+
+	nodes := []*Node{{Host: "node-a", Cordoned: true}, {Host: "node-b"}}
+	if schedulable := schedulableNodes(nodes); len(schedulable) != 1 || schedulable[0].Host != "node-b" {
+		t.Errorf("Expected only node-b to be schedulable but actual=%v", schedulable)
+	}
+}
@@ -14,7 +14,6 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"text/template"
 	"time"
 
@@ -33,7 +32,9 @@ type DeploymentOptions struct {
 	Config      *Config
 	Revision    string
 	Version     string
-	ScalingOnly bool // Flag to indicate whether this is a new release or a scaling activity.
+	ScalingOnly bool   // Flag to indicate whether this is a new release or a scaling activity.
+	Archive     string // Path to an uploaded source archive to build from instead of git.
+	Resumable   bool   // Flag to indicate whether step progress should be persisted for deploy:resume.
 }
 
 type Deployment struct {
@@ -44,10 +45,14 @@ type Deployment struct {
 	Config           *Config
 	Revision         string
 	Version          string
-	ScalingOnly      bool // Flag to indicate whether this is a new release or a scaling activity.
+	ScalingOnly      bool   // Flag to indicate whether this is a new release or a scaling activity.
+	Archive          string // Path to an uploaded source archive to build from instead of git.
+	Resumable        bool   // Flag to indicate whether step progress should be persisted for deploy:resume.
 	exe              *Executor
 	ImageFingerprint string
-	err              error
+	state            *DeployState
+	manifest         *Manifest
+	manifestLoaded   bool
 }
 
 func NewDeployment(options DeploymentOptions) *Deployment {
@@ -62,9 +67,16 @@ func NewDeployment(options DeploymentOptions) *Deployment {
 			Revision:    options.Revision,
 			Version:     options.Version,
 			ScalingOnly: options.ScalingOnly,
+			Archive:     options.Archive,
+			Resumable:   options.Resumable,
 			exe: &Executor{
 				Logger: dimLogger,
 			},
+			state: &DeployState{
+				Application: options.Application.Name,
+				Version:     options.Version,
+				Revision:    options.Revision,
+			},
 		}
 	)
 	return d
@@ -112,34 +124,6 @@ var deployLock = DeployLock{
 	numFinished: 0,
 }
 
-// applySSHPrivateKeyFile is used to enable private github repo access.
-func (d *Deployment) applySSHPrivateKeyFile() error {
-	if d.Application.SSHPrivateKey != nil {
-		if err := os.Mkdir(d.Application.SSHDir(), os.FileMode(int(0700))); err != nil {
-			return err
-		}
-		if err := ioutil.WriteFile(d.Application.SSHPrivateKeyFilePath(), []byte(*d.Application.SSHPrivateKey), 0500); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-// Removes To be invoked after dependency retrieval.
-func (d *Deployment) removeSshPrivateKeyFile() error {
-	path := d.Application.SSHPrivateKeyFilePath()
-	exists, err := PathExists(path)
-	if err != nil {
-		return fmt.Errorf("checking if path=%v exists: %s", path, err)
-	}
-	if d.Application.SSHPrivateKey != nil && exists {
-		if err := os.Remove(path); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 func (d *Deployment) createContainer() (err error) {
 	titleLogger := NewFormatter(d.Logger, GREEN)
 
@@ -150,17 +134,13 @@ func (d *Deployment) createContainer() (err error) {
 	} else if !exists {
 		fmt.Fprintf(titleLogger, "Creating container\n")
 		// Clone the base application.
-		if d.err = d.initContainer(); d.err != nil {
-			err = d.err
+		if err = d.initContainer(); err != nil {
 			return
 		}
 	} else {
 		fmt.Fprintf(titleLogger, "App image container already exists\n")
 	}
 
-	// if err = d.exe.MountContainerFS(d.Application.Name); err != nil {
-	// 	return
-	// }
 	defer func() {
 		// Housekeeping.
 		running, checkErr := d.exe.ContainerRunning(d.Application.Name)
@@ -180,97 +160,6 @@ func (d *Deployment) createContainer() (err error) {
 		}
 	}()
 
-	/*
-		if d.err = d.b64FileIntoContainer(EXE, oslib.OsPath(string(os.PathSeparator)+"app", BINARY), "755"); d.err != nil {
-			err = d.err
-			return
-		}
-
-		if d.err = d.gitClone(); d.err != nil {
-			err = d.err
-			return
-		}
-
-		// // TEMPORARILY DISABLED
-		// // TODO: RESTORE THIS FUNCTIONALITY!
-		// // Add the public ssh key for submodule (and later dependency) access.
-		// if d.err = d.applySSHPrivateKeyFile(); d.err != nil {
-		// 	err = d.err
-		// 	return
-		// }
-
-		if d.err = d.containerCodeInit(); d.err != nil {
-			err = d.err
-			return
-		}
-
-		if d.err = d.sendPreStartScript(); d.err != nil {
-			err = d.err
-			return
-		}*/
-
-	// {
-	// 	path := oslib.OsPath(string(os.PathSeparator)+"tmp", "init-"+d.Application.Name+".sh")
-	// 	var initFile *os.File
-	// 	if initFile, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(int(0755))); err != nil {
-	// 		err = fmt.Errorf("opening app code init file %q: %s", path)
-	// 		return
-	// 	}
-	// 	containerCodeTpl.Execute(wr, data)
-
-	// 	var (
-	// 		cmd = exec.Command("sudo", "lxc", "exec", d.Application.Name, "--",
-	// 			fmt.Sprintf("/bin/bash -c 'set -o errexit ; tee %[1]v && chmod 555 %[1]v'", oslib.OsPath(string(os.PathSeparator)+"app", "init.sh")),
-	// 		)
-	// 		// r, w   = io.Pipe()
-	// 		outBuf = &bytes.Buffer{}
-	// 		errBuf = &bytes.Buffer{}
-	// 	)
-	// 	if d.err = containerCodeTpl.Execute(w, d); d.err != nil {
-	// 		err = d.err
-	// 		return
-	// 	}
-	// 	cmd.Stdin = r
-	// 	cmd.Stdout = outBuf
-	// 	cmd.Stderr = errBuf
-	// 	if d.err = cmd.Run(); d.err != nil {
-	// 		log.WithField("app", d.Application.Name).Errorf("re")
-	// 		err = d.err
-	// 		return
-	// 	}
-	// }
-
-	// // Checkout the given revision.
-	// if d.err = d.exe.BashCmdf("sudo lxc exec %v -- /bin/bash -c 'cd %v && git checkout -q -f %v", d.Application.Name, d.Application.SrcDir(), d.Revision); d.err != nil {
-	// 	err = d.err
-	// 	return
-	// }
-
-	// // Convert references to submodules to be read-only.
-	// {
-	// 	cmdStr := fmt.Sprintf(`test -f '%[1]v/.gitmodules' && echo 'git: converting submodule refs to be read-only' && sed -i 's,git@github.com:,git://github.com/,g' '%[1]v/.gitmodules' || echo 'git: project does not appear to have any submodules'`, d.Application.SrcDir())
-	// 	if d.err = d.exe.BashCmd(cmdStr); d.err != nil {
-	// 		err = d.err
-	// 		return
-	// 	}
-	// }
-
-	// // Update the submodules.
-	// if d.err = d.exe.BashCmd("cd " + d.Application.SrcDir() + " && git submodule init && git submodule update"); d.err != nil {
-	// 	err = d.err
-	// 	return
-	// }
-
-	// // Clear out and remove all git files from the container; they are unnecessary
-	// // from this point forward.
-	// // NB: If this command fails, don't abort anything, just log the error.
-	// {
-	// 	cmdStr := fmt.Sprintf(`find %v . -regex '^.*\.git\(ignore\|modules\|attributes\)?$' -exec rm -rf {} \; 1>/dev/null 2>/dev/null`, d.Application.SrcDir())
-	// 	if ignorableErr := d.exe.BashCmd(cmdStr); ignorableErr != nil {
-	// 		fmt.Fprintf(dimLogger, ".git* cleanup failed: %v\n", ignorableErr)
-	// 	}
-	// }
-
 	return nil
 }
 
@@ -420,46 +309,6 @@ func (d *Deployment) b64FileIntoContainer(src string, dst string, permissions st
 		return fmt.Errorf("sending %v -> %v via b64 into container: %s (out=%v)", src, dst, err, string(out))
 	}
 	return nil
-
-	// NB: This way of doing it makes things hang, isn't yet working right.
-	// // Copy the ShipBuilder binary into the container.
-	// var (
-	// 	cmd = exec.Command("sudo", "lxc", "exec", d.Application.Name, "--",
-	// 		fmt.Sprintf("/bin/bash -c 'set -o errexit ; base64 -d > %[1]v && chmod %[2]v %[1]v'", oslib.OsPath(string(os.PathSeparator)+"app", BINARY), permissions),
-	// 	)
-	// 	r, w = io.Pipe()
-	// 	enc  = base64.NewEncoder(base64.StdEncoding, w)
-	// 	file *os.File
-	// 	out  []byte
-	// )
-
-	// cmd.Stdin = r
-
-	// defer enc.Close()
-
-	// if file, err = os.Open(EXE); d.err != nil {
-	// 	err = fmt.Errorf("opening file %q: %s", EXE, d.err)
-	// 	return
-	// }
-	// defer func() {
-	// 	if closeErr := file.Close(); closeErr != nil {
-	// 		if err == nil {
-	// 			err = closeErr
-	// 		} else {
-	// 			log.WithField("app", d.Application.Name).Errorf("closing file %q: %s (pre-existing error=%s", EXE, closeErr, err)
-	// 		}
-	// 	}
-	// }()
-
-	// if _, err = io.Copy(enc, file); err != nil {
-	// 	return
-	// }
-
-	// if out, err = cmd.CombinedOutput(); err != nil {
-	// 	err = fmt.Errorf("sending SB binary: %s (out=%v)", err, string(out))
-	// 	return
-	// }
-	// return
 }
 
 func (d *Deployment) prepareEnvironmentVariables() (err error) {
@@ -676,19 +525,6 @@ func (d *Deployment) prepareDisabledServices() error {
 	if err := d.lxcExec("update-rc.d ondemand disable"); err != nil {
 		return err
 	}
-	// NB: No longer needed in ubuntu 16.04.
-	// // Disable `ntpdate` client from being triggered when networking comes up.
-	// if err := d.lxcExec("chmod a-x /etc/network/if-up.d/ntpdate", d.Application.Name); err != nil {
-	// 	return err
-	// }
-
-	// TODO: COME BACK TO THIS LATER, IT BREAKS B/C NETWORKING SERVICE NOT YET STARTED.
-	// if len(PurgePackages) > 0 {
-	// 	if err := d.lxcExecf("apt-get purge -y --no-upgrade %v", strings.Join(PurgePackages, " ")); err != nil {
-	// 		return err
-	// 	}
-	// }
-
 	if len(DisableServices) > 0 {
 		// Disable auto-start for unnecessary services, such as:
 		// SSH, rsyslog, cron, tty1-6, and udev.
@@ -714,63 +550,56 @@ func (d *Deployment) build() (err error) {
 		log.WithField("app", d.Application.Name).WithField("err", stopErr).Errorf("Problem stopping container (this can likely be ignored)")
 	}
 
-	// if d.err = d.exe.MountContainerFS(d.Application.Name); d.err != nil {
-	// 	err = d.err
-	// 	return
-	// }
-
-	if d.err = d.exe.StartContainer(d.Application.Name); d.err != nil {
-		err = d.err
+	if err = d.exe.StartContainer(d.Application.Name); err != nil {
 		return
 	}
 
-	if d.err = d.lxcExec("bash -c 'rm -rf /app/src /app/env ; rc=$? ; echo rc=${rc} ; find /app/src -exec stat {} + ; mkdir -p /app /app/env ; exit ${rc}'"); d.err != nil {
-		// if d.err = d.lxcExec("bash -c 'rm -rf /app/src /app/env && mkdir -p /app /app/env'"); d.err != nil {
-		err = d.err
+	if err = d.lxcExec("bash -c 'rm -rf /app/src /app/env ; rc=$? ; echo rc=${rc} ; find /app/src -exec stat {} + ; mkdir -p /app /app/env ; exit ${rc}'"); err != nil {
 		return
 	}
 
 	log.Debugf("SENDING SB BIN...")
-	if d.err = d.b64FileIntoContainer(EXE, oslib.OsPath(string(os.PathSeparator)+"app", BINARY), "755"); d.err != nil {
-		err = d.err
+	if err = d.b64FileIntoContainer(EXE, oslib.OsPath(string(os.PathSeparator)+"app", BINARY), "755"); err != nil {
 		return
 	}
 
-	if d.err = d.gitClone(); d.err != nil {
-		err = d.err
+	// Defer removal of the ssh private key file.
+	defer func() {
+		if rmErr := d.removeSSHPrivateKeyFile(); rmErr != nil {
+			if err == nil {
+				err = rmErr
+			} else {
+				log.Warnf("found pre-existing err=%q and encountered a problem removing ssh private key for app=%q: %s", err, d.Application.Name, rmErr)
+			}
+		}
+	}()
+
+	// Add the private ssh key for submodule and dependency access.
+	if err = d.applySSHPrivateKeyFile(); err != nil {
 		return
 	}
 
-	// // TEMPORARILY DISABLED
-	// // TODO: RESTORE THIS FUNCTIONALITY!
-	// // Add the public ssh key for submodule (and later dependency) access.
-	// if d.err = d.applySSHPrivateKeyFile(); d.err != nil {
-	// 	err = d.err
-	// 	return
-	// }
+	if len(d.Archive) > 0 {
+		if err = d.archiveExtract(); err != nil {
+			return
+		}
+	} else {
+		if err = d.gitClone(); err != nil {
+			return
+		}
 
-	if d.err = d.containerCodeInit(); d.err != nil {
-		err = d.err
-		return
+		if err = d.containerCodeInit(); err != nil {
+			return
+		}
 	}
 
-	if d.err = d.Validate(); d.err != nil {
-		err = d.err
+	if err = d.Validate(); err != nil {
 		return
 	}
 
-	// // TEMPORARILY DISABLED
-	// // TODO: RESTORE THIS FUNCTIONALITY!
-	// // Defer removal of the ssh private key file.
-	// defer func() {
-	// 	if rmErr := d.removeSshPrivateKeyFile(); rmErr != nil {
-	// 		if err == nil {
-	// 			err = rmErr
-	// 		} else {
-	// 			log.Warnf("found pre-existing err=%q and encountered a problem removing ssh private key for app=%q: %s", err, d.Application.Name, rmErr)
-	// 		}
-	// 	}
-	// }()
+	if err = d.applyManifestFormation(); err != nil {
+		return
+	}
 
 	prepErr := func() error {
 		if err := d.prepareShellEnvironment(); err != nil {
@@ -792,7 +621,6 @@ func (d *Deployment) build() (err error) {
 	}()
 	if prepErr != nil {
 		err = prepErr
-		d.err = err
 		if err := d.exe.StopContainer(d.Application.Name); err != nil && err != ErrContainerNotFound {
 			log.WithField("app", d.Application.Name).Errorf("Unexpected error stopping container after prep failure: %s", err)
 		}
@@ -806,46 +634,39 @@ func (d *Deployment) build() (err error) {
 	}
 
 	// Create app system service.
-	if d.err = d.renderTemplateIntoContainer(systemdAppTpl, oslib.OsPath(string(os.PathSeparator)+"etc", "systemd", "system", "app.service"), "644"); d.err != nil {
-		d.err = fmt.Errorf("rendering app.service systemd template into container: %s", d.err)
-		err = d.err
+	if err = d.renderTemplateIntoContainer(systemdAppTpl, oslib.OsPath(string(os.PathSeparator)+"etc", "systemd", "system", "app.service"), "644"); err != nil {
+		err = fmt.Errorf("rendering app.service systemd template into container: %s", err)
 		return
 	}
 
 	// Enable app system service.
-	if d.err = d.lxcExec("systemctl enable app"); d.err != nil {
-		d.err = fmt.Errorf("enabling app system service: %s", err)
-		err = d.err
+	if err = d.lxcExec("systemctl enable app"); err != nil {
+		err = fmt.Errorf("enabling app system service: %s", err)
 		return
 	}
 
-	if d.err = d.sendPreStartScript(); d.err != nil {
-		err = d.err
+	if err = d.sendPreStartScript(); err != nil {
 		return
 	}
 
 	var bp domain.Buildpack
-	if bp, d.err = d.Server.BuildpacksProvider.New(d.Application.BuildPack); d.err != nil {
-		err = d.err
+	if bp, err = d.Server.BuildpacksProvider.New(d.Application.BuildPack); err != nil {
 		return
 	}
 
 	var tpl *template.Template
-	if tpl, d.err = template.New(d.Application.BuildPack).Parse(bp.PreHook()); d.err != nil {
-		d.err = fmt.Errorf("compiling pre-hook template: %s", d.err)
-		err = d.err
+	if tpl, err = template.New(d.Application.BuildPack).Parse(bp.PreHook()); err != nil {
+		err = fmt.Errorf("compiling pre-hook template: %s", err)
 		return
 	}
 
-	if d.err = d.renderTemplateIntoContainer(tpl, oslib.OsPath(string(os.PathSeparator)+"app", "run"), "777"); d.err != nil {
-		d.err = fmt.Errorf("rendering /app/run template: %s", d.err)
-		err = d.err
+	if err = d.renderTemplateIntoContainer(tpl, oslib.OsPath(string(os.PathSeparator)+"app", "run"), "777"); err != nil {
+		err = fmt.Errorf("rendering /app/run template: %s", err)
 		return
 	}
 
 	// Resart container to trigger the build.
-	if d.err = d.exe.RestartContainer(d.Application.Name); d.err != nil {
-		err = d.err
+	if err = d.exe.RestartContainer(d.Application.Name); err != nil {
 		return
 	}
 
@@ -854,14 +675,12 @@ func (d *Deployment) build() (err error) {
 
 	r, err := cmd.StdoutPipe()
 	if err != nil {
-		d.err = fmt.Errorf("getting stdout monitor pipe: %s", err)
-		err = d.err
+		err = fmt.Errorf("getting stdout monitor pipe: %s", err)
 		return
 	}
 
 	if err = cmd.Start(); err != nil {
-		d.err = fmt.Errorf("monitoring /app/out: %s", err)
-		err = d.err
+		err = fmt.Errorf("monitoring /app/out: %s", err)
 		return
 	}
 	defer func() {
@@ -870,8 +689,7 @@ func (d *Deployment) build() (err error) {
 				log.Errorf("Problem killing process pid=%v: %s", cmd.Process.Pid, killErr)
 				return
 			}
-			d.err = fmt.Errorf("killing out monitor pid=%v: %s", cmd.Process.Pid, killErr)
-			err = d.err
+			err = fmt.Errorf("killing out monitor pid=%v: %s", cmd.Process.Pid, killErr)
 		}
 	}()
 
@@ -913,41 +731,21 @@ func (d *Deployment) build() (err error) {
 		errCh <- waitErr
 	}()
 
-	// if err = d.exe.StartContainer(d.Application.Name); err != nil {
-	// 	cancelCh <- struct{}{}
-	// 	err = fmt.Errorf("starting container: %s", err)
-	// 	return
-	// }
-
 	fmt.Fprintf(titleLogger, "Waiting for container pre-hook\n")
 
 	select {
 	case err = <-errCh:
-		// if err == nil {
-		// 	prepErr := func() error {
-		// 		if err := d.prepareShellEnvironment(); err != nil {
-		// 			return err
-		// 		}
-		// 		if err := d.prepareAppFilePermissions(); err != nil {
-		// 			return err
-		// 		}
-		// 		if err := d.prepareDisabledServices(); err != nil {
-		// 			return err
-		// 		}
-		// 		return nil
-		// 	}()
-		// 	if prepErr != nil {
-		// 		err = prepErr
-		// 		d.exe.StopContainer(d.Application.Name)
-		// 		return
-		// 	}
-		// }
-
 	case <-time.After(waitDuration):
 		err = fmt.Errorf("timed out after %v", waitDuration)
 		cancelCh <- struct{}{}
 	}
 
+	// The key is only needed for the pre-hook, make sure nothing references it
+	// once the build is complete.
+	if rmErr := d.removeSSHPrivateKeyEnv(); rmErr != nil && err == nil {
+		err = rmErr
+	}
+
 	stopErr := d.exe.StopContainer(d.Application.Name)
 	if err != nil {
 		return
@@ -1153,7 +951,7 @@ func (d *Deployment) startDyno(dynoGenerator *DynoGenerator, process string) (Dy
 	go func() {
 		fmt.Fprint(logger, "Starting dyno")
 		mu.Lock()
-		err = e.Run("ssh", DEFAULT_NODE_USERNAME+"@"+dyno.Host, "sudo", "/tmp/postdeploy.py", dyno.Container)
+		err = e.Run("ssh", append([]string{DEFAULT_NODE_USERNAME + "@" + dyno.Host, "sudo", "/tmp/postdeploy.py", dyno.Container, "index=" + strconv.Itoa(dyno.Index)}, append(dyno.identityOptions(), d.dynoOptions(process)...)...)...)
 		mu.Unlock()
 		done <- struct{}{}
 	}()
@@ -1213,6 +1011,44 @@ func (d *Deployment) calculateDynosToDestroy() ([]Dyno, bool, error) {
 	return removeDynos, allocatingNewDynos, nil
 }
 
+// eligibleNodes returns the configured nodes satisfying the placement
+// constraints of at least one scaled process type.  An error is returned when
+// no node satisfies the constraints of a scaled process type.
+func (d *Deployment) eligibleNodes() ([]*Node, error) {
+	var (
+		eligible = map[string]bool{}
+		errs     = []error{}
+		nodes    = []*Node{}
+		// No new dynos are placed on cordoned nodes.
+		schedulable = schedulableNodes(d.Config.Nodes)
+	)
+	if len(schedulable) == 0 && len(d.Config.Nodes) > 0 {
+		return nil, fmt.Errorf("all nodes are cordoned, use nodes:uncordon to allow dynos to be placed on them")
+	}
+	for process, numDynos := range d.Application.Processes {
+		if numDynos <= 0 {
+			continue
+		}
+		processNodes, err := eligibleNodes(schedulable, d.Application.Constraints, process)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, node := range processNodes {
+			eligible[node.Host] = true
+		}
+	}
+	if err := errorlib.Merge(errs); err != nil {
+		return nil, err
+	}
+	for _, node := range schedulable {
+		if eligible[node.Host] {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
+
 func (d *Deployment) syncNodes() ([]*Node, error) {
 	type NodeSyncResult struct {
 		node *Node
@@ -1222,8 +1058,14 @@ func (d *Deployment) syncNodes() ([]*Node, error) {
 	d.exe.SuppressOutput = true
 	defer func() { d.exe.SuppressOutput = false }()
 
+	// Only sync to nodes which will be able to run some of the app's dynos.
+	nodes, err := d.eligibleNodes()
+	if err != nil {
+		return nil, err
+	}
+
 	syncStep := make(chan NodeSyncResult)
-	for _, node := range d.Config.Nodes {
+	for _, node := range nodes {
 		go func(node *Node) {
 			c := make(chan error, 1)
 			go func() { c <- d.syncNode(node) }()
@@ -1239,7 +1081,7 @@ func (d *Deployment) syncNodes() ([]*Node, error) {
 	availableNodes := []*Node{}
 
 	// Wait for all the syncs to finish or timeout, and collect available nodes.
-	for _ = range d.Config.Nodes {
+	for _ = range nodes {
 		syncResult := <-syncStep
 		if syncResult.err == nil {
 			availableNodes = append(availableNodes, syncResult.node)
@@ -1252,14 +1094,25 @@ func (d *Deployment) syncNodes() ([]*Node, error) {
 	return availableNodes, nil
 }
 
-func (d *Deployment) startDynos(availableNodes []*Node, titleLogger io.Writer) ([]Dyno, error) {
+func (d *Deployment) startDynos(availableNodes []*Node, titleLogger io.Writer, started func(Dyno)) ([]Dyno, error) {
 	// Now we've successfully sync'd and we have a list of nodes available to deploy to.
 	addDynos := []Dyno{}
 
-	dynoGenerator, err := d.Server.NewDynoGenerator(availableNodes, d.Application.Name, d.Version)
+	// Checks and resource limits declared in the manifest are applied by
+	// postdeploy.py.
+	if _, err := d.loadManifest(); err != nil {
+		return addDynos, err
+	}
+
+	dynoGenerator, err := d.Server.NewDynoGenerator(availableNodes, d.Application, d.Version)
 	if err != nil {
 		return addDynos, err
 	}
+	for process := range d.Application.Processes {
+		if limits := d.processLimits(process); limits.MemoryMb > 0 {
+			dynoGenerator.SetMemoryMb(process, limits.MemoryMb)
+		}
+	}
 
 	type StartResult struct {
 		dyno Dyno
@@ -1267,6 +1120,8 @@ func (d *Deployment) startDynos(availableNodes []*Node, titleLogger io.Writer) (
 	}
 	startedChannel := make(chan StartResult)
 
+	// pending counts the starts which haven't reported back yet.
+	pending := 0
 	startDynoWrapper := func(dynoGenerator *DynoGenerator, process string) {
 		dyno, err := d.startDyno(dynoGenerator, process)
 		startedChannel <- StartResult{
@@ -1281,6 +1136,7 @@ func (d *Deployment) startDynos(availableNodes []*Node, titleLogger io.Writer) (
 	for process, numDynos := range d.Application.Processes {
 		for i := 0; i < numDynos; i++ {
 			go startDynoWrapper(dynoGenerator, process)
+			pending++
 			numDesiredDynos++
 		}
 	}
@@ -1291,18 +1147,32 @@ func (d *Deployment) startDynos(availableNodes []*Node, titleLogger io.Writer) (
 		for {
 			select {
 			case result := <-startedChannel:
+				pending--
 				if result.err != nil {
 					// Then attempt to start it again.
 					fmt.Fprintf(titleLogger, "Retrying starting app dyno %v on host %v, failure reason: %v\n", result.dyno.Process, result.dyno.Host, result.err)
 					go startDynoWrapper(dynoGenerator, result.dyno.Process)
+					pending++
 				} else {
+					result.dyno.State = DYNO_STATE_RUNNING
 					addDynos = append(addDynos, result.dyno)
+					started(result.dyno)
 					if len(addDynos) == numDesiredDynos {
 						fmt.Fprintf(titleLogger, "Successfully started app on %v total dynos\n", numDesiredDynos)
 						break OUTER
 					}
 				}
 			case <-timeout:
+				// Dynos which finish starting after this are never routed to.
+				go func(pending int) {
+					logger := NewLogger(os.Stdout, "["+d.Application.Name+"] ")
+					for ; pending > 0; pending-- {
+						if result := <-startedChannel; result.err == nil {
+							result.dyno.State = DYNO_STATE_RUNNING
+							shutdownDynos(logger, []Dyno{result.dyno})
+						}
+					}
+				}(pending)
 				return addDynos, fmt.Errorf("Start operation timed out after %v seconds", DEPLOY_TIMEOUT_SECONDS)
 			}
 		}
@@ -1317,6 +1187,7 @@ func (d *Deployment) Validate() error {
 		d.validateProcfile(),
 		d.validatePackages(),
 		d.validatePPAs(),
+		d.validateManifest(),
 	}
 
 	if err := errorlib.Merge(errs); err != nil {
@@ -1329,7 +1200,7 @@ func (d *Deployment) Validate() error {
 // TODO: check for ignored errors.
 // TODO: check for instances of duplicate names (snake vs camel).
 func (d *Deployment) validateProcfile() error {
-	r, err := d.bareRepoContent("Procfile")
+	r, err := d.sourceContent("Procfile")
 	if err != nil {
 		if err == os.ErrNotExist {
 			return errors.New("missing required file: Procfile")
@@ -1353,7 +1224,7 @@ func (d *Deployment) validateProcfile() error {
 
 // validatePackages validates an apps '.packages' file, if one exists.
 func (d *Deployment) validatePackages() error {
-	r, err := d.bareRepoContent(".packages")
+	r, err := d.sourceContent(".packages")
 	if err != nil {
 		if err == os.ErrNotExist {
 			return nil
@@ -1377,7 +1248,7 @@ func (d *Deployment) validatePackages() error {
 
 // validatePPAs validates an apps '.ppas' file, if one exists.
 func (d *Deployment) validatePPAs() error {
-	r, err := d.bareRepoContent(".ppas")
+	r, err := d.sourceContent(".ppas")
 	if err != nil {
 		if err == os.ErrNotExist {
 			return nil
@@ -1435,108 +1306,44 @@ func allLinesMatch(r io.Reader, expr *regexp.Regexp, lineFilterFuncs ...func(lin
 	return nil
 }
 
-// bareRepoContent gets a file from the deployment apps' bare git repository.
+// bareRepoContent gets a file at the deployment's revision from the app's
+// bare git repository.
 func (d *Deployment) bareRepoContent(file string) (io.Reader, error) {
-	cmd := exec.Command("/bin/bash", "-c", fmt.Sprintf("set -o errexit ; cd %v/%v ; git show HEAD:%v", GIT_DIRECTORY, d.Application.Name, file))
-
-	r, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, fmt.Errorf("getting stdout pipe for app=%v %q content: %s", d.Application.Name, file, err)
-	}
-
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("starting command to check if app=%v git repo contains file %q: %s", d.Application.Name, file, err)
-	}
-
-	if err := cmd.Wait(); err != nil {
-		if exiterr, ok := err.(*exec.ExitError); ok {
-			// The program has exited with a non-zero exit status code.
-			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-				if status.ExitStatus() == 128 {
-					// File was not found.
-					return nil, os.ErrNotExist
-				}
-			}
-		}
-		return nil, fmt.Errorf("retrieving app=%v %q content: %s", d.Application.Name, file, err)
-	}
-
-	return r, nil
+	return d.Application.gitContent(d.Revision, file)
 }
 
-// Deploy and launch the container to nodes.
-func (d *Deployment) deploy() error {
-	if len(d.Application.Processes) == 0 {
-		return fmt.Errorf("No processes scaled up, adjust with `ps:scale procType=#` before deploying")
+// gitContent gets a file at a revision, HEAD when empty, from the app's bare
+// git repository.  Returns os.ErrNotExist when the file isn't there.
+func (app *Application) gitContent(revision string, file string) (io.Reader, error) {
+	if len(revision) == 0 {
+		revision = "HEAD"
 	}
-
-	var (
-		titleLogger = NewFormatter(d.Logger, GREEN)
-		dimLogger   = NewFormatter(d.Logger, DIM)
-		e           = Executor{Logger: dimLogger}
-	)
-
-	d.autoDetectRevision()
-
-	removeDynos, allocatingNewDynos, err := d.calculateDynosToDestroy()
+	content, err := exec.Command("git", "-C", app.BareGitDir(), "show", revision+":"+file).Output()
 	if err != nil {
-		return err
-	}
-
-	if allocatingNewDynos {
-		availableNodes, err := d.syncNodes()
-		if err != nil {
-			return err
-		}
-
-		// Now we've successfully sync'd and we have a list of nodes available to deploy to.
-		addDynos, err := d.startDynos(availableNodes, titleLogger)
-		if err != nil {
-			return err
-		}
-
-		if err := d.Server.SyncLoadBalancers(&e, addDynos, removeDynos); err != nil {
-			return err
-		}
-	}
-
-	if !d.ScalingOnly {
-		// Update releases.
-		releases, err := d.Server.ReleasesProvider.List(d.Application.Name)
-		if err != nil {
-			return err
-		}
-		// Prepend the release (releases are in descending order).
-		releases = append([]domain.Release{d.release()}, releases...)
-		// Only keep around the latest 15 (older ones are still in S3).
-		if len(releases) > 15 {
-			releases = releases[:15]
-		}
-		if err := d.Server.ReleasesProvider.Set(d.Application.Name, releases); err != nil {
-			log.WithField("app", d.Application.Name).Errorf("Problem setting releases: %s", err)
-			return err
-		}
-		log.WithField("app", d.Application.Name).Debug("Successfully set releases")
-	} else {
-		// Trigger old dynos to shutdown.
-		for _, removeDyno := range removeDynos {
-			fmt.Fprintf(titleLogger, "Shutting down dyno: %v\n", removeDyno.Container)
-			go func(rd Dyno) {
-				e := &Executor{
-					Logger: os.Stdout,
-				}
-				rd.Shutdown(e)
-			}(removeDyno)
+		if status, ok := exitStatus(err); ok && status == 128 {
+			// File was not found.
+			return nil, os.ErrNotExist
 		}
+		return nil, fmt.Errorf("retrieving app=%v %q content at revision %v: %s", app.Name, file, revision, err)
 	}
+	return bytes.NewReader(content), nil
+}
 
-	return nil
+// deploy launches the already published image on the nodes and routes
+// traffic to it.
+func (d *Deployment) deploy() error {
+	return d.runSteps(d.releaseSteps())
 }
 
 // publish pushes the built image to the LXC image repository.
 //
 // As a side-effect, when successful it sets the Deployment.ImageFingerprint.
 func (d *Deployment) publish() error {
+	// Guarantee the private key is never exposed to the published image.
+	if err := d.removeSSHPrivateKeyFile(); err != nil {
+		return err
+	}
+
 	var (
 		cmd    = logcmd(exec.Command(LXC_BIN, "publish", "--force", "--force-local", "--public", d.Application.Name, "--alias", d.lxcImageName()))
 		stderr = &bytes.Buffer{}
@@ -1591,55 +1398,78 @@ func (d *Deployment) release() domain.Release {
 	return r
 }
 
-func (d *Deployment) Deploy() error {
-	var err error
-
+func (d *Deployment) Deploy() (err error) {
 	// Cleanup any hanging chads upon error.
 	defer func() {
-		if err != nil {
+		// NB: Scaling doesn't bump the version, so there is nothing to undo.
+		if err != nil && !d.ScalingOnly {
 			d.undoVersionBump()
 		}
 		d.postDeployHooks(err)
 	}()
 
-	if !d.ScalingOnly {
-		if err = d.createContainer(); err != nil {
-			return fmt.Errorf("initializing: %s", err)
-		}
-
-		if err = d.build(); err != nil {
-			return fmt.Errorf("building: %s", err)
-		}
-
-		if err = d.publish(); err != nil {
-			return fmt.Errorf("publishing: %s", err)
-		}
-
-		if err = d.archive(); err != nil {
-			return fmt.Errorf("archiving: %s", err)
-		}
+	if err = d.runSteps(d.steps()); err != nil {
+		return
 	}
 
-	if err = d.deploy(); err != nil {
-		return fmt.Errorf("deploying: %s", err)
+	titleLogger := NewFormatter(d.Logger, GREEN)
+	for _, step := range d.state.Steps {
+		fmt.Fprintf(titleLogger, "%-8v %v\n", step.Name, step.Duration().Round(time.Millisecond))
 	}
-
-	return nil
+	return
 }
 
 func (server *Server) Deploy(conn net.Conn, applicationName, revision string) error {
 	deployLock.start()
 	defer deployLock.finish()
 
-	logger := NewTimeLogger(NewMessageLogger(conn))
-	fmt.Fprintf(logger, "Deploying revision %v\n", revision)
+	return server.deploy(conn, applicationName, revision, "")
+}
 
-	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+func (server *Server) Deploy_Archive(conn net.Conn, applicationName string, filename string) error {
+	deployLock.start()
+	defer deployLock.finish()
+
+	// NB: The app is checked before accepting the upload so unknown apps can't
+	// be used to fill the disk.
+	if err := server.WithApplication(applicationName, func(_ *Application, _ *Config) error { return nil }); err != nil {
+		return err
+	}
+
+	archive, revision, err := receiveArchive(conn, applicationName, filename, maxArchiveBytes())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rmErr := os.Remove(archive); rmErr != nil {
+			log.WithField("app", applicationName).Errorf("Problem removing temporary archive file %q: %s", archive, rmErr)
+		}
+	}()
+
+	return server.deploy(conn, applicationName, revision, archive)
+}
+
+// deploy builds and releases a new version of an app from either a git
+// revision or, when archive is non-empty, an uploaded source archive.
+//
+// NB: deployLock must be held.
+func (server *Server) deploy(conn net.Conn, applicationName string, revision string, archive string) error {
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) (err error) {
 		// Bump version.
-		app, cfg, err := server.IncrementAppVersion(app)
-		if err != nil {
+		if app, cfg, err = server.IncrementAppVersion(app); err != nil {
 			return err
 		}
+
+		kind := "deploy"
+		if len(archive) > 0 {
+			kind = "archive"
+		}
+		deployLog := NewDeployLog(app.Name, app.LastDeploy, revision, kind)
+		defer func() { deployLog.Finish(err) }()
+
+		logger := NewTimeLogger(deployOutput(conn, deployLog))
+		fmt.Fprintf(logger, "Deploying revision %v\n", revision)
+
 		deployment := NewDeployment(DeploymentOptions{
 			Server:      server,
 			Logger:      logger,
@@ -1648,7 +1478,10 @@ func (server *Server) Deploy(conn net.Conn, applicationName, revision string) er
 			Revision:    revision,
 			Version:     app.LastDeploy,
 			StartedTs:   time.Now(),
+			Archive:     archive,
+			Resumable:   true,
 		})
+		deployment.state.Kind = kind
 		if err = deployment.Deploy(); err != nil {
 			return err
 		}
@@ -1660,20 +1493,22 @@ func (server *Server) Redeploy(conn net.Conn, applicationName string) error {
 	deployLock.start()
 	defer deployLock.finish()
 
-	logger := NewTimeLogger(NewMessageLogger(conn))
-
-	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) (err error) {
 		if app.LastDeploy == "" {
 			// Nothing to redeploy.
 			return fmt.Errorf("Redeploy is not going to happen because this app has not yet had a first deploy")
 		}
 		previousVersion := app.LastDeploy
 		// Bump version.
-		app, cfg, err := server.IncrementAppVersion(app)
-		if err != nil {
+		if app, cfg, err = server.IncrementAppVersion(app); err != nil {
 			return err
 		}
 
+		deployLog := NewDeployLog(app.Name, app.LastDeploy, "", "redeploy")
+		defer func() { deployLog.Finish(err) }()
+
+		logger := NewTimeLogger(deployOutput(conn, deployLog))
+
 		restore := func() error {
 			pErr := server.WithPersistentApplication(applicationName, func(app *Application, cfg *Config) error {
 				app.LastDeploy = previousVersion
@@ -1692,7 +1527,9 @@ func (server *Server) Redeploy(conn net.Conn, applicationName string) error {
 			Application: app,
 			Version:     app.LastDeploy,
 			StartedTs:   time.Now(),
+			Resumable:   true,
 		})
+		deployment.state.Kind = "redeploy"
 		// Find the release that corresponds with the latest deploy.
 		releases, err := server.ReleasesProvider.List(applicationName)
 		if err != nil {
@@ -1705,6 +1542,8 @@ func (server *Server) Redeploy(conn net.Conn, applicationName string) error {
 		for _, r := range releases {
 			if r.Version == previousVersion {
 				deployment.Revision = r.Revision
+				deployment.state.Revision = r.Revision
+				deployLog.Revision = r.Revision
 				found = true
 				break
 			}
@@ -1716,16 +1555,89 @@ func (server *Server) Redeploy(conn net.Conn, applicationName string) error {
 			}
 			return fmt.Errorf("failed to find previous deploy: %v", previousVersion)
 		}
-		Logf(conn, "redeploying\n")
+		if err = app.verifyRevision(previousVersion, deployment.Revision); err != nil {
+			if rErr := restore(); rErr != nil {
+				return errorlib.Merge([]error{err, rErr})
+			}
+			return err
+		}
+		if isArchiveRevision(deployment.Revision) {
+			// The uploaded source archive is not retained, so it cannot be rebuilt.
+			if rErr := restore(); rErr != nil {
+				return rErr
+			}
+			return fmt.Errorf("Redeploy is not possible because %v was deployed from an uploaded archive; use rollback or deploy:archive instead", previousVersion)
+		}
+		fmt.Fprintf(logger, "Redeploying revision %v\n", deployment.Revision)
 		return deployment.Deploy()
 	})
 }
 
-func (server *Server) Rescale(conn net.Conn, applicationName string, deferred bool, args map[string]string) error {
+// Deploy_Resume continues the most recent failed deployment of an app from the
+// step which failed.
+func (server *Server) Deploy_Resume(conn net.Conn, applicationName string) error {
 	deployLock.start()
 	defer deployLock.finish()
 
-	logger := NewLogger(NewTimeLogger(NewMessageLogger(conn)), "[scale] ")
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) (err error) {
+		state, err := LoadDeployState(app.Name)
+		if err != nil {
+			if err == os.ErrNotExist {
+				return fmt.Errorf("No deployment found to resume for app %v", app.Name)
+			}
+			return err
+		}
+		failed := state.Failed()
+		if failed == nil {
+			return fmt.Errorf("Nothing to resume, the most recent deployment of %v (%v) did not fail", app.Name, state.Version)
+		}
+		// The failed deploy rolled back the version bump, so the app must not have
+		// been deployed since.
+		if next, _ := app.NextVersion(); next != state.Version {
+			return fmt.Errorf("Cannot resume deployment of %v because %v has since been deployed", state.Version, app.LastDeploy)
+		}
+		if isArchiveRevision(state.Revision) && !state.Completed("build") {
+			return fmt.Errorf("Cannot resume deployment of %v from the %v step because the uploaded archive is no longer available; use deploy:archive instead", state.Version, failed.Name)
+		}
+
+		if app, cfg, err = server.IncrementAppVersion(app); err != nil {
+			return err
+		}
+
+		deployLog := NewDeployLog(app.Name, app.LastDeploy, state.Revision, "resume")
+		defer func() { deployLog.Finish(err) }()
+
+		logger := NewTimeLogger(deployOutput(conn, deployLog))
+		fmt.Fprintf(logger, "Resuming deployment of %v revision %v from the %v step\n", state.Version, state.Revision, failed.Name)
+
+		deployment := NewDeployment(DeploymentOptions{
+			Server:      server,
+			Logger:      logger,
+			Config:      cfg,
+			Application: app,
+			Revision:    state.Revision,
+			Version:     app.LastDeploy,
+			StartedTs:   time.Now(),
+			Resumable:   true,
+		})
+		deployment.state = state
+		deployment.ImageFingerprint = state.ImageFingerprint
+		if err = deployment.Deploy(); err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+func (server *Server) Rescale(conn net.Conn, applicationName string, deferred bool, args map[string]string) error {
+	return server.rescale(NewLogger(NewTimeLogger(NewMessageLogger(conn)), "[scale] "), applicationName, deferred, args)
+}
+
+// rescale applies the process scale settings in args, e.g. web=12, and unless
+// deferred starts or stops dynos to match.
+func (server *Server) rescale(logger io.Writer, applicationName string, deferred bool, args map[string]string) error {
+	deployLock.start()
+	defer deployLock.finish()
 
 	// Calculate scale changes to make.
 	changes := map[string]int{}
@@ -1806,7 +1718,7 @@ func (server *Server) ManageProcessState(action string, conn net.Conn, app *Appl
 		Logger: logger,
 	}
 	for _, dyno := range dynos {
-		if dyno.Process == processType {
+		if sameProcess(dyno.Process, processType) {
 			if action == "stop" {
 				err = dyno.StopService(executor)
 			} else if action == "start" {
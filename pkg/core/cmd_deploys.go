@@ -0,0 +1,39 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+func (server *Server) Deploys_List(conn net.Conn, applicationName string) error {
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+		dls, err := ListDeployLogs(app.Name)
+		if err != nil {
+			return err
+		}
+		if len(dls) == 0 {
+			return Logf(conn, "No deploys found for app %v\n", app.Name)
+		}
+		for _, dl := range dls {
+			Logf(conn, "%v %v %v %v %v (%v)\n", dl.Version, dl.Kind, dl.Status, dl.Revision, dl.StartedTs.Format(time.RFC3339), dl.Duration().Round(time.Second))
+		}
+		return nil
+	})
+}
+
+func (server *Server) Deploys_Log(conn net.Conn, applicationName string, version string) error {
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+		dl, err := FindDeployLog(app.Name, version)
+		if err != nil {
+			return err
+		}
+		content, err := dl.Content()
+		if err != nil {
+			return fmt.Errorf("reading deploy log for app=%v version=%v: %s", app.Name, dl.Version, err)
+		}
+		titleLogger, _ := server.getTitleAndDimLoggers(conn)
+		fmt.Fprintf(titleLogger, "=== Deploy log for %v %v (%v)\n\n", app.Name, dl.Version, dl.Status)
+		return Send(conn, Message{Log, string(content)})
+	})
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+func (server *Server) Limits_List(conn net.Conn, applicationName string) error {
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+		titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+		fmt.Fprintf(titleLogger, "=== Dyno limits for %v\n", applicationName)
+
+		manifest, err := app.loadManifest(app.deployedRevision())
+		if err != nil {
+			fmt.Fprintf(dimLogger, "Warning: ignoring %v limits: %s\n", MANIFEST_FILE, err)
+		}
+		processes := []string{}
+		for process := range app.Processes {
+			processes = append(processes, process)
+		}
+		sort.Strings(processes)
+		for _, process := range processes {
+			fmt.Fprintf(dimLogger, "%v: %v\n", process, app.processLimits(manifest, process))
+		}
+		return nil
+	})
+}
+
+// e.g. limits:set -amyApp web memory=512MB cpus=2 cpu=50% processes=200
+func (server *Server) Limits_Set(conn net.Conn, applicationName string, processType string, args map[string]string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("one or more limits are required, e.g. memory=512MB cpus=2 cpu=50%% processes=200")
+	}
+	return server.WithPersistentApplication(applicationName, func(app *Application, cfg *Config) error {
+		if _, ok := app.Processes[processType]; !ok {
+			return fmt.Errorf("unrecognized process type: %v", processType)
+		}
+		process := normalizeAppProcessName(processType)
+		limits, err := ParseProcessLimits(app.Limits[process], args)
+		if err != nil {
+			return err
+		}
+		if app.Limits == nil {
+			app.Limits = map[string]ProcessLimits{}
+		}
+		if limits.IsZero() {
+			delete(app.Limits, process)
+		} else {
+			app.Limits[process] = limits
+		}
+		titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+		fmt.Fprintf(titleLogger, "=== Setting dyno limits for %v %v\n", applicationName, processType)
+		fmt.Fprintf(dimLogger, "%v: %v (applies to dynos started from now on, redeploy to apply to running dynos)\n", processType, limits)
+		return nil
+	})
+}
+
+func (server *Server) Limits_Remove(conn net.Conn, applicationName string, processTypes []string) error {
+	if len(processTypes) == 0 {
+		return fmt.Errorf("list of process types must not be empty")
+	}
+	return server.WithPersistentApplication(applicationName, func(app *Application, cfg *Config) error {
+		titleLogger, _ := server.getTitleAndDimLoggers(conn)
+		for _, processType := range processTypes {
+			fmt.Fprintf(titleLogger, "=== Removing dyno limits for %v %v\n", applicationName, processType)
+			delete(app.Limits, normalizeAppProcessName(processType))
+		}
+		return nil
+	})
+}
@@ -20,16 +20,56 @@ func replaceLocalhostWithSystemIp(addresses *[]string) []string {
 	return out
 }
 
-func (server *Server) LoadBalancer_Add(conn net.Conn, addresses []string) error {
+// LoadBalancer_Add adds load-balancers which run the named driver, HAProxy
+// when empty.  Re-adding a load-balancer switches it to the driver given.
+func (server *Server) LoadBalancer_Add(conn net.Conn, driver string, addresses []string) error {
 	addresses = replaceLocalhostWithSystemIp(&addresses)
-	err := server.WithPersistentConfig(func(cfg *Config) error {
+	if len(driver) == 0 {
+		driver = LBDriverHAProxy
+	}
+	newDriver, err := server.newLoadBalancerDriver(driver)
+	if err != nil {
+		return err
+	}
+	e := &Executor{Logger: NewLogger(NewMessageLogger(conn), "[lb:add] ")}
+
+	// Existing load-balancers switching drivers have the previous software
+	// stopped first, as it would keep the ports bound.
+	var switches map[string]string
+	if err := server.WithConfig(func(cfg *Config) error {
+		switches = cfg.LoadBalancerDriverSwitches(addresses, driver)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, address := range sortedStringKeys(switches) {
+		oldDriver, err := server.newLoadBalancerDriver(switches[address])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.Logger, "Switching load-balancer %v from %v to %v, it won't serve requests until the sync completes\n", address, oldDriver.Name(), newDriver.Name())
+		if err := switchLoadBalancerService(e, address, oldDriver, newDriver); err != nil {
+			return err
+		}
+	}
+
+	err = server.WithPersistentConfig(func(cfg *Config) error {
 		cfg.LoadBalancers = server.UniqueStringsAppender(conn, cfg.LoadBalancers, addresses, "load-balancer", nil)
+		if cfg.LoadBalancerDrivers == nil {
+			cfg.LoadBalancerDrivers = map[string]string{}
+		}
+		for _, address := range addresses {
+			if driver == LBDriverHAProxy {
+				delete(cfg.LoadBalancerDrivers, address)
+			} else {
+				cfg.LoadBalancerDrivers[address] = driver
+			}
+		}
 		return nil
 	})
 	if err != nil {
 		return err
 	}
-	e := &Executor{Logger: NewLogger(NewMessageLogger(conn), "[lb:add] ")}
 	return server.SyncLoadBalancers(e, []Dyno{}, []Dyno{})
 }
 
@@ -39,7 +79,7 @@ func (server *Server) LoadBalancer_List(conn net.Conn) error {
 
 	return server.WithConfig(func(cfg *Config) error {
 		for _, lb := range cfg.LoadBalancers {
-			Logf(conn, "%v\n", lb)
+			Logf(conn, "%v (%v)\n", lb, cfg.LoadBalancerDriverName(lb))
 		}
 		return nil
 	})
@@ -49,6 +89,9 @@ func (server *Server) LoadBalancer_Remove(conn net.Conn, addresses []string) err
 	addresses = replaceLocalhostWithSystemIp(&addresses)
 	err := server.WithPersistentConfig(func(cfg *Config) error {
 		cfg.LoadBalancers = server.UniqueStringsRemover(conn, cfg.LoadBalancers, addresses, "load-balancer", nil)
+		for _, address := range addresses {
+			delete(cfg.LoadBalancerDrivers, address)
+		}
 		return nil
 	})
 	if err != nil {
@@ -58,8 +101,8 @@ func (server *Server) LoadBalancer_Remove(conn net.Conn, addresses []string) err
 	return server.SyncLoadBalancers(e, []Dyno{}, []Dyno{})
 }
 
-// LoadBalancer_Sync is the public interface for syncing all HAProxy
-// load-balancer configurations across the fleet.
+// LoadBalancer_Sync is the public interface for syncing all load-balancer
+// configurations across the fleet.
 func (server *Server) LoadBalancer_Sync(conn net.Conn) error {
 	if err := server.loadBalancerSync(conn); err != nil {
 		log.Errorf("Problem syncing load-balancer configuration: %s", err)
@@ -69,7 +112,7 @@ func (server *Server) LoadBalancer_Sync(conn net.Conn) error {
 	return nil
 }
 
-// loadBalancerSync attmpts to sync all HAProxy load-balancer configurations
+// loadBalancerSync attmpts to sync all load-balancer configurations
 // across the fleet.
 func (server *Server) loadBalancerSync(conn net.Conn) error {
 	var (
@@ -81,3 +124,36 @@ func (server *Server) loadBalancerSync(conn net.Conn) error {
 	}
 	return nil
 }
+
+// LoadBalancer_Diff shows how each load-balancer's installed config differs from the desired one.
+func (server *Server) LoadBalancer_Diff(conn net.Conn) error {
+	titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+	fmt.Fprintf(titleLogger, "=== Load-balancer config drift\n\n")
+
+	drifts, err := server.LoadBalancerDrift()
+	if err != nil {
+		return err
+	}
+	if len(drifts) == 0 {
+		fmt.Fprint(dimLogger, "No load-balancers are configured\n")
+		return nil
+	}
+	nDrifted := 0
+	for _, drift := range drifts {
+		switch {
+		case drift.Err != nil:
+			nDrifted++
+			fmt.Fprintf(titleLogger, "%v (%v): unable to check: %s\n\n", drift.Host, drift.Driver, drift.Err)
+		case len(drift.Diff) == 0:
+			fmt.Fprintf(dimLogger, "%v (%v): in sync\n\n", drift.Host, drift.Driver)
+		default:
+			nDrifted++
+			fmt.Fprintf(titleLogger, "%v (%v): drifted\n", drift.Host, drift.Driver)
+			fmt.Fprintf(dimLogger, "%v\n", drift.Diff)
+		}
+	}
+	if nDrifted > 0 {
+		fmt.Fprintf(titleLogger, "%v of %v load-balancer(s) out of sync, run lb:sync to fix\n", nDrifted, len(drifts))
+	}
+	return nil
+}
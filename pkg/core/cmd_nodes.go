@@ -5,6 +5,7 @@ import (
 	"io"
 	"net"
 	"strings"
+	"time"
 )
 
 func (server *Server) SyncContainer(e Executor, address string, container string, cloneOrCreateArgs ...string) error {
@@ -61,46 +62,25 @@ func (server *Server) SyncContainer(e Executor, address string, container string
 	// return nil
 }
 
-func (server *Server) addNode(addAddress string, logger io.Writer) (string, error) {
-	// var (
-	// 	prefixLogger = NewLogger(logger, "["+addAddress+"] ")
-	// 	e            = Executor{
-	// 		logger: prefixLogger,
-	// 	}
-	// )
-
-	// fmt.Fprintf(prefixLogger, "Transmitting base LXC container image to node: %v\n", addAddress)
-	// if err := server.SyncContainer(e, addAddress, "base", "lxc", "launch", "base", "-B", DefaultLXCFS, "-t", "ubuntu"); err != nil {
-	// 	return addAddress, err
-	// }
-	// // Add build-packs.
-	// for _, buildPack := range server.BuildpacksProvider.All() {
-	// 	nContainer := "base-" + buildPack.Name()
-	// 	fmt.Fprintf(prefixLogger, "Transmitting build-pack '%v' LXC container image to node: %v\n", nContainer, addAddress)
-	// 	if err := server.SyncContainer(e, addAddress, nContainer, "lxc-clone", "-s", "-B", DefaultLXCFS, "-o", "base", "-n", nContainer); err != nil {
-	// 		return addAddress, err
-	// 	}
-	// }
-	return addAddress, nil
+// addNode bootstraps and verifies a node, reporting the outcome of each check.
+// The node should only be persisted when no error is returned.
+func (server *Server) addNode(addAddress string, images []string, logger io.Writer) (string, error) {
+	checks, err := server.provisionNode(addAddress, images, NewLogger(logger, "["+addAddress+"] "))
+	fmt.Fprintf(logger, "\n%v\n", nodeChecksTable(addAddress, checks))
+	return addAddress, err
 }
 
-// Require that the node name does not contain the word "backend",
-// as it would break Server.dynoRoutingActive().
-func (server *Server) validateNodeNames(addresses *[]string) error {
-	for _, address := range *addresses {
-		if strings.Contains(strings.ToLower(address), "backend") {
-			return fmt.Errorf(`Invalid name "%v", must not contain "backend"`, address)
+// findNode returns the node with the given address, or nil if there isn't one.
+func findNode(nodes []*Node, address string) *Node {
+	for _, node := range nodes {
+		if strings.ToLower(node.Host) == strings.ToLower(address) {
+			return node
 		}
 	}
 	return nil
 }
 
 func (server *Server) Node_Add(conn net.Conn, addresses []string) error {
-	err := server.validateNodeNames(&addresses)
-	if err != nil {
-		return err
-	}
-
 	type AddResult struct {
 		address string
 		err     error
@@ -108,8 +88,8 @@ func (server *Server) Node_Add(conn net.Conn, addresses []string) error {
 
 	addChannel := make(chan AddResult)
 
-	addNodeWrapper := func(addAddress string, logger io.Writer) {
-		result, err := server.addNode(addAddress, logger)
+	addNodeWrapper := func(addAddress string, images []string, logger io.Writer) {
+		result, err := server.addNode(addAddress, images, logger)
 		addChannel <- AddResult{result, err}
 	}
 
@@ -122,6 +102,7 @@ func (server *Server) Node_Add(conn net.Conn, addresses []string) error {
 	return server.WithPersistentConfig(func(cfg *Config) error {
 		numRemaining := 0
 
+		newAddresses := []string{}
 		for _, addAddress := range addresses {
 			// Ensure the node to be added is not empty and that it isn't already added.
 			if len(addAddress) == 0 {
@@ -138,9 +119,19 @@ func (server *Server) Node_Add(conn net.Conn, addresses []string) error {
 			if found {
 				continue
 			}
+			newAddresses = append(newAddresses, addAddress)
+		}
 
-			go addNodeWrapper(addAddress, dimLogger)
-			numRemaining++
+		if len(newAddresses) > 0 {
+			// Published once up front, the nodes are all provisioned concurrently.
+			images, err := server.publishBaseImages(&Executor{Logger: dimLogger})
+			if err != nil {
+				return fmt.Errorf("publishing base images: %s", err)
+			}
+			for _, addAddress := range newAddresses {
+				go addNodeWrapper(addAddress, images, dimLogger)
+				numRemaining++
+			}
 		}
 
 		if numRemaining > 0 {
@@ -152,7 +143,7 @@ func (server *Server) Node_Add(conn net.Conn, addresses []string) error {
 						fmt.Fprintf(titleLogger, "Failed to add node '%v': %v\n", result.address, result.err)
 					} else {
 						fmt.Fprintf(titleLogger, "Adding node: %v\n", result.address)
-						cfg.Nodes = append(cfg.Nodes, &Node{result.address})
+						cfg.Nodes = append(cfg.Nodes, &Node{Host: result.address})
 					}
 					numRemaining--
 					if numRemaining == 0 {
@@ -173,13 +164,30 @@ func (server *Server) Node_List(conn net.Conn) error {
 	return server.WithConfig(func(cfg *Config) error {
 		for _, node := range cfg.Nodes {
 			nodeStatus := server.getNodeStatus(node)
+			labels := ""
+			if len(node.Labels) > 0 {
+				pairs := []string{}
+				for _, key := range sortedStringKeys(node.Labels) {
+					pairs = append(pairs, key+"="+node.Labels[key])
+				}
+				labels = " [" + strings.Join(pairs, " ") + "]"
+			}
+			if node.Cordoned {
+				labels += " (cordoned)"
+			}
 			if nodeStatus.Err == nil {
-				fmt.Fprintf(dimLogger, "%v (%vMB free)\n", node.Host, nodeStatus.FreeMemoryMb)
+				fmt.Fprintf(dimLogger, "%v%v (%v)\n", node.Host, labels, nodeStatus.Summary())
 				for _, application := range nodeStatus.Containers {
+					if dyno, err := nodeStatus.Dyno(application); err == nil {
+						if stats, ok := nodeStatus.ContainerStats[dyno.Container]; ok {
+							fmt.Fprintf(dimLogger, "    `- %v (%v)\n", application, stats)
+							continue
+						}
+					}
 					fmt.Fprintf(dimLogger, "    `- %v\n", application)
 				}
 			} else {
-				fmt.Fprintf(dimLogger, "%v (unknown status: %v since %v)\n", node.Host, nodeStatus.Err, nodeStatus.Ts)
+				fmt.Fprintf(dimLogger, "%v%v (unknown status: %v since %v)\n", node.Host, labels, nodeStatus.Err, nodeStatus.Ts)
 			}
 
 		}
@@ -187,6 +195,86 @@ func (server *Server) Node_List(conn net.Conn) error {
 	})
 }
 
+// e.g. nodes:label node1.example.com disk=ssd zone=a
+// An empty value removes the label, e.g. nodes:label node1.example.com zone=
+func (server *Server) Node_Label(conn net.Conn, address string, labels map[string]string) error {
+	if len(labels) == 0 {
+		return fmt.Errorf("one or more key=value labels are required, e.g. disk=ssd")
+	}
+	for key := range labels {
+		if err := validateNodeLabelKey(key); err != nil {
+			return err
+		}
+	}
+	address = replaceLocalhostWithSystemIp(&[]string{address})[0]
+
+	titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+
+	fmt.Fprintf(titleLogger, "=== Labeling Node %v\n\n", address)
+
+	return server.WithPersistentConfig(func(cfg *Config) error {
+		for _, node := range cfg.Nodes {
+			if strings.ToLower(node.Host) != strings.ToLower(address) {
+				continue
+			}
+			if node.Labels == nil {
+				node.Labels = map[string]string{}
+			}
+			for _, key := range sortedStringKeys(labels) {
+				if value := labels[key]; len(value) > 0 {
+					node.Labels[key] = value
+					fmt.Fprintf(dimLogger, "Set label: %v=%v\n", key, value)
+				} else {
+					delete(node.Labels, key)
+					fmt.Fprintf(dimLogger, "Removed label: %v\n", key)
+				}
+			}
+			return nil
+		}
+		return fmt.Errorf("unrecognized node: %v", address)
+	})
+}
+
+// e.g. nodes:ports node1.example.com
+func (server *Server) Node_Ports(conn net.Conn, address string) error {
+	address = replaceLocalhostWithSystemIp(&[]string{address})[0]
+
+	titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+
+	return server.WithConfig(func(cfg *Config) error {
+		node := findNode(cfg.Nodes, address)
+		if node == nil {
+			return fmt.Errorf("unrecognized node: %v", address)
+		}
+
+		allocations, err := portRegistry.List(node.Host)
+		if err != nil {
+			return err
+		}
+
+		nodeStatus := server.getNodeStatus(node)
+		running := map[int]bool{}
+		for _, port := range runningDynoPorts(&nodeStatus) {
+			running[port] = true
+		}
+
+		fmt.Fprintf(titleLogger, "=== Port Allocations on %v\n\n", node.Host)
+		for _, allocation := range allocations {
+			state := "allocated"
+			if nodeStatus.Err != nil {
+				state = "unknown"
+			} else if running[allocation.Port] {
+				state = "running"
+			}
+			fmt.Fprintf(dimLogger, "%v\t%v\t%v (since %v)\n", allocation.Port, allocation.Container, state, allocation.Ts.Format(time.RFC3339))
+		}
+		if nodeStatus.Err != nil {
+			fmt.Fprintf(dimLogger, "\nWarning: node status unknown: %v\n", nodeStatus.Err)
+		}
+		return nil
+	})
+}
+
 func (server *Server) Node_Remove(conn net.Conn, addresses []string) error {
 	addresses = replaceLocalhostWithSystemIp(&addresses)
 
@@ -201,6 +289,9 @@ func (server *Server) Node_Remove(conn net.Conn, addresses []string) error {
 			for _, removeAddress := range addresses {
 				if strings.ToLower(removeAddress) == strings.ToLower(node.Host) {
 					fmt.Fprintf(dimLogger, "Removing node: %v\n", removeAddress)
+					if nodeStatus := server.getNodeStatus(node); nodeStatus.Err == nil && len(nodeStatus.Containers) > 0 {
+						fmt.Fprintf(dimLogger, "Warning: %v dyno(s) are still running on node %v, use nodes:drain to move dynos off of a node before removing it\n", len(nodeStatus.Containers), node.Host)
+					}
 					keep = false
 					break
 				}
@@ -0,0 +1,34 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+func (server *Server) Placement_Get(conn net.Conn, applicationName string) error {
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+		titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+		fmt.Fprintf(titleLogger, "=== Dyno placement strategy for %v\n", applicationName)
+		placement := app.Placement
+		if len(placement) == 0 {
+			placement = DefaultPlacement + " (default)"
+		}
+		fmt.Fprintf(dimLogger, "%v\n", placement)
+		return nil
+	})
+}
+
+func (server *Server) Placement_Set(conn net.Conn, applicationName string, placement string) error {
+	placement = strings.ToLower(strings.TrimSpace(placement))
+	if _, err := NewPlacementStrategy(placement); err != nil {
+		return err
+	}
+	return server.WithPersistentApplication(applicationName, func(app *Application, cfg *Config) error {
+		titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+		fmt.Fprintf(titleLogger, "=== Setting dyno placement strategy for %v\n", applicationName)
+		app.Placement = placement
+		fmt.Fprintf(dimLogger, "Placement strategy is now: %v (applies to dynos started from now on)\n", placement)
+		return nil
+	})
+}
@@ -1,22 +1,35 @@
 package core
 
 import (
+	"bytes"
 	"fmt"
 	"net"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 
+const defaultRollingRestartInterval = 10 * time.Second // Pause between dynos during rolling restarts.
+
 func (server *Server) Ps_List(conn net.Conn, applicationName string) error {
 	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
 		str := ""
+		// Effective limits include those declared in the app manifest.
+		manifest, err := app.loadManifest(app.deployedRevision())
+		if err != nil {
+			Logf(conn, "Warning: ignoring %v limits: %s\n", MANIFEST_FILE, err)
+		}
 		for process, numDynos := range app.Processes {
 			dynos, err := server.GetRunningDynos(app.Name, process)
 			if err != nil {
 				Logf(conn, "Error: %v (process was '%v')", err, process)
 				continue
 			}
-			Logf(conn, "=== %v: dyno scale=%v, actual=%v\n", process, numDynos, len(dynos))
+			Logf(conn, "=== %v: dyno scale=%v, actual=%v, limits=%v\n", process, numDynos, len(dynos), app.processLimits(manifest, process))
+			sortDynos(dynos)
 			for _, dyno := range dynos {
-				Logf(conn, "%v @ %v [%v:%v]\n", process, dyno.Version, dyno.Host, dyno.Port)
+				Logf(conn, "%v @ %v [%v:%v]\n", dyno.Name(), dyno.Version, dyno.Host, dyno.Port)
 			}
 			Logf(conn, "\n")
 		}
@@ -51,10 +64,114 @@ func (server *Server) Ps_Manage(action string, conn net.Conn, applicationName st
 	})
 }
 
-// Restart all dynos for a particular process type.
+// Restart all dynos of the given process types, and/or individual dynos by
+// name.  Rolling restarts go one dyno at a time, taking web dynos out of the
+// load-balancers while they restart and waiting for them to pass their health
+// check before moving on.
 // e.g. ps:restart web -amyApp
-func (server *Server) Ps_Restart(conn net.Conn, applicationName string, processTypes []string) error {
-	return server.Ps_Manage("restart", conn, applicationName, processTypes)
+// e.g. ps:restart web.1 worker.2 -amyApp
+// e.g. ps:restart web --rolling --interval=10s -amyApp
+func (server *Server) Ps_Restart(conn net.Conn, applicationName string, rolling bool, interval string, names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("list of process types or dyno names must not be empty")
+	}
+	wait := defaultRollingRestartInterval
+	if len(interval) > 0 {
+		if !rolling {
+			return fmt.Errorf("an interval only applies to rolling restarts")
+		}
+		var err error
+		if wait, err = time.ParseDuration(interval); err != nil {
+			return fmt.Errorf("invalid interval %q: %s", interval, err)
+		}
+	}
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+		dynos, err := server.restartTargets(app, names)
+		if err != nil {
+			return err
+		}
+		logger := NewLogger(NewTimeLogger(NewMessageLogger(conn)), "[ps:restart] ")
+		e := &Executor{
+			Logger: logger,
+		}
+		for i, dyno := range dynos {
+			if !rolling {
+				if err := dyno.RestartService(e); err != nil {
+					return err
+				}
+				continue
+			}
+			if i > 0 && wait > 0 {
+				fmt.Fprintf(logger, "Waiting %v before restarting %v\n", wait, dyno.Name())
+				time.Sleep(wait)
+			}
+			if err := server.rollingRestartDyno(e, cfg.LoadBalancersUsing(LBDriverHAProxy), dyno, app.DrainTimeout()); err != nil {
+				return fmt.Errorf("restarting %v: %s (%v of %v dynos restarted)", dyno.Name(), err, i, len(dynos))
+			}
+		}
+		return nil
+	})
+}
+
+// restartTargets resolves process types and dyno names to running dynos.
+func (server *Server) restartTargets(app *Application, names []string) ([]Dyno, error) {
+	var (
+		dynos = []Dyno{}
+		seen  = map[string]bool{}
+	)
+	for _, name := range names {
+		var found []Dyno
+		if strings.Contains(name, ".") {
+			dyno, err := server.FindRunningDyno(app.Name, name)
+			if err != nil {
+				return nil, err
+			}
+			found = []Dyno{dyno}
+		} else {
+			if _, ok := app.Processes[name]; !ok {
+				return nil, fmt.Errorf("unrecognized process type: %v", name)
+			}
+			var err error
+			if found, err = server.GetRunningDynos(app.Name, name); err != nil {
+				return nil, err
+			}
+			sortDynos(found)
+		}
+		for _, dyno := range found {
+			if !seen[dyno.Container] {
+				seen[dyno.Container] = true
+				dynos = append(dynos, dyno)
+			}
+		}
+	}
+	return dynos, nil
+}
+
+// rollingRestartDyno restarts a dyno's app service.  Web dynos are drained from
+// the load-balancers beforehand, and re-enabled once restarted.
+func (server *Server) rollingRestartDyno(e *Executor, loadBalancers []string, dyno Dyno, drainTimeout time.Duration) error {
+	if dyno.Process != "web" || len(loadBalancers) == 0 {
+		return dyno.RestartService(e)
+	}
+	fmt.Fprintf(e.Logger, "Draining %v from the load-balancers\n", dyno.Name())
+	if err := server.setHAProxyServerState(loadBalancers, dyno, HAProxyServerDrain); err != nil {
+		return err
+	}
+	if err := server.waitForHAProxyServersIdle(loadBalancers, []Dyno{dyno}, drainTimeout); err != nil {
+		fmt.Fprintf(e.Logger, "Warning: %s\n", err)
+	}
+	if err := server.setHAProxyServerState(loadBalancers, dyno, HAProxyServerMaint); err != nil {
+		return err
+	}
+	if err := dyno.RestartService(e); err != nil {
+		// NB: The dyno is left disabled since it may not be serving requests.
+		return err
+	}
+	fmt.Fprintf(e.Logger, "Re-enabling %v on the load-balancers and waiting for its health check\n", dyno.Name())
+	if err := server.setHAProxyServerState(loadBalancers, dyno, HAProxyServerReady); err != nil {
+		return err
+	}
+	return server.waitForHAProxyServerUp(loadBalancers, dyno, DYNO_START_TIMEOUT_SECONDS*time.Second)
 }
 
 // Stop all dynos for a particular process type.
@@ -74,3 +191,99 @@ func (server *Server) Ps_Start(conn net.Conn, applicationName string, processTyp
 func (server *Server) Ps_Status(conn net.Conn, applicationName string, processTypes []string) error {
 	return server.Ps_Manage("status", conn, applicationName, processTypes)
 }
+
+// Run a command inside a running dyno with the app's environment.  With tty
+// the connection is hijacked for an interactive session, as with console, and
+// a shell is started when no command is given.
+// e.g. ps:exec -amyApp web.2 -- env
+func (server *Server) Ps_Exec(conn net.Conn, applicationName string, dynoName string, tty bool, args []string) error {
+	if !tty && len(args) == 0 {
+		return fmt.Errorf("a command is required, e.g. ps:exec -a %v %v -- env", applicationName, dynoName)
+	}
+	var dyno Dyno
+	if err := server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+		var err error
+		dyno, err = server.FindRunningDyno(app.Name, dynoName)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if tty {
+		Send(conn, Message{Hijack, ""})
+		return attachPty(conn, dyno.AttachCommand(args...))
+	}
+
+	e := &Executor{
+		Logger:         server.getSimpleLogger(conn),
+		SuppressOutput: true,
+	}
+	if err := dyno.AttachAndExecute(e, remoteAppShellArgs(args...)...); err != nil {
+		if status, ok := exitStatus(err); ok {
+			return fmt.Errorf("command exited with status %v in dyno %v (%v)", status, dynoName, dyno.Container)
+		}
+		return fmt.Errorf("running command in dyno %v (%v): %s", dynoName, dyno.Container, err)
+	}
+	return nil
+}
+
+// Show the resource usage of an app's running dynos.
+// e.g. ps:stats -amyApp
+func (server *Server) Ps_Stats(conn net.Conn, applicationName string) error {
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+		var (
+			rows     = map[string][]string{}
+			memoryMb = map[string]int{}
+			cpu      = map[string]float64{}
+		)
+		for _, node := range cfg.Nodes {
+			status := server.getNodeStatus(node)
+			if status.Err != nil {
+				Logf(conn, "Warning: skipping node %v with unknown status: %v\n", node.Host, status.Err)
+				continue
+			}
+			for _, container := range status.Containers {
+				dyno, err := status.Dyno(container)
+				if err != nil || dyno.Application != app.Name || dyno.State != DYNO_STATE_RUNNING {
+					continue
+				}
+				stats, ok := status.ContainerStats[dyno.Container]
+				if !ok {
+					rows[dyno.Process] = append(rows[dyno.Process], fmt.Sprintf("%v\t%v\t%v\tunknown\tunknown", dyno.Container, dyno.Version, dyno.Host))
+					continue
+				}
+				memoryMb[dyno.Process] += stats.MemoryMb
+				cpuStr := "unknown"
+				if stats.CPUPercent >= 0 {
+					cpu[dyno.Process] += stats.CPUPercent
+					cpuStr = fmt.Sprintf("%.1f%%", stats.CPUPercent)
+				}
+				rows[dyno.Process] = append(rows[dyno.Process], fmt.Sprintf("%v\t%v\t%v\t%vMB\t%v", dyno.Container, dyno.Version, dyno.Host, stats.MemoryMb, cpuStr))
+			}
+		}
+		if len(rows) == 0 {
+			Logf(conn, "No running dynos found for app %v\n", app.Name)
+			return nil
+		}
+
+		buf := &bytes.Buffer{}
+		w := tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+		processes := []string{}
+		for process := range rows {
+			processes = append(processes, process)
+		}
+		sort.Strings(processes)
+		for _, process := range processes {
+			fmt.Fprintf(w, "=== %v: %v dyno(s), %vMB memory, %.1f%% cpu\n", process, len(rows[process]), memoryMb[process], cpu[process])
+			fmt.Fprintf(w, "DYNO\tVERSION\tHOST\tMEMORY\tCPU\n")
+			sort.Strings(rows[process])
+			for _, row := range rows[process] {
+				fmt.Fprintf(w, "%v\n", row)
+			}
+			fmt.Fprintf(w, "\n")
+		}
+		w.Flush()
+		Logf(conn, "%v", buf.String())
+		return nil
+	})
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"net"
+	"time"
+)
+
+func (server *Server) Reconcile_On(conn net.Conn, applicationName string) error {
+	return server.WithPersistentApplication(applicationName, func(app *Application, cfg *Config) error {
+		app.NoReconcile = false
+		reconcileTracker.Reset(applicationName)
+		Logf(conn, "reconcile: true (missing dynos will be replaced automatically)\n")
+		return nil
+	})
+}
+
+func (server *Server) Reconcile_Off(conn net.Conn, applicationName string) error {
+	return server.WithPersistentApplication(applicationName, func(app *Application, cfg *Config) error {
+		app.NoReconcile = true
+		Logf(conn, "reconcile: false\n")
+		return nil
+	})
+}
+
+func (server *Server) Reconcile_Status(conn net.Conn, applicationName string) error {
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+		Logf(conn, "reconcile: %v\n", !app.NoReconcile)
+
+		statuses := map[string]NodeStatus{}
+		for _, node := range cfg.Nodes {
+			statuses[node.Host] = server.getNodeStatus(node)
+		}
+		allocations, err := unreachableAllocations(statuses)
+		if err != nil {
+			return err
+		}
+		missing := missingDynos(app, statuses, allocations)
+		for _, process := range sortedIntKeys(missing) {
+			Logf(conn, "missing: %v (processType=%v)\n", missing[process], process)
+		}
+
+		if backoff, ok := reconcileTracker.Get(applicationName); ok {
+			Logf(conn, "attempts: %v (next attempt no sooner than %v)\n", backoff.Attempts, backoff.Next.Format(time.RFC3339))
+		}
+		return nil
+	})
+}
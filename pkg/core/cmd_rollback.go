@@ -9,7 +9,7 @@ import (
 )
 
 func (server *Server) Rollback(conn net.Conn, applicationName, version string) error {
-	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) (err error) {
 		deployLock.start()
 		defer deployLock.finish()
 
@@ -21,9 +21,7 @@ func (server *Server) Rollback(conn net.Conn, applicationName, version string) e
 		}
 		if version == "" {
 			// Get release before current.
-			var err error = nil
-			version, err = app.CalcPreviousVersion()
-			if err != nil {
+			if version, err = app.CalcPreviousVersion(); err != nil {
 				return err
 			}
 		}
@@ -32,20 +30,47 @@ func (server *Server) Rollback(conn net.Conn, applicationName, version string) e
 			version = strings.TrimLeft(version, "v")
 		}
 
-		logger := NewLogger(NewTimeLogger(NewMessageLogger(conn)), "[rollback] ")
-		fmt.Fprintf(logger, "Rolling back to v%v\n", version)
+		releases, err := server.ReleasesProvider.List(app.Name)
+		if err != nil {
+			return err
+		}
+		revision, err := app.releaseRevision(releases, "v"+version)
+		if err != nil {
+			return err
+		}
+		// The published image is restored rather than rebuilt, so a revision which
+		// is gone from the git repository only matters to a later redeploy.
+		var revisionErr error
+		if len(revision) > 0 {
+			revisionErr = app.verifyRevision("v"+version, revision)
+		}
+
+		// The app container is about to be replaced, so any failed deployment can no
+		// longer be resumed.
+		if err = DeleteDeployState(app.Name); err != nil {
+			return err
+		}
 
 		// Get the next version.
-		app, cfg, err := server.IncrementAppVersion(app)
-		if err != nil {
+		if app, cfg, err = server.IncrementAppVersion(app); err != nil {
 			return err
 		}
 
+		deployLog := NewDeployLog(app.Name, app.LastDeploy, revision, "rollback")
+		defer func() { deployLog.Finish(err) }()
+
+		logger := NewLogger(NewTimeLogger(deployOutput(conn, deployLog)), "[rollback] ")
+		fmt.Fprintf(logger, "Rolling back to v%v\n", version)
+		if revisionErr != nil {
+			fmt.Fprintf(logger, "Warning: %s\n", revisionErr)
+		}
+
 		deployment := NewDeployment(DeploymentOptions{
 			Server:      server,
 			Logger:      logger,
 			Config:      cfg,
 			Application: app,
+			Revision:    revision,
 			Version:     app.LastDeploy,
 			StartedTs:   time.Now(),
 		})
@@ -0,0 +1,456 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DYNO_PROCESS_RUN is the process type of one-off dynos.  Their output is
+	// logged under the process name run.N, where N is the run number.
+	DYNO_PROCESS_RUN = "run"
+
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+
+	maxRunRecords = 100
+)
+
+// runRecordsLock serializes updates to the per-app run record files.
+var runRecordsLock sync.Mutex
+
+var runsDirectory = RUNS_DIRECTORY
+
+// activeRuns tracks the one-off dynos whose commands are supervised by this
+// server process.  Any other run dynos or running records are orphans, e.g.
+// left behind by a server restart or crash.
+var activeRuns = ActiveRuns{containers: map[string]bool{}}
+
+// ActiveRuns is the set of supervised one-off dyno containers.
+type ActiveRuns struct {
+	containers map[string]bool
+	lock       sync.Mutex
+}
+
+// Add starts tracking a one-off dyno container.
+func (ar *ActiveRuns) Add(container string) {
+	ar.lock.Lock()
+	defer ar.lock.Unlock()
+	ar.containers[container] = true
+}
+
+// Remove stops tracking a one-off dyno container.
+func (ar *ActiveRuns) Remove(container string) {
+	ar.lock.Lock()
+	defer ar.lock.Unlock()
+	delete(ar.containers, container)
+}
+
+// Contains returns true when the one-off dyno container is supervised.
+func (ar *ActiveRuns) Contains(container string) bool {
+	ar.lock.Lock()
+	defer ar.lock.Unlock()
+	return ar.containers[container]
+}
+
+// RunRecord is a one-off command started with run:detached.
+type RunRecord struct {
+	Number     int
+	Command    string
+	Host       string
+	Container  string
+	Version    string
+	State      string // One of RunRunning, RunSucceeded or RunFailed.
+	ExitStatus int
+	Error      string
+	StartedTs  time.Time
+	FinishedTs time.Time
+}
+
+// ProcessName is the name the run's output is logged under.
+func (record RunRecord) ProcessName() string {
+	return fmt.Sprintf("%v.%v", DYNO_PROCESS_RUN, record.Number)
+}
+
+// e.g. run:detached -a myapp -- ./bin/backfill --since=2018-01-01
+//
+// Runs a command in a one-off dyno from the app's current release, placed on a
+// cluster node like any other dyno.  The connection is released as soon as the
+// dyno has started; output goes to the app's log stream.
+func (server *Server) Run_Detached(conn net.Conn, applicationName string, args []string) error {
+	command := strings.TrimSpace(strings.Join(args, " "))
+	if len(command) == 0 {
+		return fmt.Errorf("a command is required, e.g. run:detached -a %v -- ./bin/task", applicationName)
+	}
+
+	var (
+		app   *Application
+		nodes []*Node
+	)
+	if err := server.WithApplication(applicationName, func(a *Application, cfg *Config) error {
+		if a.LastDeploy == "" {
+			return fmt.Errorf("run not available - application has not yet had a first deploy")
+		}
+		app = a
+		nodes = schedulableNodes(cfg.Nodes)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+
+	fmt.Fprintf(titleLogger, "=== Starting one-off dyno for %v\n\n", app.Name)
+
+	record, err := server.launchRun(app, nodes, command, dimLogger, nil)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(dimLogger, "Running %q as %v in dyno %v\n", command, record.ProcessName(), record.Container)
+	fmt.Fprintf(dimLogger, "Follow its output with `logs -a %v`, and check its exit status with `run:list -a %v`\n", app.Name, app.Name)
+	return nil
+}
+
+// launchRun places and boots a one-off dyno and returns once the command has
+// been started in it.  The outcome is recorded when the command exits, and
+// passed to done if it isn't nil.
+func (server *Server) launchRun(app *Application, nodes []*Node, command string, logger io.Writer, done func(RunRecord)) (RunRecord, error) {
+	dynoGenerator, err := server.NewDynoGenerator(nodes, app, app.LastDeploy)
+	if err != nil {
+		return RunRecord{}, err
+	}
+	dyno, err := dynoGenerator.Next(DYNO_PROCESS_RUN)
+	if err != nil {
+		return RunRecord{}, err
+	}
+	dyno.State = DYNO_STATE_RUNNING
+
+	// Tracked before the record exists so the dyno is never taken for an
+	// orphan.
+	activeRuns.Add(dyno.Container)
+	record, err := addRunRecord(app.Name, RunRecord{
+		Command:   command,
+		Host:      dyno.Host,
+		Container: dyno.Container,
+		Version:   app.LastDeploy,
+		State:     RunRunning,
+		StartedTs: time.Now(),
+	})
+	if err != nil {
+		activeRuns.Remove(dyno.Container)
+		return record, err
+	}
+
+	fmt.Fprintf(logger, "Starting %v on %v\n", record.ProcessName(), dyno.Host)
+	e := &Executor{Logger: NewLogger(logger, "["+dyno.Host+"] ")}
+	if err := server.startRunDyno(e, findNode(nodes, dyno.Host), app, dyno, record); err != nil {
+		record = server.finishRun(e, app.Name, dyno, record, err)
+		return record, fmt.Errorf("starting %v: %s", record.ProcessName(), err)
+	}
+
+	go func(app string) {
+		e := &Executor{Logger: NewLogger(os.Stdout, fmt.Sprintf("[%v:%v] ", app, record.ProcessName()))}
+		record := server.finishRun(e, app, dyno, record, e.Run("ssh", "root@"+dyno.Host, runExecCommand(app, dyno.Container, record)))
+		if done != nil {
+			done(record)
+		}
+	}(app.Name)
+	return record, nil
+}
+
+// startRunDyno creates and boots a one-off dyno container.
+func (server *Server) startRunDyno(e *Executor, node *Node, app *Application, dyno Dyno, record RunRecord) error {
+	if node == nil {
+		return fmt.Errorf("unrecognized node: %v", dyno.Host)
+	}
+	// The node might not have run any of the app's dynos before.
+	d := NewDeployment(DeploymentOptions{
+		Server:      server,
+		Logger:      e.Logger,
+		Application: app,
+		Version:     dyno.Version,
+		StartedTs:   record.StartedTs,
+	})
+	if err := d.syncNode(node); err != nil {
+		return err
+	}
+
+	metadata := map[string]string{
+		"app":     app.Name,
+		"version": dyno.Version,
+		"process": DYNO_PROCESS_RUN,
+		"port":    dyno.Port,
+		"started": record.StartedTs.UTC().Format(time.RFC3339),
+	}
+	return e.Run("ssh", "root@"+dyno.Host, "/bin/bash", "-c", runDynoStartCommand(app.Name, dyno, metadata))
+}
+
+// runDynoStartCommand returns the bash which creates and boots a one-off dyno
+// from its release image.  The image has the app service enabled with the
+// build's /app/run, so it's disabled before the container first boots.
+func runDynoStartCommand(applicationName string, dyno Dyno, metadata map[string]string) string {
+	configCmds := []string{}
+	for _, key := range sortedStringKeys(metadata) {
+		configCmds = append(configCmds, fmt.Sprintf("%v config set %v %v%v %v", LXC_BIN, dyno.Container, DYNO_METADATA_PREFIX, key, bashQuote(metadata[key])))
+	}
+	// NB: The leading ":" below is a no-op to prevent extraneous useless bash
+	// output.
+	return fmt.Sprintf(`:
+set -o errexit
+set -o pipefail
+%[1]v init %[2]v%[3]v%[4]v %[5]v
+%[6]v
+%[1]v file delete %[5]v%[8]v 2>/dev/null || :
+if %[1]v file pull %[5]v%[8]v - 1>/dev/null 2>&1 ; then
+    echo 'failed to disable the app service in container %[5]v' 1>&2
+    exit 1
+fi
+%[1]v start %[5]v
+for i in $(seq 1 45) ; do test -n "$(%[7]v)" && exit 0 ; sleep 1 ; done
+echo 'timed out waiting for container %[5]v to report an IP-address' 1>&2
+exit 1`,
+		LXC_BIN,
+		applicationName,
+		DYNO_DELIMITER,
+		dyno.Version,
+		dyno.Container,
+		strings.Join(configCmds, "\n"),
+		fmt.Sprintf(bashLXCIPWaitCommand, dyno.Container),
+		appServiceWantsPath,
+	)
+}
+
+// appServiceWantsPath is the symlink which enables the app service in a
+// release image.
+const appServiceWantsPath = "/etc/systemd/system/multi-user.target.wants/app.service"
+
+// runExecCommand returns the remote command which runs a one-off command inside
+// its dyno, piping the output to the logserver and exiting with the command's
+// exit status.
+func runExecCommand(applicationName string, container string, record RunRecord) string {
+	process := record.ProcessName()
+	inner := fmt.Sprintf(`cd %[1]v/src && export PATH="$(find %[1]v/.shipbuilder -type d -wholename '*bin' -maxdepth 2):${PATH}" && ( envdir %[2]v /bin/bash -c %[3]v ; rc=$? ; echo "%[4]v exited with status ${rc}" ; exit ${rc} ) 2>&1 | %[1]v/%[5]v logger --host=%[6]v --app=%[7]v --process=%[4]v ; exit ${PIPESTATUS[0]}`,
+		APP_DIR,
+		ENV_DIR,
+		bashQuote(record.Command),
+		process,
+		BINARY,
+		DefaultSSHHost[strings.LastIndex(DefaultSSHHost, "@")+1:],
+		applicationName,
+	)
+	return fmt.Sprintf("%v exec %v -- /bin/bash -c %v", LXC_BIN, container, bashQuote(inner))
+}
+
+// finishRun records the outcome of a one-off command and destroys its dyno.
+func (server *Server) finishRun(e *Executor, applicationName string, dyno Dyno, record RunRecord, err error) RunRecord {
+	record.FinishedTs = time.Now()
+	record.State = RunSucceeded
+	if err != nil {
+		record.State = RunFailed
+		record.ExitStatus = -1
+		record.Error = err.Error()
+		if status, ok := exitStatus(err); ok {
+			record.ExitStatus = status
+		}
+	}
+	fmt.Fprintf(e.Logger, "%v %v after %v (exit status %v)\n", record.ProcessName(), record.State, record.FinishedTs.Sub(record.StartedTs), record.ExitStatus)
+
+	// The command has already exited, so there's nothing to give a grace period.
+	if err := dyno.Shutdown(e, 0); err != nil {
+		fmt.Fprintf(e.Logger, "Warning: failed to clean up dyno %v: %s\n", dyno.Container, err)
+	}
+	if err := updateRunRecord(applicationName, record); err != nil {
+		log.WithField("app", applicationName).Errorf("Recording outcome of %v failed: %s", record.ProcessName(), err)
+	}
+	activeRuns.Remove(dyno.Container)
+	return record
+}
+
+// sysFailOrphanedRuns marks recorded runs which are still running but no
+// longer supervised, e.g. because the server restarted mid-run, as failed.
+// Their dynos are destroyed by pruneDynos.
+func (server *Server) sysFailOrphanedRuns(logger io.Writer) error {
+	cfg, err := server.getConfig(true)
+	if err != nil {
+		return err
+	}
+	for _, app := range cfg.Applications {
+		failed, err := failOrphanedRunRecords(app.Name, activeRuns.Contains, time.Now())
+		if err != nil {
+			return err
+		}
+		for _, record := range failed {
+			fmt.Fprintf(logger, "Marked orphaned %v of app %v as failed (dyno %v on %v)\n", record.ProcessName(), app.Name, record.Container, record.Host)
+		}
+	}
+	return nil
+}
+
+// failOrphanedRunRecords marks an app's running records whose dynos aren't
+// active as failed, and returns them.
+func failOrphanedRunRecords(applicationName string, active func(container string) bool, now time.Time) ([]RunRecord, error) {
+	runRecordsLock.Lock()
+	defer runRecordsLock.Unlock()
+
+	records, err := ListRunRecords(applicationName)
+	if err != nil {
+		return nil, err
+	}
+	failed := []RunRecord{}
+	for i, record := range records {
+		if record.State != RunRunning || active(record.Container) {
+			continue
+		}
+		record.State = RunFailed
+		record.ExitStatus = -1
+		record.Error = "orphaned, the run was no longer supervised by the server (e.g. after a restart)"
+		record.FinishedTs = now
+		records[i] = record
+		failed = append(failed, record)
+	}
+	if len(failed) == 0 {
+		return failed, nil
+	}
+	return failed, writeRunRecords(applicationName, records)
+}
+
+// e.g. run:list -a myapp
+func (server *Server) Run_List(conn net.Conn, applicationName string) error {
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+		records, err := ListRunRecords(app.Name)
+		if err != nil {
+			return err
+		}
+		titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+		fmt.Fprintf(titleLogger, "=== One-off runs for %v\n\n", app.Name)
+		if len(records) == 0 {
+			fmt.Fprint(dimLogger, "No runs found\n")
+			return nil
+		}
+		fmt.Fprint(dimLogger, runRecordsTable(records))
+		return nil
+	})
+}
+
+// runRecordsTable renders run records as an aligned table, newest first.
+func runRecordsTable(records []RunRecord) string {
+	var (
+		buf = &bytes.Buffer{}
+		w   = tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+	)
+	fmt.Fprintf(w, "RUN\tSTATE\tEXIT\tSTARTED\tDURATION\tNODE\tCOMMAND\n")
+	for i := len(records) - 1; i >= 0; i-- {
+		var (
+			record   = records[i]
+			exit     = "-"
+			duration = "-"
+		)
+		if record.State != RunRunning {
+			exit = fmt.Sprint(record.ExitStatus)
+			duration = record.FinishedTs.Sub(record.StartedTs).Truncate(time.Second).String()
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", record.ProcessName(), record.State, exit, record.StartedTs.Format(time.RFC3339), duration, record.Host, record.Command)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+func runRecordsPath(applicationName string) string {
+	return filepath.Join(runsDirectory, applicationName+".json")
+}
+
+// ListRunRecords returns the recorded one-off runs for an app, oldest first.
+func ListRunRecords(applicationName string) ([]RunRecord, error) {
+	records := []RunRecord{}
+	data, err := ioutil.ReadFile(runRecordsPath(applicationName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("decoding runs file %q: %s", runRecordsPath(applicationName), err)
+	}
+	return records, nil
+}
+
+// addRunRecord records a new run for an app, assigning it the next run number.
+// Only the most recent maxRunRecords are retained.
+func addRunRecord(applicationName string, record RunRecord) (RunRecord, error) {
+	runRecordsLock.Lock()
+	defer runRecordsLock.Unlock()
+
+	records, err := ListRunRecords(applicationName)
+	if err != nil {
+		return record, err
+	}
+	record.Number = 1
+	if len(records) > 0 {
+		record.Number = records[len(records)-1].Number + 1
+	}
+	records = append(records, record)
+	if len(records) > maxRunRecords {
+		records = records[len(records)-maxRunRecords:]
+	}
+	return record, writeRunRecords(applicationName, records)
+}
+
+// updateRunRecord replaces the stored record having the same run number.
+func updateRunRecord(applicationName string, record RunRecord) error {
+	runRecordsLock.Lock()
+	defer runRecordsLock.Unlock()
+
+	records, err := ListRunRecords(applicationName)
+	if err != nil {
+		return err
+	}
+	for i := range records {
+		if records[i].Number == record.Number {
+			records[i] = record
+			return writeRunRecords(applicationName, records)
+		}
+	}
+	return nil
+}
+
+func writeRunRecords(applicationName string, records []RunRecord) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(runsDirectory, os.FileMode(int(0700))); err != nil {
+		return fmt.Errorf("creating runs directory: %s", err)
+	}
+	if err := ioutil.WriteFile(runRecordsPath(applicationName), data, os.FileMode(int(0600))); err != nil {
+		return fmt.Errorf("writing runs file %q: %s", runRecordsPath(applicationName), err)
+	}
+	return nil
+}
+
+// DeleteRunRecords removes the run records for an app.
+func DeleteRunRecords(applicationName string) error {
+	if err := os.Remove(runRecordsPath(applicationName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// bashQuote quotes a string for use as a single bash word.
+func bashQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
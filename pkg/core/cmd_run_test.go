@@ -0,0 +1,105 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBashQuote(t *testing.T) {
+	for i, s := range []string{
+		"./bin/backfill --since=2018-01-01",
+		`it's "quoted" $HOME $(whoami) \n`,
+		"",
+	} {
+		out, err := exec.Command("/bin/bash", "-c", "printf %s "+bashQuote(s)).Output()
+		if err != nil {
+			t.Fatalf("[i=%v] %s", i, err)
+		}
+		if string(out) != s {
+			t.Errorf("[i=%v] Expected %q but actual=%q", i, s, string(out))
+		}
+	}
+}
+
+func TestRunExecCommand(t *testing.T) {
+	record := RunRecord{Number: 7, Command: "./bin/task 'a b'"}
+	cmd := runExecCommand("myapp", "myapp-v3-run-10001", record)
+	if !strings.HasPrefix(cmd, LXC_BIN+" exec myapp-v3-run-10001 -- /bin/bash -c ") {
+		t.Errorf("Expected lxc exec into the dyno but actual=%v", cmd)
+	}
+	for _, expected := range []string{"--app=myapp", "--process=run.7", `${PIPESTATUS[0]}`} {
+		if !strings.Contains(cmd, expected) {
+			t.Errorf("Expected command to contain %q but actual=%v", expected, cmd)
+		}
+	}
+}
+
+func TestRunDynoStartCommand(t *testing.T) {
+	dyno := Dyno{Container: "myapp-v3-run-10001", Version: "v3"}
+	cmd := runDynoStartCommand("myapp", dyno, map[string]string{"process": DYNO_PROCESS_RUN})
+	var (
+		disable = strings.Index(cmd, LXC_BIN+" file delete myapp-v3-run-10001"+appServiceWantsPath)
+		verify  = strings.Index(cmd, LXC_BIN+" file pull myapp-v3-run-10001"+appServiceWantsPath)
+		start   = strings.Index(cmd, LXC_BIN+" start myapp-v3-run-10001")
+	)
+	// The release image's app service must never boot with the build's /app/run.
+	if disable == -1 || verify == -1 || start == -1 || !(disable < verify && verify < start) {
+		t.Errorf("Expected the app service to be disabled before the dyno starts but actual=%v", cmd)
+	}
+}
+
+func TestFailOrphanedRunRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shipbuilder-runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	previous := runsDirectory
+	runsDirectory = dir
+	defer func() { runsDirectory = previous }()
+
+	for _, record := range []RunRecord{
+		{Container: "myapp-v3-run-10001", State: RunSucceeded},
+		{Container: "myapp-v3-run-10002", State: RunRunning},
+		{Container: "myapp-v3-run-10003", State: RunRunning},
+	} {
+		if _, err := addRunRecord("myapp", record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		runs   = ActiveRuns{containers: map[string]bool{}}
+		now    = time.Now()
+		failed []RunRecord
+	)
+	runs.Add("myapp-v3-run-10003")
+	if failed, err = failOrphanedRunRecords("myapp", runs.Contains, now); err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].Number != 2 {
+		t.Fatalf("Expected only run.2 to be orphaned but actual=%+v", failed)
+	}
+
+	records, err := ListRunRecords("myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := []string{RunSucceeded, RunFailed, RunRunning}, []string{records[0].State, records[1].State, records[2].State}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected states=%v but actual=%v", expected, actual)
+	}
+	if records[1].ExitStatus != -1 || !records[1].FinishedTs.Equal(now) || len(records[1].Error) == 0 {
+		t.Errorf("Expected orphaned run to be recorded as failed but actual=%+v", records[1])
+	}
+
+	// Once the supervised run is no longer tracked it is orphaned too.
+	runs.Remove("myapp-v3-run-10003")
+	if failed, err = failOrphanedRunRecords("myapp", runs.Contains, now); err != nil || len(failed) != 1 || failed[0].Number != 3 {
+		t.Errorf("Expected run.3 to be orphaned but actual=%+v err=%v", failed, err)
+	}
+}
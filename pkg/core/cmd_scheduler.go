@@ -0,0 +1,95 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (server *Server) Scheduler_List(conn net.Conn, applicationName string) error {
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+		titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+		fmt.Fprintf(titleLogger, "=== Scheduled jobs for %v\n\n", applicationName)
+		if len(app.Schedules) == 0 {
+			fmt.Fprint(dimLogger, "No scheduled jobs\n")
+			return nil
+		}
+		now := time.Now()
+		for _, job := range app.Schedules {
+			next := "unknown"
+			if schedule, err := parseSchedule(job.Schedule); err == nil {
+				next = schedule.Next(now).Format(time.RFC3339)
+			}
+			fmt.Fprintf(dimLogger, "%v (next run at %v)\n", job, next)
+		}
+		return nil
+	})
+}
+
+// e.g. scheduler:add -amyApp "0 3 * * *" -- ./bin/cleanup --days=30
+func (server *Server) Scheduler_Add(conn net.Conn, applicationName string, schedule string, args []string) error {
+	command := strings.TrimSpace(strings.Join(args, " "))
+	if len(command) == 0 {
+		return fmt.Errorf(`a command is required, e.g. scheduler:add -a %v "0 3 * * *" -- ./bin/cleanup`, applicationName)
+	}
+	if _, err := parseSchedule(schedule); err != nil {
+		return err
+	}
+	return server.WithPersistentApplication(applicationName, func(app *Application, cfg *Config) error {
+		job := ScheduledJob{Id: 1, Schedule: schedule, Command: command}
+		for _, existing := range app.Schedules {
+			if existing.Id >= job.Id {
+				job.Id = existing.Id + 1
+			}
+		}
+		app.Schedules = append(app.Schedules, job)
+		titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+		fmt.Fprintf(titleLogger, "=== Adding scheduled job for %v\n\n", applicationName)
+		fmt.Fprintf(dimLogger, "Added scheduled job: %v\n", job)
+		if len(app.LastDeploy) == 0 {
+			fmt.Fprint(dimLogger, "Warning: scheduled jobs won't run until the app has been deployed\n")
+		}
+		return nil
+	})
+}
+
+// e.g. scheduler:remove -amyApp 2
+func (server *Server) Scheduler_Remove(conn net.Conn, applicationName string, ids []string) error {
+	if len(ids) == 0 {
+		return fmt.Errorf("one or more scheduled job ids are required, see scheduler:list")
+	}
+	remove := map[int]bool{}
+	for _, id := range ids {
+		n, err := strconv.Atoi(strings.TrimPrefix(id, "#"))
+		if err != nil {
+			return fmt.Errorf("invalid scheduled job id %q", id)
+		}
+		remove[n] = true
+	}
+	return server.WithPersistentApplication(applicationName, func(app *Application, cfg *Config) error {
+		titleLogger, dimLogger := server.getTitleAndDimLoggers(conn)
+		fmt.Fprintf(titleLogger, "=== Removing scheduled jobs for %v\n\n", applicationName)
+		jobs := []ScheduledJob{}
+		for _, job := range app.Schedules {
+			if remove[job.Id] {
+				fmt.Fprintf(dimLogger, "Removed scheduled job: %v\n", job)
+				delete(remove, job.Id)
+				continue
+			}
+			jobs = append(jobs, job)
+		}
+		if len(remove) > 0 {
+			missing := []string{}
+			for _, id := range ids {
+				if n, _ := strconv.Atoi(strings.TrimPrefix(id, "#")); remove[n] {
+					missing = append(missing, id)
+				}
+			}
+			return fmt.Errorf("unrecognized scheduled job id(s): %v", strings.Join(missing, ", "))
+		}
+		app.Schedules = jobs
+		return nil
+	})
+}
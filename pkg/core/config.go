@@ -10,6 +10,7 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,31 +23,59 @@ import (
 )
 
 const (
-	APP_DIR                            = "/app"
-	ENV_DIR                            = APP_DIR + "/env"
-	LXC_DIR                            = "/var/lib/lxd/storage-pools/tank/containers/" // "/var/snap/lxd/common/lxd/containers" // "/var/lib/lxd/storage-pools/tank/containers" // "/tank/lxc" // "/var/lib/lxc"
-	LXC_BIN                            = "/snap/bin/lxc"
-	ZFS_CONTAINER_MOUNT                = "tank/containers"
-	DIRECTORY                          = "/etc/shipbuilder"
-	BINARY                             = "shipbuilder"
-	EXE                                = "/usr/bin/" + BINARY
-	CONFIG                             = DIRECTORY + "/config.json"
-	GIT_DIRECTORY                      = "/git"
-	DEFAULT_NODE_USERNAME              = "ubuntu"
-	NODE_SYNC_TIMEOUT_SECONDS          = 180
-	DYNO_START_TIMEOUT_SECONDS         = 120
-	LOAD_BALANCER_SYNC_TIMEOUT_SECONDS = 45
-	DEPLOY_TIMEOUT_SECONDS             = 240
-	STATUS_MONITOR_INTERVAL_SECONDS    = 15
-	DEFAULT_SSH_PARAMETERS             = "-o StrictHostKeyChecking=no -o BatchMode=yes -o ConnectTimeout=30" // NB: Notice 30s connect timeout.
+	APP_DIR                               = "/app"
+	ENV_DIR                               = APP_DIR + "/env"
+	LXC_DIR                               = "/var/lib/lxd/storage-pools/tank/containers/" // "/var/snap/lxd/common/lxd/containers" // "/var/lib/lxd/storage-pools/tank/containers" // "/tank/lxc" // "/var/lib/lxc"
+	LXC_BIN                               = "/snap/bin/lxc"
+	ZFS_CONTAINER_MOUNT                   = "tank/containers"
+	DIRECTORY                             = "/etc/shipbuilder"
+	BINARY                                = "shipbuilder"
+	EXE                                   = "/usr/bin/" + BINARY
+	CONFIG                                = DIRECTORY + "/config.json"
+	GIT_DIRECTORY                         = "/git"
+	DEPLOY_LOGS_DIRECTORY                 = DIRECTORY + "/deploys"
+	DEPLOY_STATE_DIRECTORY                = DIRECTORY + "/deploy-state"
+	SSH_KEYS_DIRECTORY                    = DIRECTORY + "/ssh-keys"
+	AUTOSCALE_EVENTS_DIRECTORY            = DIRECTORY + "/autoscale"
+	PORTS_FILE                            = DIRECTORY + "/ports.json"
+	RUNS_DIRECTORY                        = DIRECTORY + "/runs"
+	CONTAINER_SSH_DIR                     = APP_DIR + "/.ssh-build"
+	CONTAINER_SSH_PRIVATE_KEY             = CONTAINER_SSH_DIR + "/id_rsa"
+	DEFAULT_NODE_USERNAME                 = "ubuntu"
+	NODE_SYNC_TIMEOUT_SECONDS             = 180
+	DYNO_START_TIMEOUT_SECONDS            = 120
+	LOAD_BALANCER_SYNC_TIMEOUT_SECONDS    = 45
+	HAPROXY_SERVER_SLOTS                  = 10
+	DEPLOY_TIMEOUT_SECONDS                = 240
+	STATUS_MONITOR_INTERVAL_SECONDS       = 15
+	AUTOSCALE_INTERVAL_SECONDS            = 60
+	SCHEDULER_INTERVAL_SECONDS            = 60
+	DEFAULT_DRAIN_TIMEOUT_SECONDS         = 30
+	DEFAULT_SHUTDOWN_GRACE_PERIOD_SECONDS = 10
+	DEFAULT_MAX_ARCHIVE_BYTES             = 512 * 1024 * 1024                                                   // Largest archive accepted by deploy:archive.
+	DEFAULT_SSH_PARAMETERS                = "-o StrictHostKeyChecking=no -o BatchMode=yes -o ConnectTimeout=30" // NB: Notice 30s connect timeout.
 )
 
 const (
-	bashHAProxyReloadCommand = `/bin/bash -c 'set -o errexit ; set -o pipefail ; if [ "$(sudo systemctl status haproxy | grep --only-matching "Active: [^ ]\+" | cut -d " " -f 2)" = "inactive" ] ; then sudo systemctl start haproxy ; else sudo systemctl reload haproxy ; fi'`
+	haProxyConfigPath = "/etc/haproxy/haproxy.cfg"
+	nginxConfigPath   = "/etc/nginx/nginx.conf"
+
+	// Rendered load-balancer configs are staged next to the installed config
+	// until they pass validation, and the config they replace is kept for
+	// rollbacks.
+	stagedConfigSuffix   = ".new"
+	previousConfigSuffix = ".previous"
+
+	bashHAProxyValidateCommand = `sudo haproxy -c -q -f ` + haProxyConfigPath + stagedConfigSuffix
+	bashNginxValidateCommand   = `sudo nginx -t -q -c ` + nginxConfigPath + stagedConfigSuffix
 )
 
 var defaultSSHParametersList = strings.Split(DEFAULT_SSH_PARAMETERS, " ")
 
+// gitDirectory is where the apps' bare git repositories live, overridable for
+// tests.
+var gitDirectory = GIT_DIRECTORY
+
 // Global configuration.
 var (
 	// TODO: Remove "Default" prefix from all these vars.
@@ -87,29 +116,38 @@ type Application struct {
 	Maintenance   bool
 	Drains        []string
 	SSHPrivateKey *string
+	Placement     string                     // Dyno placement strategy, defaults to DefaultPlacement when empty.
+	Limits        map[string]ProcessLimits   // Dyno container limits per (normalized) process type.
+	Constraints   []PlacementConstraint      // Node label requirements for dynos.
+	Autoscale     map[string]AutoscalePolicy // Autoscaling policies per process type.
+	NoReconcile   bool                       // Disables automatic replacement of missing dynos.
+	Schedules     []ScheduledJob             // Commands run periodically in one-off dynos.
 }
 
 type Node struct {
-	Host string
+	Host     string
+	Labels   map[string]string
+	Cordoned bool // Cordoned nodes keep running their dynos, but no new dynos are placed on them.
 }
 
 type Config struct {
-	LoadBalancers []string
-	Nodes         []*Node
-	Port          int
-	GitRoot       string
-	LxcRoot       string
-	Applications  []*Application
+	LoadBalancers       []string
+	LoadBalancerDrivers map[string]string // Driver name per load-balancer host, LBDriverHAProxy when unset.
+	Nodes               []*Node
+	Port                int
+	GitRoot             string
+	LxcRoot             string
+	Applications        []*Application
 }
 
 func (app *Application) BareGitDir() string {
-	return GIT_DIRECTORY + "/" + app.Name
+	return gitDirectory + "/" + app.Name
 }
 func (app *Application) SSHDir() string {
-	panic("SSHDir is *DECPRECATED*, DISCONTINUE ALL USE")
+	return SSH_KEYS_DIRECTORY + "/" + app.Name
 }
 func (app *Application) SSHPrivateKeyFilePath() string {
-	panic("SSHPrivateKeyFilePath is *DECPRECATED*, DISCONTINUE ALL USE")
+	return app.SSHDir() + "/id_rsa"
 }
 func (app *Application) BaseContainerName() string {
 	return "base-" + app.BuildPack
@@ -129,6 +167,17 @@ func (app *Application) TotalRequestedDynos() int {
 	return n
 }
 
+// Whether the app has a process type, compared by normalized name.
+func (app *Application) hasProcess(process string) bool {
+	process = normalizeAppProcessName(process)
+	for p := range app.Processes {
+		if normalizeAppProcessName(p) == process {
+			return true
+		}
+	}
+	return false
+}
+
 // Get any valid domain for the app.  HAProxy will use this to formulate checks which are maximally valid, compliant and compatible.
 // Note: Not a pointer because this needs to be available for invocation from inside templates.
 // Also see: http://stackoverflow.com/questions/10200178/call-a-method-from-a-go-template
@@ -191,6 +240,37 @@ func (app *Application) MaintenancePageDomain() string {
 	return u.Host
 }
 
+// DrainTimeout is how long web dynos are given to finish their in-flight
+// requests on the load-balancers before being removed, configurable with the
+// SB_DRAIN_TIMEOUT app environment variable.
+func (app *Application) DrainTimeout() time.Duration {
+	return app.durationFromEnv("SB_DRAIN_TIMEOUT", DEFAULT_DRAIN_TIMEOUT_SECONDS*time.Second)
+}
+
+// ShutdownGracePeriod is how long a dyno's app is given to exit after SIGTERM
+// before its container is forcibly stopped, configurable with the
+// SB_SHUTDOWN_GRACE_PERIOD app environment variable.
+func (app *Application) ShutdownGracePeriod() time.Duration {
+	return app.durationFromEnv("SB_SHUTDOWN_GRACE_PERIOD", DEFAULT_SHUTDOWN_GRACE_PERIOD_SECONDS*time.Second)
+}
+
+// durationFromEnv parses an app environment variable holding either a number
+// of seconds or a duration such as "2m".
+func (app *Application) durationFromEnv(key string, defaultValue time.Duration) time.Duration {
+	value, ok := app.Environment[key]
+	if !ok || len(value) == 0 {
+		return defaultValue
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return d
+	}
+	log.WithField("app", app.Name).Warnf("Ignoring invalid %v value %q, using default of %v", key, value, defaultValue)
+	return defaultValue
+}
+
 func (app *Application) NextVersion() (string, error) {
 	if app.LastDeploy == "" {
 		return "v1", nil
@@ -375,19 +455,12 @@ func (server *Server) ResolveLogServerIpAndPort() (string, error) {
 	return ip + ":" + port, nil
 }
 
-// TODO: Check for ignored errors.
-func (server *Server) SyncLoadBalancers(e *Executor, addDynos []Dyno, removeDynos []Dyno) error {
-	syncLoadBalancerLock.Lock()
-	defer syncLoadBalancerLock.Unlock()
-
-	cfg, err := server.getConfig(true)
-	if err != nil {
-		return err
-	}
-
+// loadBalancerSpec returns the spec the HAProxy config is rendered from.  Each
+// app routes to its running web dynos, less removeDynos, plus addDynos.
+func (server *Server) loadBalancerSpec(cfg *Config, addDynos []Dyno, removeDynos []Dyno) (*LBSpec, error) {
 	logServerIpAndPort, err := server.ResolveLogServerIpAndPort()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	lbSpec := &LBSpec{
@@ -396,6 +469,7 @@ func (server *Server) SyncLoadBalancers(e *Executor, addDynos []Dyno, removeDyno
 		LoadBalancers:       cfg.LoadBalancers,
 		HaProxyStatsEnabled: isTruthy(DefaultHAProxyStats),
 		HaProxyCredentials:  HaProxyCredentials(),
+		ServerSlots:         HAPROXY_SERVER_SLOTS,
 	}
 
 	for _, app := range cfg.Applications {
@@ -416,7 +490,7 @@ func (server *Server) SyncLoadBalancers(e *Executor, addDynos []Dyno, removeDyno
 				// Find and don't add `removeDynos`.
 				runningDynos, err := server.GetRunningDynos(app.Name, proc)
 				if err != nil {
-					return err
+					return nil, err
 				}
 				for _, dyno := range runningDynos {
 					found := false
@@ -431,7 +505,7 @@ func (server *Server) SyncLoadBalancers(e *Executor, addDynos []Dyno, removeDyno
 					}
 					port, err := strconv.Atoi(dyno.Port)
 					if err != nil {
-						return err
+						return nil, err
 					}
 					a.Servers = append(a.Servers, &LBAppDyno{
 						Host: dyno.Host,
@@ -440,10 +514,10 @@ func (server *Server) SyncLoadBalancers(e *Executor, addDynos []Dyno, removeDyno
 				}
 				// Add `addDynos` if type is "web" and it matches the current application and process.
 				for _, addDyno := range addDynos {
-					if addDyno.Application == app.Name && addDyno.Process == proc {
+					if addDyno.Application == app.Name && sameProcess(addDyno.Process, proc) {
 						port, err := strconv.Atoi(addDyno.Port)
 						if err != nil {
-							return err
+							return nil, err
 						}
 
 						candidateServer := &LBAppDyno{
@@ -467,73 +541,140 @@ func (server *Server) SyncLoadBalancers(e *Executor, addDynos []Dyno, removeDyno
 		}
 		lbSpec.Applications = append(lbSpec.Applications, a)
 	}
+	return lbSpec, nil
+}
+
+// renderLoadBalancerConfig renders the HAProxy config for a spec.
+func renderLoadBalancerConfig(lbSpec *LBSpec) (string, error) {
+	buf := bytes.Buffer{}
+	if err := HAPROXY_CONFIG.Execute(&buf, lbSpec); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// TODO: Check for ignored errors.
+func (server *Server) SyncLoadBalancers(e *Executor, addDynos []Dyno, removeDynos []Dyno) error {
+	syncLoadBalancerLock.Lock()
+	defer syncLoadBalancerLock.Unlock()
+	server.loadBalancerSyncs++
+
+	cfg, err := server.getConfig(true)
+	if err != nil {
+		return err
+	}
 
-	// Save it to the load balancer
-	hapFileLoc := "/tmp/haproxy.cfg"
-	f, err := os.OpenFile(hapFileLoc, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(int(0666)))
+	lbSpec, err := server.loadBalancerSpec(cfg, addDynos, removeDynos)
 	if err != nil {
 		return err
 	}
 
+	// Each kind of load-balancer in use gets its own rendering of the spec.  A
+	// config which fails validation is never pushed to the load-balancers.
+	var (
+		drivers        = map[string]LoadBalancerDriver{} // By load-balancer host.
+		paths          = map[string]string{}             // Rendered config locations by driver name.
+		desiredConfigs = map[string]string{}             // Rendered configs by driver name.
+	)
+
 	defer func() {
-		if rmErr := os.Remove(hapFileLoc); rmErr != nil {
-			log.Warnf("Unexpected problem during cleanup removal of %q: %s", hapFileLoc, rmErr)
+		for _, path := range paths {
+			if rmErr := os.Remove(path); rmErr != nil {
+				log.Warnf("Unexpected problem during cleanup removal of %q: %s", path, rmErr)
+			}
 		}
 	}()
 
-	err = HAPROXY_CONFIG.Execute(f, lbSpec)
-
-	if closeErr := f.Close(); closeErr != nil {
-		log.Warnf("Unexpected problem closing %q: %s", hapFileLoc, closeErr)
+	for _, host := range cfg.LoadBalancers {
+		driver, err := server.loadBalancerDriver(cfg, host)
+		if err != nil {
+			return err
+		}
+		drivers[host] = driver
+		if _, ok := paths[driver.Name()]; ok {
+			continue
+		}
+		rendered, err := driver.Render(lbSpec)
+		if err != nil {
+			return err
+		}
+		path := "/tmp/" + filepath.Base(driver.ConfigPath())
+		if err := ioutil.WriteFile(path, []byte(rendered), os.FileMode(int(0666))); err != nil {
+			return err
+		}
+		paths[driver.Name()] = path
+		if err := driver.Validate(path); err != nil {
+			return err
+		}
+		desiredConfigs[driver.Name()] = rendered
 	}
+	server.desiredLoadBalancerConfigs = desiredConfigs
 
+	// When nothing but app servers changed since the last full sync,
+	// load-balancers whose driver supports it are updated through a runtime API
+	// instead of being reloaded.  The config is still written out so it
+	// reflects the running state.
+	structure, err := renderLoadBalancerStructure(lbSpec)
 	if err != nil {
 		return err
 	}
+	var (
+		runtime = len(server.currentLoadBalancerStructure) > 0 && structure == server.currentLoadBalancerStructure
+		desired = haProxyDesiredServers(lbSpec)
+	)
 
 	type LBSyncResult struct {
-		lbHost string
-		err    error
+		lbHost  string
+		outcome string
+		err     error
 	}
 
 	syncChannel := make(chan LBSyncResult)
 	for _, host := range cfg.LoadBalancers {
 		go func(host string) {
-			c := make(chan error, 1)
+			c := make(chan LBSyncResult, 2)
 			go func() {
-				err := e.Run("rsync",
-					"-azve", "ssh "+DEFAULT_SSH_PARAMETERS,
-					"/tmp/haproxy.cfg", "root@"+host+":/etc/haproxy/",
-				)
-				if err != nil {
-					c <- err
-					return
-				}
-				if err = e.Run("ssh", DEFAULT_NODE_USERNAME+"@"+host, bashHAProxyReloadCommand); err != nil {
-					c <- err
-					return
-				}
-				c <- nil
+				driver := drivers[host]
+				outcome, err := syncLoadBalancer(e, host, driver, paths[driver.Name()], runtime, desired)
+				c <- LBSyncResult{host, outcome, err}
 			}()
 			go func() {
 				time.Sleep(LOAD_BALANCER_SYNC_TIMEOUT_SECONDS * time.Second)
-				c <- fmt.Errorf("LB sync operation to %q timed out after %v seconds", host, LOAD_BALANCER_SYNC_TIMEOUT_SECONDS)
+				c <- LBSyncResult{host, "", fmt.Errorf("LB sync operation to %q timed out after %v seconds", host, LOAD_BALANCER_SYNC_TIMEOUT_SECONDS)}
 			}()
 			// Block until chan has something, at which point syncChannel will be notified.
-			syncChannel <- LBSyncResult{host, <-c}
+			syncChannel <- <-c
 		}(host)
 	}
 
-	nLoadBalancers := len(cfg.LoadBalancers)
-	errors := []error{}
+	var (
+		nLoadBalancers = len(cfg.LoadBalancers)
+		errors         = []error{}
+		routes         = lbSpec.Routes()
+	)
 	for i := 1; i <= nLoadBalancers; i++ {
 		syncResult := <-syncChannel
 		if syncResult.err != nil {
 			errors = append(errors, syncResult.err)
+			fmt.Fprintf(e.Logger, "Load-balancer %v sync failed: %s\n", syncResult.lbHost, syncResult.err)
+			// What the load-balancer is routing is unknown until its config is
+			// read again.
+			delete(server.loadBalancerRoutes, syncResult.lbHost)
+		} else {
+			fmt.Fprintf(e.Logger, "Load-balancer %v sync succeeded: %v\n", syncResult.lbHost, syncResult.outcome)
+			server.setLoadBalancerRoutes(syncResult.lbHost, routes)
 		}
 		fmt.Fprintf(e.Logger, "%v/%v load-balancer sync finished (%v succeeded, %v failed, %v outstanding)\n", i, nLoadBalancers, i-len(errors), len(errors), nLoadBalancers-i)
 	}
 
+	// A load-balancer which failed to sync may be out of date, so the next sync
+	// has to be a full one.
+	if len(errors) == 0 {
+		server.currentLoadBalancerStructure = structure
+	} else {
+		server.currentLoadBalancerStructure = ""
+	}
+
 	// If all LB updates failed, abort with error.
 	if nLoadBalancers > 0 && len(errors) == nLoadBalancers {
 		err = fmt.Errorf("error: all load-balancer updates failed: %v", errors)
@@ -541,42 +682,9 @@ func (server *Server) SyncLoadBalancers(e *Executor, addDynos []Dyno, removeDyno
 		return err
 	}
 
-	// Uddate `currentLoadBalancerConfig` with updated HAProxy configuration.
-	cfgBuffer := bytes.Buffer{}
-	if err = HAPROXY_CONFIG.Execute(&cfgBuffer, lbSpec); err != nil {
-		return err
-	}
-	server.currentLoadBalancerConfig = cfgBuffer.String()
-
 	return nil
 }
 
-// The first time this method is invoked the current config will read from a load-balancer, if one is available.
-// Subsequent invocations will use the current version.
-// After a deployment, the `SyncLoadBalancers` method automatically updates `currentLoadBalancerConfig`.
-func (server *Server) GetActiveLoadBalancerConfig() (string, error) {
-	if len(server.currentLoadBalancerConfig) == 0 {
-		cfg, err := server.getConfig(true)
-		if err != nil {
-			return server.currentLoadBalancerConfig, err
-		}
-		if len(cfg.LoadBalancers) == 0 {
-			return server.currentLoadBalancerConfig, fmt.Errorf("There are currently no load-balancers configured to pull LB config from")
-		}
-
-		syncLoadBalancerLock.Lock()
-		defer syncLoadBalancerLock.Unlock()
-		server.currentLoadBalancerConfig, err = RemoteCommand(cfg.LoadBalancers[0], "sudo cat /etc/haproxy/haproxy.cfg")
-		if err != nil {
-			return server.currentLoadBalancerConfig, err
-		}
-	} else {
-		syncLoadBalancerLock.Lock()
-		defer syncLoadBalancerLock.Unlock()
-	}
-	return server.currentLoadBalancerConfig, nil
-}
-
 // TODO: Replace with gigawattio/oslib.
 func PathExists(path string) (bool, error) {
 	_, err := os.Stat(path)
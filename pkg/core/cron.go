@@ -35,6 +35,18 @@ func (server *Server) GetCronTasks() []CronTask {
 			Schedule: "1 1 * * * *",
 			Fn:       server.sysSyncNtp,
 		},
+		// Load-balancer config drift check.
+		CronTask{
+			Name:     "LoadBalancerDrift",
+			Schedule: "1 */15 * * * *",
+			Fn:       server.sysCheckLoadBalancerDrift,
+		},
+		// Orphaned one-off runs.
+		CronTask{
+			Name:     "OrphanedRuns",
+			Schedule: "1 */5 * * * *",
+			Fn:       server.sysFailOrphanedRuns,
+		},
 	}
 	return cronTasks
 }
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gigawattio/oslib"
+	log "github.com/sirupsen/logrus"
+)
+
+// archiveRevisionPrefix is prepended to the content hash of an uploaded
+// archive to form the release revision, distinguishing it from git revisions.
+const archiveRevisionPrefix = "archive:"
+
+// isArchiveRevision returns true when the revision refers to an uploaded
+// archive rather than a git commit.
+func isArchiveRevision(revision string) bool {
+	return strings.HasPrefix(revision, archiveRevisionPrefix)
+}
+
+// shortRevision abbreviates a git or archive-based revision for display.
+func shortRevision(revision string) string {
+	prefix := ""
+	if isArchiveRevision(revision) {
+		prefix = archiveRevisionPrefix
+		revision = strings.TrimPrefix(revision, archiveRevisionPrefix)
+	}
+	if len(revision) > 7 {
+		revision = revision[0:7]
+	}
+	return prefix + revision
+}
+
+// maxArchiveBytes returns the largest archive deploy:archive accepts, set in
+// bytes with the SB_MAX_ARCHIVE_BYTES environment variable.
+func maxArchiveBytes() int64 {
+	value := ConfigFromEnv("SB_MAX_ARCHIVE_BYTES", "")
+	if len(value) == 0 {
+		return DEFAULT_MAX_ARCHIVE_BYTES
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		log.Warnf("Ignoring invalid SB_MAX_ARCHIVE_BYTES=%q, using the default of %v bytes", value, DEFAULT_MAX_ARCHIVE_BYTES)
+		return DEFAULT_MAX_ARCHIVE_BYTES
+	}
+	return n
+}
+
+// uploadReader reads the content of an upload from a stream of UploadData
+// messages, terminated by an empty one.
+type uploadReader struct {
+	conn net.Conn
+	buf  []byte
+	done bool
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		msg, err := Receive(r.conn)
+		if err != nil {
+			return 0, fmt.Errorf("receiving archive: %s", err)
+		}
+		if msg.Type == Error {
+			return 0, fmt.Errorf("client aborted upload: %v", msg.Body)
+		}
+		if msg.Type != UploadData {
+			return 0, fmt.Errorf("Got unexpected message type %v, wanted `UploadData`", msg.Type)
+		}
+		if len(msg.Body) == 0 {
+			r.done = true
+		}
+		r.buf = []byte(msg.Body)
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// receiveArchive requests an upload from the client and stores the streamed
+// content in a temporary file.  Archives larger than maxBytes are rejected.
+//
+// Returns the path to the temporary file and the archive-based revision.  The
+// caller is responsible for removing the file.
+func receiveArchive(conn net.Conn, applicationName string, filename string, maxBytes int64) (string, string, error) {
+	if !strings.HasSuffix(filename, ".tar.gz") && !strings.HasSuffix(filename, ".tgz") && !strings.HasSuffix(filename, ".tar") {
+		return "", "", fmt.Errorf("unsupported archive %q, must be one of: .tar.gz, .tgz, .tar", filename)
+	}
+
+	file, err := ioutil.TempFile("", "shipbuilder-archive-"+applicationName+"-")
+	if err != nil {
+		return "", "", fmt.Errorf("creating temporary archive file: %s", err)
+	}
+	defer file.Close()
+
+	fail := func(err error) (string, string, error) {
+		if rmErr := os.Remove(file.Name()); rmErr != nil {
+			log.WithField("app", applicationName).Errorf("Problem removing temporary archive file %q: %s", file.Name(), rmErr)
+		}
+		return "", "", err
+	}
+
+	if err := Send(conn, Message{UploadRequest, filename}); err != nil {
+		return fail(err)
+	}
+
+	hash := sha256.New()
+	// NB: One byte past the limit is read so oversized archives can be told
+	// apart from ones exactly at it.
+	n, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(&uploadReader{conn: conn}, maxBytes+1))
+	if err != nil {
+		return fail(fmt.Errorf("writing archive: %s", err))
+	}
+	if n > maxBytes {
+		return fail(fmt.Errorf("archive exceeds the maximum size of %v bytes", maxBytes))
+	}
+	if n == 0 {
+		return fail(fmt.Errorf("received empty archive"))
+	}
+
+	revision := archiveRevisionPrefix + hex.EncodeToString(hash.Sum(nil))
+	log.WithField("app", applicationName).WithField("bytes", n).WithField("revision", revision).Debug("Received archive")
+	return file.Name(), revision, nil
+}
+
+// archiveExtract unpacks the uploaded archive into /app/src inside the
+// container.  Used in place of gitClone and containerCodeInit.
+func (d *Deployment) archiveExtract() error {
+	dst := oslib.OsPath(string(os.PathSeparator)+"tmp", "src.tar")
+	if err := d.b64FileIntoContainer(d.Archive, dst, "600"); err != nil {
+		return err
+	}
+	if err := d.lxcExecf("rm -rf /app/src && mkdir -p /app/src && tar -xf %[1]v -C /app/src && rm -f %[1]v && chown -R ubuntu:ubuntu /app/src", dst); err != nil {
+		return fmt.Errorf("extracting archive into container: %s", err)
+	}
+	return nil
+}
+
+// archiveContent gets a file from the top-level of the deployment's uploaded
+// archive.
+func (d *Deployment) archiveContent(file string) (io.Reader, error) {
+	fd, err := os.Open(d.Archive)
+	if err != nil {
+		return nil, fmt.Errorf("opening archive for app=%v: %s", d.Application.Name, err)
+	}
+	defer fd.Close()
+
+	var (
+		br           = bufio.NewReader(fd)
+		r  io.Reader = br
+	)
+	// Transparently handle both gzipped and uncompressed tarballs.
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing archive for app=%v: %s", d.Application.Name, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, os.ErrNotExist
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading archive for app=%v: %s", d.Application.Name, err)
+		}
+		if path.Clean(hdr.Name) != file || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving app=%v %q content from archive: %s", d.Application.Name, file, err)
+		}
+		return bytes.NewReader(content), nil
+	}
+}
+
+// sourceContent gets a file from the deployment's source, which is either the
+// uploaded archive or the apps' bare git repository.
+func (d *Deployment) sourceContent(file string) (io.Reader, error) {
+	if len(d.Archive) > 0 {
+		return d.archiveContent(file)
+	}
+	return d.bareRepoContent(file)
+}
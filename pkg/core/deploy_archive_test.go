@@ -0,0 +1,133 @@
+package core
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDeploymentArchiveContent(t *testing.T) {
+	files := map[string]string{
+		"./Procfile":    "web: ./run-server\n",
+		"lib/.packages": "libxml2\n",
+		".ppas":         "ppa:foo/bar\n",
+	}
+
+	for i, compressed := range []bool{true, false} {
+		archive := writeTestArchive(t, files, compressed)
+		defer os.Remove(archive)
+
+		d := &Deployment{
+			Application: &Application{Name: "test-app"},
+			Archive:     archive,
+		}
+
+		testCases := []struct {
+			file     string
+			expected string
+			err      error
+		}{
+			{file: "Procfile", expected: "web: ./run-server\n"},
+			{file: ".ppas", expected: "ppa:foo/bar\n"},
+			{file: ".packages", err: os.ErrNotExist},
+		}
+
+		for j, testCase := range testCases {
+			r, err := d.sourceContent(testCase.file)
+			if err != testCase.err {
+				t.Errorf("[i=%v j=%v] Expected err=%v but actual=%v", i, j, testCase.err, err)
+				continue
+			}
+			if err != nil {
+				continue
+			}
+			content, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("[i=%v j=%v] %s", i, j, err)
+			}
+			if actual := string(content); actual != testCase.expected {
+				t.Errorf("[i=%v j=%v] Expected content=%q but actual=%q", i, j, testCase.expected, actual)
+			}
+		}
+	}
+}
+
+func TestReceiveArchive(t *testing.T) {
+	content := strings.Repeat("x", 3*uploadChunkSize+10)
+
+	receive := func(maxBytes int64) (string, string, error) {
+		server, client := net.Pipe()
+		defer server.Close()
+		go func() {
+			defer client.Close()
+			if msg, err := Receive(client); err != nil || msg.Type != UploadRequest {
+				return
+			}
+			(&Client{Upload: strings.NewReader(content)}).upload(client)
+		}()
+		return receiveArchive(server, "myapp", "src.tar.gz", maxBytes)
+	}
+
+	path, revision, err := receive(int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+	if received, err := ioutil.ReadFile(path); err != nil || string(received) != content {
+		t.Errorf("Expected %v bytes to be received but actual=%v (err=%v)", len(content), len(received), err)
+	}
+	if !isArchiveRevision(revision) {
+		t.Errorf("Expected an archive revision but actual=%q", revision)
+	}
+
+	if path, _, err := receive(int64(len(content) - 1)); err == nil {
+		os.Remove(path)
+		t.Errorf("Expected an archive over the maximum size to be rejected")
+	}
+}
+
+func writeTestArchive(t *testing.T, files map[string]string, compressed bool) string {
+	f, err := ioutil.TempFile("", "sb-archive-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var (
+		w  io.Writer = f
+		gz *gzip.Writer
+	)
+	if compressed {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for name, content := range files {
+		hdr := &tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f.Name()
+}
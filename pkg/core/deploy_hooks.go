@@ -18,6 +18,22 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// Deploy-hook URL patterns of the default handlers.
+const (
+	hipChatDeployHookPattern  = "^https://api.hipchat.com/v1/rooms/message.*"
+	slackDeployHookPattern    = "^https://hooks.slack.com/services/.*"
+	newRelicDeployHookPattern = "^https://api.newrelic.com/v2/applications/[^/]+/deployments.json$"
+	datadogDeployHookPattern  = "^https://app.datadoghq.com/api/v1/events.*"
+)
+
+// notificationDeployHookPatterns match the deploy-hooks which merely relay a
+// message, as opposed to e.g. New Relic where each call records a deployment.
+var notificationDeployHookPatterns = []string{
+	hipChatDeployHookPattern,
+	slackDeployHookPattern,
+	datadogDeployHookPattern,
+}
+
 // DeployHookFunc is the interface deployment hook functions adhere to.
 type DeployHookFunc func(d *Deployment, hookURL string, message string, alert bool) error
 
@@ -37,7 +53,7 @@ func (d *Deployment) postDeployHooks(deployErr error) {
 	}
 
 	if len(d.Revision) > 0 {
-		revision = " (" + d.Revision[0:7] + ")."
+		revision = " (" + shortRevision(d.Revision) + ")."
 	}
 
 	if deployErr != nil {
@@ -67,6 +83,12 @@ func (d *Deployment) postDeployHooks(deployErr error) {
 		message = "Deployed " + d.Application.Name + " " + d.Version + " in " + duration + revision
 	}
 
+	d.dispatchDeployHooks(hookURLs, message, alert)
+}
+
+// dispatchDeployHooks sends a message to each of the deploy-hook URLs via the
+// matching handler.
+func (d *Deployment) dispatchDeployHooks(hookURLs []string, message string, alert bool) {
 	deployHookFuncs := []func() error{}
 
 	for _, hookURL := range hookURLs {
@@ -97,7 +119,7 @@ func (*Server) defaultDeployHooks() map[string]DeployHookFunc {
 	// deployHooksMap follows the form of regExpPrefix->callbackHandler.
 	deployHooksMap := map[string]DeployHookFunc{
 		// HipChat.
-		"^https://api.hipchat.com/v1/rooms/message.*": func(d *Deployment, hookURL string, message string, alert bool) error {
+		hipChatDeployHookPattern: func(d *Deployment, hookURL string, message string, alert bool) error {
 			var (
 				notify = 0
 				color  = "green"
@@ -118,7 +140,7 @@ func (*Server) defaultDeployHooks() map[string]DeployHookFunc {
 		},
 
 		// Slack.
-		"^https://hooks.slack.com/services/.*": func(d *Deployment, hookURL string, message string, alert bool) error {
+		slackDeployHookPattern: func(d *Deployment, hookURL string, message string, alert bool) error {
 			data := map[string]interface{}{
 				"text":         message,
 				"username":     d.Server.Name,
@@ -145,7 +167,7 @@ func (*Server) defaultDeployHooks() map[string]DeployHookFunc {
 		},
 
 		// New-Relic.
-		"^https://api.newrelic.com/v2/applications/[^/]+/deployments.json$": func(d *Deployment, hookURL string, message string, alert bool) error {
+		newRelicDeployHookPattern: func(d *Deployment, hookURL string, message string, alert bool) error {
 			apiKey, ok := d.Application.Environment["SB_NEWRELIC_API_KEY"]
 			if !ok || len(apiKey) == 0 {
 				return fmt.Errorf("new-relic deploy-hook: missing app environment variable %q", "SB_NEWRELIC_API_KEY")
@@ -153,7 +175,7 @@ func (*Server) defaultDeployHooks() map[string]DeployHookFunc {
 
 			data := map[string]map[string]interface{}{
 				"deployment": map[string]interface{}{
-					"revision":    d.Revision[0:7],
+					"revision":    shortRevision(d.Revision),
 					"changelog":   message,
 					"description": fmt.Sprintf("version=%v", d.Version),
 					"user":        "Anomali", // TODO: Determine deploying user.
@@ -184,7 +206,7 @@ func (*Server) defaultDeployHooks() map[string]DeployHookFunc {
 		},
 
 		// Datadog.
-		"^https://app.datadoghq.com/api/v1/events.*": func(d *Deployment, hookURL string, message string, alert bool) error {
+		datadogDeployHookPattern: func(d *Deployment, hookURL string, message string, alert bool) error {
 			var (
 				hostname, _ = os.Hostname()
 				data        = map[string]interface{}{
@@ -265,3 +287,18 @@ func (d *Deployment) deployHookURLs() []string {
 
 	return hooksURLs
 }
+
+// notificationHookURLs filters deploy-hook URLs down to the notification
+// hooks, for messages which aren't about a deploy.
+func notificationHookURLs(hookURLs []string) []string {
+	filtered := []string{}
+	for _, hookURL := range hookURLs {
+		for _, pattern := range notificationDeployHookPatterns {
+			if regexp.MustCompile(pattern).MatchString(hookURL) {
+				filtered = append(filtered, hookURL)
+				break
+			}
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,241 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DeployLogRunning   = "running"
+	DeployLogSucceeded = "succeeded"
+	DeployLogFailed    = "failed"
+
+	deployLogTsLayout = "20060102150405"
+	maxDeployLogs     = 50 // Number of deploy logs to retain per app.
+)
+
+// deployLogsDirectory is where deploy logs are stored, overridable for tests.
+var deployLogsDirectory = DEPLOY_LOGS_DIRECTORY
+
+// DeployLog captures the complete output of a single deploy attempt, whether
+// or not it resulted in a release.
+//
+// Logs are stored on the shipbuilder server's local disk under
+// DEPLOY_LOGS_DIRECTORY/<app>/ as a pair of files: <id>.log holds the raw
+// output and <id>.json holds the metadata.
+type DeployLog struct {
+	Application string    `json:"application"`
+	Version     string    `json:"version"`
+	Revision    string    `json:"revision"`
+	Kind        string    `json:"kind"` // One of "deploy", "redeploy", or "rollback".
+	StartedTs   time.Time `json:"started"`
+	FinishedTs  time.Time `json:"finished"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	file        *os.File
+}
+
+// NewDeployLog creates and opens a new deploy log for the given app version.
+//
+// Problems opening the log file are logged rather than returned so that a
+// full disk never blocks a deploy; in that case writes are discarded.
+func NewDeployLog(applicationName string, version string, revision string, kind string) *DeployLog {
+	dl := &DeployLog{
+		Application: applicationName,
+		Version:     version,
+		Revision:    revision,
+		Kind:        kind,
+		StartedTs:   time.Now(),
+		Status:      DeployLogRunning,
+	}
+
+	if err := os.MkdirAll(deployLogsPath(applicationName), os.FileMode(int(0700))); err != nil {
+		log.WithField("app", applicationName).Errorf("Problem creating deploy logs directory: %s", err)
+		return dl
+	}
+	fd, err := os.OpenFile(dl.logPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(int(0600)))
+	if err != nil {
+		log.WithField("app", applicationName).Errorf("Problem creating deploy log file %q: %s", dl.logPath(), err)
+		return dl
+	}
+	dl.file = fd
+	if err := dl.save(); err != nil {
+		log.WithField("app", applicationName).Errorf("Problem saving deploy log metadata: %s", err)
+	}
+	return dl
+}
+
+// Id returns the unique identifier for the deploy log.
+func (dl *DeployLog) Id() string {
+	return fmt.Sprintf("%v-%v", dl.Version, dl.StartedTs.UTC().Format(deployLogTsLayout))
+}
+
+// Duration returns how long the deploy ran for.
+func (dl *DeployLog) Duration() time.Duration {
+	if dl.FinishedTs.IsZero() {
+		return time.Since(dl.StartedTs)
+	}
+	return dl.FinishedTs.Sub(dl.StartedTs)
+}
+
+// Write implements io.Writer.
+func (dl *DeployLog) Write(bs []byte) (int, error) {
+	if dl.file == nil {
+		return len(bs), nil
+	}
+	if _, err := dl.file.Write(bs); err != nil {
+		log.WithField("app", dl.Application).Errorf("Problem writing to deploy log %q: %s", dl.logPath(), err)
+	}
+	// NB: Errors are intentionally not propagated so the deploy output to the
+	// client is never interrupted.
+	return len(bs), nil
+}
+
+// deployOutput returns the writer for the output of a deploy, which is always
+// persisted to its deploy log and streamed to the client for as long as it
+// stays connected.
+func deployOutput(conn net.Conn, dl *DeployLog) io.Writer {
+	return io.MultiWriter(dl, &clientWriter{NewMessageLogger(conn)})
+}
+
+// clientWriter discards write errors so a client disconnecting never
+// interrupts a deploy or its deploy log.
+type clientWriter struct {
+	writer io.Writer
+}
+
+func (w *clientWriter) Write(bs []byte) (int, error) {
+	w.writer.Write(bs)
+	return len(bs), nil
+}
+
+// Finish records the outcome of the deploy, closes the log file and prunes
+// old logs for the app.
+func (dl *DeployLog) Finish(err error) {
+	dl.FinishedTs = time.Now()
+	if err != nil {
+		dl.Status = DeployLogFailed
+		dl.Error = err.Error()
+		fmt.Fprintf(dl, "Deploy failed: %s\n", err)
+	} else {
+		dl.Status = DeployLogSucceeded
+	}
+	if dl.file == nil {
+		return
+	}
+	if err := dl.file.Close(); err != nil {
+		log.WithField("app", dl.Application).Errorf("Problem closing deploy log %q: %s", dl.logPath(), err)
+	}
+	dl.file = nil
+	if err := dl.save(); err != nil {
+		log.WithField("app", dl.Application).Errorf("Problem saving deploy log metadata: %s", err)
+	}
+	if err := pruneDeployLogs(dl.Application); err != nil {
+		log.WithField("app", dl.Application).Errorf("Problem pruning deploy logs: %s", err)
+	}
+}
+
+func (dl *DeployLog) save() error {
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(dl.metadataPath(), data, os.FileMode(int(0600))); err != nil {
+		return fmt.Errorf("writing deploy log metadata file %q: %s", dl.metadataPath(), err)
+	}
+	return nil
+}
+
+func (dl *DeployLog) logPath() string {
+	return filepath.Join(deployLogsPath(dl.Application), dl.Id()+".log")
+}
+
+func (dl *DeployLog) metadataPath() string {
+	return filepath.Join(deployLogsPath(dl.Application), dl.Id()+".json")
+}
+
+// Content returns the raw log output.
+func (dl *DeployLog) Content() ([]byte, error) {
+	return ioutil.ReadFile(dl.logPath())
+}
+
+func deployLogsPath(applicationName string) string {
+	return filepath.Join(deployLogsDirectory, applicationName)
+}
+
+// ListDeployLogs returns all deploy logs for an app, most recent first.
+func ListDeployLogs(applicationName string) ([]*DeployLog, error) {
+	matches, err := filepath.Glob(filepath.Join(deployLogsPath(applicationName), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	dls := make([]*DeployLog, 0, len(matches))
+	for _, match := range matches {
+		data, err := ioutil.ReadFile(match)
+		if err != nil {
+			return nil, fmt.Errorf("reading deploy log metadata file %q: %s", match, err)
+		}
+		dl := &DeployLog{}
+		if err := json.Unmarshal(data, dl); err != nil {
+			return nil, fmt.Errorf("parsing deploy log metadata file %q: %s", match, err)
+		}
+		dls = append(dls, dl)
+	}
+	sort.Slice(dls, func(i, j int) bool {
+		return dls[i].StartedTs.After(dls[j].StartedTs)
+	})
+	return dls, nil
+}
+
+// FindDeployLog locates the most recent deploy log matching either a version
+// (e.g. "v12" or "12") or a deploy log id.
+func FindDeployLog(applicationName string, version string) (*DeployLog, error) {
+	dls, err := ListDeployLogs(applicationName)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	for _, dl := range dls {
+		if dl.Version == version || dl.Id() == version {
+			return dl, nil
+		}
+	}
+	return nil, fmt.Errorf("no deploy log found for app=%v version=%v", applicationName, version)
+}
+
+// pruneDeployLogs removes all but the most recent maxDeployLogs logs for an
+// app.
+func pruneDeployLogs(applicationName string) error {
+	dls, err := ListDeployLogs(applicationName)
+	if err != nil {
+		return err
+	}
+	if len(dls) <= maxDeployLogs {
+		return nil
+	}
+	for _, dl := range dls[maxDeployLogs:] {
+		for _, path := range []string{dl.logPath(), dl.metadataPath()} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteDeployLogs removes all deploy logs for an app.
+func DeleteDeployLogs(applicationName string) error {
+	return os.RemoveAll(deployLogsPath(applicationName))
+}
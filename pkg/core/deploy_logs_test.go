@@ -0,0 +1,130 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func withTestDeployLogsDirectory(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "shipbuilder-deploy-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := deployLogsDirectory
+	deployLogsDirectory = dir
+	return func() {
+		deployLogsDirectory = previous
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDeployLog(t *testing.T) {
+	defer withTestDeployLogsDirectory(t)()
+
+	dl := NewDeployLog("myapp", "v3", "abc123", "deploy")
+	fmt.Fprintf(dl, "Building...\n")
+	dl.Finish(errors.New("build failed"))
+
+	found, err := FindDeployLog("myapp", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Id() != dl.Id() || found.Revision != "abc123" || found.Status != DeployLogFailed || found.Error != "build failed" {
+		t.Errorf("Unexpected deploy log metadata read back: %+v", found)
+	}
+	content, err := found.Content()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Building...\nDeploy failed: build failed\n"; string(content) != expected {
+		t.Errorf("Expected content=%q but actual=%q", expected, string(content))
+	}
+	if _, err := FindDeployLog("myapp", "v4"); err == nil {
+		t.Errorf("Expected an error finding a missing version")
+	}
+	if dls, err := ListDeployLogs("otherapp"); err != nil || len(dls) != 0 {
+		t.Errorf("Expected no deploy logs for another app but actual=%v (err=%v)", dls, err)
+	}
+
+	if err := DeleteDeployLogs("myapp"); err != nil {
+		t.Fatal(err)
+	}
+	if dls, err := ListDeployLogs("myapp"); err != nil || len(dls) != 0 {
+		t.Errorf("Expected no deploy logs after deletion but actual=%v (err=%v)", dls, err)
+	}
+}
+
+func TestDeployOutputClientDisconnected(t *testing.T) {
+	defer withTestDeployLogsDirectory(t)()
+
+	dl := NewDeployLog("myapp", "v3", "abc123", "deploy")
+	client, conn := net.Pipe()
+	client.Close()
+	conn.Close()
+
+	w := deployOutput(conn, dl)
+	for _, line := range []string{"Building...\n", "Starting dynos...\n"} {
+		if _, err := fmt.Fprint(w, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dl.Finish(nil)
+
+	content, err := dl.Content()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Building...\nStarting dynos...\n"; string(content) != expected {
+		t.Errorf("Expected content=%q but actual=%q", expected, string(content))
+	}
+}
+
+func TestPruneDeployLogs(t *testing.T) {
+	defer withTestDeployLogsDirectory(t)()
+
+	if err := os.MkdirAll(deployLogsPath("myapp"), os.FileMode(int(0700))); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= maxDeployLogs+2; i++ {
+		dl := &DeployLog{
+			Application: "myapp",
+			Version:     fmt.Sprintf("v%v", i),
+			StartedTs:   started.Add(time.Duration(i) * time.Minute),
+			Status:      DeployLogSucceeded,
+		}
+		if err := dl.save(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(dl.logPath(), []byte("output\n"), os.FileMode(int(0600))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pruneDeployLogs("myapp"); err != nil {
+		t.Fatal(err)
+	}
+	dls, err := ListDeployLogs("myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != maxDeployLogs {
+		t.Fatalf("Expected %v deploy logs to be retained but actual=%v", maxDeployLogs, len(dls))
+	}
+	if newest, oldest := dls[0].Version, dls[len(dls)-1].Version; newest != fmt.Sprintf("v%v", maxDeployLogs+2) || oldest != "v3" {
+		t.Errorf("Expected retained versions v3..v%v but actual=%v..%v", maxDeployLogs+2, oldest, newest)
+	}
+	for _, version := range []string{"v1", "v2"} {
+		if _, err := FindDeployLog("myapp", version); err == nil {
+			t.Errorf("Expected pruned deploy log %v to be gone", version)
+		}
+	}
+	if files, err := ioutil.ReadDir(deployLogsPath("myapp")); err != nil || len(files) != 2*maxDeployLogs {
+		t.Errorf("Expected %v log and metadata files but actual=%v (err=%v)", 2*maxDeployLogs, len(files), err)
+	}
+}
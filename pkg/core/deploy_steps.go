@@ -0,0 +1,387 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jaytaylor/shipbuilder/pkg/domain"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DeployStepPending   = "pending"
+	DeployStepRunning   = "running"
+	DeployStepSucceeded = "succeeded"
+	DeployStepFailed    = "failed"
+)
+
+// DeployStepState records the progress and timing of a single deployment step.
+type DeployStepState struct {
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	StartedTs  time.Time `json:"started,omitempty"`
+	FinishedTs time.Time `json:"finished,omitempty"`
+	Attempts   int       `json:"attempts"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Duration returns how long the most recent attempt of the step took.
+func (step *DeployStepState) Duration() time.Duration {
+	if step.StartedTs.IsZero() {
+		return 0
+	}
+	if step.FinishedTs.IsZero() {
+		return time.Since(step.StartedTs)
+	}
+	return step.FinishedTs.Sub(step.StartedTs)
+}
+
+// DeployState is the persisted progress of the most recent deployment of an
+// app.  Everything needed to continue a failed deployment from the last
+// completed step is stored here.
+type DeployState struct {
+	Application        string             `json:"application"`
+	Version            string             `json:"version"`
+	Revision           string             `json:"revision"`
+	Kind               string             `json:"kind"`
+	ImageFingerprint   string             `json:"imageFingerprint,omitempty"`
+	AllocatingNewDynos bool               `json:"allocatingNewDynos"`
+	Nodes              []string           `json:"nodes,omitempty"`       // Hosts successfully sync'd during the sync step.
+	AddDynos           []Dyno             `json:"addDynos,omitempty"`    // Dynos started during the start step.
+	RemoveDynos        []Dyno             `json:"removeDynos,omitempty"` // Dynos to be replaced.
+	Steps              []*DeployStepState `json:"steps"`
+}
+
+// step returns the named step state, adding it if it is not yet present.
+func (state *DeployState) step(name string) *DeployStepState {
+	for _, step := range state.Steps {
+		if step.Name == name {
+			return step
+		}
+	}
+	step := &DeployStepState{
+		Name:   name,
+		Status: DeployStepPending,
+	}
+	state.Steps = append(state.Steps, step)
+	return step
+}
+
+// Completed returns true when the named step has already succeeded.
+func (state *DeployState) Completed(name string) bool {
+	for _, step := range state.Steps {
+		if step.Name == name {
+			return step.Status == DeployStepSucceeded
+		}
+	}
+	return false
+}
+
+// Failed returns the failed step, or nil if no step has failed.
+func (state *DeployState) Failed() *DeployStepState {
+	for _, step := range state.Steps {
+		if step.Status == DeployStepFailed {
+			return step
+		}
+	}
+	return nil
+}
+
+func deployStatePath(applicationName string) string {
+	return filepath.Join(DEPLOY_STATE_DIRECTORY, applicationName+".json")
+}
+
+// save persists the deploy state to local disk.
+func (state *DeployState) save() error {
+	if err := os.MkdirAll(DEPLOY_STATE_DIRECTORY, os.FileMode(int(0700))); err != nil {
+		return fmt.Errorf("creating path %q: %s", DEPLOY_STATE_DIRECTORY, err)
+	}
+	data, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(deployStatePath(state.Application), data, os.FileMode(int(0600))); err != nil {
+		return fmt.Errorf("writing deploy state file %q: %s", deployStatePath(state.Application), err)
+	}
+	return nil
+}
+
+// LoadDeployState reads the persisted state of the most recent deployment for
+// an app.  Returns os.ErrNotExist when there is none.
+func LoadDeployState(applicationName string) (*DeployState, error) {
+	data, err := ioutil.ReadFile(deployStatePath(applicationName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	state := &DeployState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parsing deploy state file %q: %s", deployStatePath(applicationName), err)
+	}
+	return state, nil
+}
+
+// DeleteDeployState removes the persisted deploy state for an app.
+func DeleteDeployState(applicationName string) error {
+	if err := os.Remove(deployStatePath(applicationName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// deployStep is a named phase of a deployment.  Each step must be safe to
+// retry after a failure.
+type deployStep struct {
+	name string
+	fn   func() error
+}
+
+// steps returns the ordered list of steps for the deployment.
+//
+// Scaling-only deployments skip the build phase entirely.
+func (d *Deployment) steps() []deployStep {
+	steps := []deployStep{}
+	if !d.ScalingOnly {
+		steps = append(steps,
+			deployStep{"prepare", d.prepare},
+			deployStep{"build", d.build},
+			deployStep{"publish", d.publish},
+			deployStep{"archive", d.archive},
+		)
+	}
+	steps = append(steps, d.releaseSteps()...)
+	return steps
+}
+
+// prepare applies the manifest buildpack and creates the app image container.
+func (d *Deployment) prepare() error {
+	if err := d.applyManifestBuildPack(); err != nil {
+		return err
+	}
+	return d.createContainer()
+}
+
+// releaseSteps returns the steps which roll out an already published image.
+func (d *Deployment) releaseSteps() []deployStep {
+	steps := []deployStep{
+		{"sync", d.syncStep},
+		{"start", d.startStep},
+		{"route", d.routeStep},
+		{"record", d.recordStep},
+	}
+	return steps
+}
+
+// runSteps runs each step in order, skipping any which already completed
+// during a previous attempt.
+func (d *Deployment) runSteps(steps []deployStep) error {
+	titleLogger := NewFormatter(d.Logger, GREEN)
+
+	for _, step := range steps {
+		state := d.state.step(step.name)
+		if state.Status == DeployStepSucceeded {
+			fmt.Fprintf(titleLogger, "Skipping completed step: %v\n", step.name)
+			continue
+		}
+
+		state.Status = DeployStepRunning
+		state.StartedTs = time.Now()
+		state.FinishedTs = time.Time{}
+		state.Attempts++
+		state.Error = ""
+		d.saveState()
+
+		err := step.fn()
+
+		state.FinishedTs = time.Now()
+		if err != nil {
+			state.Status = DeployStepFailed
+			state.Error = err.Error()
+			d.saveState()
+			return fmt.Errorf("%v step: %s", step.name, err)
+		}
+		state.Status = DeployStepSucceeded
+		d.saveState()
+		fmt.Fprintf(titleLogger, "Step %v completed in %v\n", step.name, state.Duration().Round(time.Millisecond))
+	}
+	return nil
+}
+
+// saveState persists the deployment state when the deployment is resumable.
+func (d *Deployment) saveState() {
+	d.state.ImageFingerprint = d.ImageFingerprint
+	if !d.Resumable {
+		return
+	}
+	if err := d.state.save(); err != nil {
+		log.WithField("app", d.Application.Name).Errorf("Problem saving deploy state: %s", err)
+	}
+}
+
+// syncStep determines which dynos will be replaced and syncs the image to all
+// available nodes.
+func (d *Deployment) syncStep() error {
+	if len(d.Application.Processes) == 0 {
+		return fmt.Errorf("No processes scaled up, adjust with `ps:scale procType=#` before deploying")
+	}
+
+	d.autoDetectRevision()
+	d.state.Revision = d.Revision
+
+	removeDynos, allocatingNewDynos, err := d.calculateDynosToDestroy()
+	if err != nil {
+		return err
+	}
+	d.state.RemoveDynos = removeDynos
+	d.state.AllocatingNewDynos = allocatingNewDynos
+	d.state.Nodes = nil
+
+	if !allocatingNewDynos {
+		return nil
+	}
+
+	availableNodes, err := d.syncNodes()
+	if err != nil {
+		return err
+	}
+	for _, node := range availableNodes {
+		d.state.Nodes = append(d.state.Nodes, node.Host)
+	}
+	return nil
+}
+
+// startStep launches the new dynos on the nodes sync'd during the sync step.
+func (d *Deployment) startStep() error {
+	if !d.state.AllocatingNewDynos {
+		return nil
+	}
+
+	availableNodes := []*Node{}
+	for _, node := range d.Config.Nodes {
+		for _, host := range d.state.Nodes {
+			if node.Host == host {
+				availableNodes = append(availableNodes, node)
+				break
+			}
+		}
+	}
+	if len(availableNodes) == 0 {
+		return fmt.Errorf("none of the sync'd nodes %v are configured anymore", d.state.Nodes)
+	}
+
+	titleLogger := NewFormatter(d.Logger, GREEN)
+
+	// Dynos started by an attempt which didn't finish were never routed to.
+	if len(d.state.AddDynos) > 0 {
+		fmt.Fprintf(titleLogger, "Shutting down %v dynos started by the previous attempt\n", len(d.state.AddDynos))
+		shutdownDynos(NewFormatter(d.Logger, DIM), d.state.AddDynos)
+		d.state.AddDynos = nil
+		d.saveState()
+	}
+
+	// Each dyno is recorded as it starts so none are lost if the server stops
+	// partway through.
+	addDynos, err := d.startDynos(availableNodes, titleLogger, func(dyno Dyno) {
+		d.state.AddDynos = append(d.state.AddDynos, dyno)
+		d.saveState()
+	})
+	if err != nil {
+		shutdownDynos(NewFormatter(d.Logger, DIM), addDynos)
+		d.state.AddDynos = nil
+		return err
+	}
+	return nil
+}
+
+// shutdownDynos destroys dynos which were started but won't be routed to.
+func shutdownDynos(logger io.Writer, dynos []Dyno) {
+	e := &Executor{
+		Logger: logger,
+	}
+	for _, dyno := range dynos {
+		if err := dyno.Shutdown(e, 0); err != nil {
+			fmt.Fprintf(logger, "Warning: failed to shut down dyno %v: %s\n", dyno.Container, err)
+		}
+	}
+}
+
+// routeStep updates the load-balancers to send traffic to the new dynos.  Web
+// dynos being replaced keep being routed to alongside the new ones while they
+// drain, and are removed once idle or the app's drain timeout has passed.
+func (d *Deployment) routeStep() error {
+	drain := webDynos(d.state.RemoveDynos)
+	if !d.state.AllocatingNewDynos && len(drain) == 0 {
+		return nil
+	}
+	e := &Executor{
+		Logger: NewFormatter(d.Logger, DIM),
+	}
+	if len(drain) > 0 && len(d.Config.LoadBalancers) > 0 {
+		if err := d.Server.SyncLoadBalancers(e, d.state.AddDynos, []Dyno{}); err != nil {
+			return err
+		}
+		d.Server.drainDynos(e.Logger, d.Config.LoadBalancersUsing(LBDriverHAProxy), drain, d.Application.DrainTimeout())
+	}
+	return d.Server.SyncLoadBalancers(e, d.state.AddDynos, d.state.RemoveDynos)
+}
+
+// recordStep adds the new release to the release history, or in the case of a
+// scaling-only deployment shuts down any surplus dynos.
+func (d *Deployment) recordStep() error {
+	if !d.ScalingOnly {
+		// Update releases.
+		releases, err := d.Server.ReleasesProvider.List(d.Application.Name)
+		if err != nil {
+			return err
+		}
+		// Remove any record left behind by a previous attempt of this step.
+		for i, r := range releases {
+			if r.Version == d.Version {
+				releases = append(releases[:i], releases[i+1:]...)
+				break
+			}
+		}
+		// Prepend the release (releases are in descending order).
+		releases = append([]domain.Release{d.release()}, releases...)
+		// Only keep around the latest 15 (older ones are still in S3).
+		if len(releases) > 15 {
+			releases = releases[:15]
+		}
+		if err := d.Server.ReleasesProvider.Set(d.Application.Name, releases); err != nil {
+			log.WithField("app", d.Application.Name).Errorf("Problem setting releases: %s", err)
+			return err
+		}
+		log.WithField("app", d.Application.Name).Debug("Successfully set releases")
+
+		// Keep the released revision reachable in the git repository.  The release
+		// is already live at this point, so problems here only warrant a warning.
+		if err := d.Application.createReleaseRef(d.Version, d.Revision); err != nil {
+			fmt.Fprintf(NewFormatter(d.Logger, RED), "Warning: %s\n", err)
+		} else if err := d.Application.pruneReleaseRefs(releases); err != nil {
+			fmt.Fprintf(NewFormatter(d.Logger, RED), "Warning: %s\n", err)
+		}
+		return nil
+	}
+
+	// Trigger old dynos to shutdown.
+	titleLogger := NewFormatter(d.Logger, GREEN)
+	gracePeriod := d.Application.ShutdownGracePeriod()
+	for _, removeDyno := range d.state.RemoveDynos {
+		fmt.Fprintf(titleLogger, "Shutting down dyno: %v\n", removeDyno.Container)
+		go func(rd Dyno) {
+			e := &Executor{
+				Logger: os.Stdout,
+			}
+			rd.Shutdown(e, gracePeriod)
+		}(removeDyno)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestDeploymentRunStepsResume(t *testing.T) {
+	var (
+		calls []string
+		fail  = true
+		d     = &Deployment{
+			Logger:      ioutil.Discard,
+			Application: &Application{Name: "test-app"},
+			state:       &DeployState{Application: "test-app"},
+		}
+		steps = []deployStep{
+			{"prepare", func() error { calls = append(calls, "prepare"); return nil }},
+			{"build", func() error { calls = append(calls, "build"); return nil }},
+			{"publish", func() error {
+				calls = append(calls, "publish")
+				if fail {
+					return errors.New("registry unavailable")
+				}
+				return nil
+			}},
+			{"sync", func() error { calls = append(calls, "sync"); return nil }},
+		}
+	)
+
+	if err := d.runSteps(steps); err == nil {
+		t.Fatalf("Expected first run to fail")
+	}
+	if expected := []string{"prepare", "build", "publish"}; !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls=%v but actual=%v", expected, calls)
+	}
+	if failed := d.state.Failed(); failed == nil || failed.Name != "publish" || failed.Error != "registry unavailable" {
+		t.Errorf("Expected publish step to be recorded as failed but actual=%+v", failed)
+	}
+
+	calls = nil
+	fail = false
+	if err := d.runSteps(steps); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"publish", "sync"}; !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected resumed calls=%v but actual=%v", expected, calls)
+	}
+	for i, step := range d.state.Steps {
+		if step.Status != DeployStepSucceeded {
+			t.Errorf("[i=%v] Expected step %v status=%v but actual=%v", i, step.Name, DeployStepSucceeded, step.Status)
+		}
+	}
+	if attempts := d.state.step("publish").Attempts; attempts != 2 {
+		t.Errorf("Expected publish attempts=2 but actual=%v", attempts)
+	}
+}
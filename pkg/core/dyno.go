@@ -2,6 +2,7 @@ package core
 
 import (
 	"fmt"
+	"os/exec"
 	"regexp"
 	"sort"
 	"strconv"
@@ -18,13 +19,18 @@ const (
 	DYNO_DELIMITER     = "-"
 	DYNO_STATE_RUNNING = "running"
 	DYNO_STATE_STOPPED = "stopped"
-)
 
-var dynoPortTracker = DynoPortTracker{allocations: map[string][]int{}, lock: sync.Mutex{}}
+	// DYNO_METADATA_PREFIX prefixes the LXC config keys which record dyno
+	// identity, e.g. user.shipbuilder.app.  They are set by postdeploy.py.
+	DYNO_METADATA_PREFIX = "user.shipbuilder."
+)
 
 type Dyno struct {
 	Host, Container, Application, Process, Version, Port, State string
 	VersionNumber, PortNumber                                   int
+	Release                                                     string    // Image fingerprint, only known for dynos with metadata.
+	StartedTs                                                   time.Time // Only known for dynos with metadata.
+	Index                                                       int       // Stable number of the dyno within its process type, 0 when unknown.
 }
 
 type NodeStatusRunning struct {
@@ -36,35 +42,64 @@ type NodeStatuses []NodeStatusRunning
 
 type DynoGenerator struct {
 	server      *Server
-	statuses    []NodeStatusRunning
-	position    int
+	statuses    map[string]NodeStatus
+	nodes       []*PlacementNode
+	strategy    PlacementStrategy
+	memoryMb    map[string]int // Memory required per dyno of a process type.
+	constraints []PlacementConstraint
 	application string
 	version     string
-	usedPorts   []int
+	indexes     map[string]map[int]bool // Dyno indexes in use per process type.
+	lock        sync.Mutex
 }
 
-type DynoPortTracker struct {
-	allocations map[string][]int
-	lock        sync.Mutex
+// Name identifies the dyno among the app's dynos, e.g. web.1.  Dynos started
+// before indexes were assigned are identified by their port instead.
+func (dyno *Dyno) Name() string {
+	if dyno.Index > 0 {
+		return fmt.Sprintf("%v.%v", dyno.Process, dyno.Index)
+	}
+	return fmt.Sprintf("%v.%v", dyno.Process, dyno.Port)
 }
 
 func (dyno *Dyno) Info() string {
 	return fmt.Sprintf("host=%v app=%v version=%v proc=%v port=%v state=%v", dyno.Host, dyno.Application, dyno.Version, dyno.Process, dyno.Port, dyno.State)
 }
 
-func (dyno *Dyno) Shutdown(e *Executor) error {
+// identityOptions returns the container control script options which identify
+// the dyno, so its container name need not be parsed.
+func (dyno *Dyno) identityOptions() []string {
+	return []string{"app=" + dyno.Application, "version=" + dyno.Version, "process=" + dyno.Process, "port=" + dyno.Port}
+}
+
+// Shutdown stops and destroys the dyno's container.  A running dyno's app is
+// given up to gracePeriod to exit after SIGTERM before the container is
+// forcibly stopped.
+func (dyno *Dyno) Shutdown(e *Executor, gracePeriod time.Duration) error {
 	fmt.Fprintf(e.Logger, "Shutting down dyno: %v\n", dyno.Info())
 	sshHost := "root@" + dyno.Host
 	if err := e.SyncContainerScripts(sshHost + ":/tmp/"); err != nil {
 		fmt.Fprintf(e.Logger, "Warning: failed to sync container control scripts to %q (will continue shutdown attempt despite this): %s\n", sshHost, err)
 	}
+	var err error
 	if dyno.State == DYNO_STATE_RUNNING {
 		// Shutdown then destroy.
-		return e.Run("ssh", sshHost, "/tmp/shutdown_container.py", dyno.Container)
+		args := append([]string{sshHost, "/tmp/shutdown_container.py", dyno.Container}, dyno.identityOptions()...)
+		if seconds := int(gracePeriod / time.Second); seconds > 0 {
+			args = append(args, fmt.Sprintf("grace-period=%v", seconds))
+		}
+		err = e.Run("ssh", args...)
 	} else {
 		// Destroy only.
-		return e.Run("ssh", sshHost, "/tmp/shutdown_container.py", dyno.Container, "destroy-only")
+		err = e.Run("ssh", append([]string{sshHost, "/tmp/shutdown_container.py", dyno.Container, "destroy-only"}, dyno.identityOptions()...)...)
 	}
+	if err != nil {
+		return err
+	}
+	if err := portRegistry.Release(dyno.Host, dyno.PortNumber); err != nil {
+		fmt.Fprintf(e.Logger, "Warning: failed to release port %v on %v: %s\n", dyno.PortNumber, dyno.Host, err)
+	}
+	return nil
 }
 
 func (dyno *Dyno) AttachAndExecute(exe *Executor, args ...string) error {
@@ -86,6 +121,31 @@ func (dyno *Dyno) AttachAndExecute(exe *Executor, args ...string) error {
 	return exe.Run("ssh", args...)
 }
 
+// AttachCommand returns the command which runs args, or an interactive shell
+// when args is empty, in the dyno with the app's environment and a terminal
+// allocated.
+func (dyno *Dyno) AttachCommand(args ...string) *exec.Cmd {
+	sshArgs := appender.Strings(
+		append([]string{}, defaultSSHParametersList...),
+		"-t",
+		DEFAULT_NODE_USERNAME+"@"+dyno.Host,
+		"sudo",
+		LXC_BIN,
+		"exec",
+		dyno.Container,
+		"--",
+	)
+	return logcmd(exec.Command("ssh", append(sshArgs, remoteAppShellArgs(args...)...)...))
+}
+
+// remoteAppShellArgs returns appShellArgs quoted for passing through ssh, which
+// joins its arguments for evaluation by the remote shell.
+func remoteAppShellArgs(args ...string) []string {
+	shellArgs := appShellArgs(args...)
+	shellArgs[len(shellArgs)-1] = bashQuote(shellArgs[len(shellArgs)-1])
+	return shellArgs
+}
+
 func (dyno *Dyno) RestartService(e *Executor) error {
 	fmt.Fprintf(e.Logger, "Restarting app service for dyno %v\n", dyno.Info())
 	return dyno.AttachAndExecute(e, "service", "app", "restart")
@@ -106,60 +166,6 @@ func (dyno *Dyno) GetServiceStatus(e *Executor) error {
 	return dyno.AttachAndExecute(e, "service", "app", "status")
 }
 
-// Check if a port is already in use.
-func (dpt *DynoPortTracker) AlreadyInUse(host string, port int) bool {
-	dpt.lock.Lock()
-	defer dpt.lock.Unlock()
-	if ports, ok := dpt.allocations[host]; ok {
-		for _, p := range ports {
-			if p == port {
-				return true
-			}
-		}
-	}
-	return false
-}
-
-// Attempt to allocate a port for a node host.
-func (dpt *DynoPortTracker) Allocate(host string, port int) error {
-	dpt.lock.Lock()
-	defer dpt.lock.Unlock()
-	if ports, ok := dpt.allocations[host]; ok {
-		// Require that the port not be already in use.
-		for _, p := range ports {
-			if p == port {
-				return fmt.Errorf("Host/port combination %v/%v is already in use", host, port)
-			}
-		}
-		dpt.allocations[host] = append(ports, port)
-	} else {
-		dpt.allocations[host] = []int{port}
-	}
-	// Schedule the port to be automatically freed once the status monitor will have picked up the in-use port.
-	go func(host string, port int) {
-		time.Sleep(1200 * time.Second)
-		dpt.Release(host, port)
-	}(host, port)
-	log.Infof("DynoPortTracker.Allocate :: added host=%v port=%v", host, port)
-	return nil
-}
-
-// Release a previously allocated host/port pair if it is still in the allocations table.
-func (dpt *DynoPortTracker) Release(host string, port int) {
-	log.Infof("DynoPortTracker.Release :: releasing port %v from host %v", port, host)
-	dpt.lock.Lock()
-	defer dpt.lock.Unlock()
-	if ports, ok := dpt.allocations[host]; ok {
-		newPorts := []int{}
-		for _, p := range ports {
-			if p != port {
-				newPorts = append(newPorts, p)
-			}
-		}
-		dpt.allocations[host] = newPorts
-	}
-}
-
 // n.b. Container name format is: app-version-process-port.
 // n.b. "container" param must container app-version-process-port-status.
 func ContainerToDyno(host string, container string) (Dyno, error) {
@@ -200,10 +206,66 @@ func ContainerToDyno(host string, container string) (Dyno, error) {
 	return dyno, nil
 }
 
+// MetadataToDyno creates a Dyno from the dyno metadata recorded in a
+// container's LXC config, keyed without DYNO_METADATA_PREFIX.
+func MetadataToDyno(host string, name string, state string, metadata map[string]string) (Dyno, error) {
+	for _, key := range []string{"app", "version", "process", "port"} {
+		if len(metadata[key]) == 0 {
+			return Dyno{}, fmt.Errorf("container %q is missing dyno metadata key %v%v", name, DYNO_METADATA_PREFIX, key)
+		}
+	}
+	if !strings.HasPrefix(metadata["version"], "v") {
+		return Dyno{}, fmt.Errorf("invalid dyno version value %q, must begin with a 'v'", metadata["version"])
+	}
+	versionNumber, err := strconv.Atoi(strings.TrimPrefix(metadata["version"], "v"))
+	if err != nil {
+		return Dyno{}, err
+	}
+	portNumber, err := strconv.Atoi(metadata["port"])
+	if err != nil {
+		return Dyno{}, err
+	}
+	dyno := Dyno{
+		Host:          host,
+		Container:     name,
+		Application:   metadata["app"],
+		Version:       metadata["version"],
+		Process:       metadata["process"],
+		Port:          metadata["port"],
+		State:         strings.ToLower(state),
+		VersionNumber: versionNumber,
+		PortNumber:    portNumber,
+		Release:       metadata["release"],
+	}
+	if index, ok := metadata["index"]; ok {
+		if dyno.Index, err = strconv.Atoi(index); err != nil {
+			return Dyno{}, fmt.Errorf("invalid dyno index %q: %s", index, err)
+		}
+	}
+	if started, ok := metadata["started"]; ok {
+		if dyno.StartedTs, err = time.Parse(time.RFC3339, started); err != nil {
+			return Dyno{}, fmt.Errorf("invalid dyno start time %q: %s", started, err)
+		}
+	}
+	return dyno, nil
+}
+
+// Dyno returns the dyno for one of the node's containers, preferring the
+// container's dyno metadata and falling back to parsing its name for
+// containers started before metadata was recorded.
+func (ns *NodeStatus) Dyno(container string) (Dyno, error) {
+	if i := strings.LastIndex(container, DYNO_DELIMITER); i > 0 {
+		if metadata, ok := ns.DynoMetadata[container[:i]]; ok && len(metadata) > 0 {
+			return MetadataToDyno(ns.Host, container[:i], container[i+1:], metadata)
+		}
+	}
+	return ContainerToDyno(ns.Host, container)
+}
+
 func NodeStatusToDynos(nodeStatus *NodeStatus) ([]Dyno, error) {
 	dynos := make([]Dyno, len(nodeStatus.Containers))
 	for i, container := range nodeStatus.Containers {
-		dyno, err := ContainerToDyno(nodeStatus.Host, container)
+		dyno, err := nodeStatus.Dyno(container)
 		if err != nil {
 			return dynos, err
 		}
@@ -213,10 +275,7 @@ func NodeStatusToDynos(nodeStatus *NodeStatus) ([]Dyno, error) {
 }
 
 func (server *Server) GetRunningDynos(application string, processType string) ([]Dyno, error) {
-	var (
-		dynos           = []Dyno{}
-		expectedProcess = normalizeAppProcessName(processType)
-	)
+	dynos := []Dyno{}
 
 	cfg, err := server.getConfig(true)
 	if err != nil {
@@ -230,10 +289,10 @@ func (server *Server) GetRunningDynos(application string, processType string) ([
 			continue
 		}
 		for _, container := range status.Containers {
-			dyno, err := ContainerToDyno(node.Host, container)
+			dyno, err := status.Dyno(container)
 			if err != nil {
 				log.Errorf("parsing Container->Dyno for host/container=%v/%v: %v\n", node.Host, container, err)
-			} else if dyno.State == DYNO_STATE_RUNNING && dyno.Application == application && dyno.Process == expectedProcess {
+			} else if dyno.State == DYNO_STATE_RUNNING && dyno.Application == application && sameProcess(dyno.Process, processType) {
 				dynos = append(dynos, dyno)
 			}
 		}
@@ -241,47 +300,137 @@ func (server *Server) GetRunningDynos(application string, processType string) ([
 	return dynos, nil
 }
 
-// NewDynoGenerator chooses which nodes to run the next N-count dynos on.
-func (server *Server) NewDynoGenerator(nodes []*Node, application string, version string) (*DynoGenerator, error) {
-	// Produce sorted sequence of NodeStatuses.
-	allStatuses := []NodeStatusRunning{}
+// FindRunningDyno resolves a dyno name of the form process.N to one of an app's
+// running dynos, where N is either the dyno's index or its port, as shown by
+// ps:list.
+func (server *Server) FindRunningDyno(application string, name string) (Dyno, error) {
+	i := strings.LastIndex(name, ".")
+	n, err := strconv.Atoi(name[i+1:])
+	if i <= 0 || err != nil || n < 1 {
+		return Dyno{}, fmt.Errorf("invalid dyno name %q, expected the form process.N, e.g. web.1", name)
+	}
+	dynos, err := server.GetRunningDynos(application, name[0:i])
+	if err != nil {
+		return Dyno{}, err
+	}
+	// NB: During deploys the old and new versions' dynos briefly share indexes,
+	// prefer the newest.
+	sortDynos(dynos)
+	for i := len(dynos) - 1; i >= 0; i-- {
+		if dynos[i].Index == n || dynos[i].PortNumber == n {
+			return dynos[i], nil
+		}
+	}
+	return Dyno{}, fmt.Errorf("no running dyno %v found for app %v (%v running)", name, application, len(dynos))
+}
+
+// sortDynos orders dynos by index, then version, node and port.  Dynos without
+// an index come last.
+func sortDynos(dynos []Dyno) {
+	sort.Slice(dynos, func(i, j int) bool {
+		if (dynos[i].Index == 0) != (dynos[j].Index == 0) {
+			return dynos[i].Index > 0
+		}
+		if dynos[i].Index != dynos[j].Index {
+			return dynos[i].Index < dynos[j].Index
+		}
+		if dynos[i].VersionNumber != dynos[j].VersionNumber {
+			return dynos[i].VersionNumber < dynos[j].VersionNumber
+		}
+		if dynos[i].Host != dynos[j].Host {
+			return dynos[i].Host < dynos[j].Host
+		}
+		return dynos[i].PortNumber < dynos[j].PortNumber
+	})
+}
+
+// NewDynoGenerator chooses which nodes to run the next N-count dynos of an app
+// version on, according to the app's placement strategy and constraints.
+func (server *Server) NewDynoGenerator(nodes []*Node, app *Application, version string) (*DynoGenerator, error) {
+	strategy, err := NewPlacementStrategy(app.Placement)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		statuses    = []NodeStatus{}
+		statusesMap = map[string]NodeStatus{}
+		labels      = map[string]map[string]string{}
+	)
 	for _, node := range nodes {
-		running := false
 		nodeStatus := server.getNodeStatus(node)
-		// Determine if there is an identical app/version container already running on the node.
-		for _, container := range nodeStatus.Containers {
-			dyno, _ := ContainerToDyno(node.Host, container)
-			if dyno.State == DYNO_STATE_RUNNING && dyno.Application == application && dyno.Version == version {
-				running = true
-				break
-			}
-		}
-		allStatuses = append(allStatuses, NodeStatusRunning{nodeStatus, running})
+		statuses = append(statuses, nodeStatus)
+		statusesMap[node.Host] = nodeStatus
+		labels[node.Host] = node.Labels
 	}
 
-	if len(allStatuses) == 0 {
+	if len(statuses) == 0 {
 		return nil, fmt.Errorf("node list was empty, which means deployment is presently not possible")
 	}
 
-	sort.Sort(NodeStatuses(allStatuses))
+	placementNodes := newPlacementNodes(statuses, app.Name, version)
+	for _, node := range placementNodes {
+		node.Labels = labels[node.Host]
+	}
 
 	return &DynoGenerator{
 		server:      server,
-		statuses:    allStatuses,
-		position:    0,
-		application: application,
+		statuses:    statusesMap,
+		nodes:       placementNodes,
+		strategy:    strategy,
+		memoryMb:    map[string]int{},
+		constraints: app.Constraints,
+		application: app.Name,
 		version:     version,
-		usedPorts:   []int{},
 	}, nil
+}
 
+// SetMemoryMb sets the memory required by each dyno of a process type, which
+// is otherwise estimated.
+func (dg *DynoGenerator) SetMemoryMb(process string, memoryMb int) {
+	dg.lock.Lock()
+	defer dg.lock.Unlock()
+	dg.memoryMb[normalizeAppProcessName(process)] = memoryMb
 }
 
 func (dg *DynoGenerator) Next(process string) (Dyno, error) {
-	process = normalizeAppProcessName(process)
-	nodeStatus := dg.statuses[dg.position%len(dg.statuses)].status
-	dg.position++
-	port := fmt.Sprint(dg.server.getNextPort(&nodeStatus, &dg.usedPorts))
-	dyno, err := ContainerToDyno(nodeStatus.Host, dg.application+DYNO_DELIMITER+dg.version+DYNO_DELIMITER+process+DYNO_DELIMITER+port+DYNO_DELIMITER+DYNO_STATE_STOPPED)
+	dg.lock.Lock()
+	defer dg.lock.Unlock()
+
+	// Process names are recorded verbatim in the dyno metadata, the normalized
+	// form keys the placement state and the container name.
+	key := normalizeAppProcessName(process)
+	memoryMb, ok := dg.memoryMb[key]
+	if !ok {
+		memoryMb = placementDynoMemoryMb
+	}
+
+	// Only consider nodes satisfying the placement constraints.
+	constraints := placementConstraintsFor(dg.constraints, process)
+	eligible := placementNodesSatisfying(dg.nodes, constraints)
+	if len(eligible) == 0 {
+		return Dyno{Process: process}, fmt.Errorf("no node satisfies the placement constraints for process type %q: %v", process, placementConstraintsString(constraints))
+	}
+
+	// Only consider nodes with enough room, unless none have any.
+	candidates := placementNodesWithCapacity(eligible, memoryMb)
+	if len(candidates) == 0 {
+		log.WithField("app", dg.application).WithField("process", process).Warnf("No nodes have %vMB of free memory available for dyno, placing it anyways", memoryMb)
+		candidates = eligible
+	}
+	node := candidates[dg.strategy.Next(candidates, key, memoryMb)]
+	node.FreeMemoryMb -= memoryMb
+	node.Dynos[key]++
+	nodeStatus := dg.statuses[node.Host]
+	container := dg.application + DYNO_DELIMITER + dg.version + DYNO_DELIMITER + key
+	port, err := portRegistry.Allocate(node.Host, container, runningDynoPorts(&nodeStatus))
+	if err != nil {
+		return Dyno{Process: process}, err
+	}
+	dyno, err := ContainerToDyno(node.Host, container+DYNO_DELIMITER+strconv.Itoa(port)+DYNO_DELIMITER+DYNO_STATE_STOPPED)
+	if err == nil {
+		dyno.Index = dg.nextIndex(key)
+	}
 
 	// Don't lose the process type!  This field gets re-used externally when error
 	// is non-nil.
@@ -289,6 +438,37 @@ func (dg *DynoGenerator) Next(process string) (Dyno, error) {
 	return dyno, err
 }
 
+// nextIndex claims the lowest dyno index not already used by the app's running
+// dynos of a process type at the version being deployed, so replacement dynos
+// take over the indexes of the dynos they replace.
+func (dg *DynoGenerator) nextIndex(process string) int {
+	if dg.indexes == nil {
+		dg.indexes = map[string]map[int]bool{}
+	}
+	taken, ok := dg.indexes[process]
+	if !ok {
+		taken = map[int]bool{}
+		if dg.server != nil {
+			dynos, err := dg.server.GetRunningDynos(dg.application, process)
+			if err != nil {
+				log.WithField("app", dg.application).Warnf("Failed to determine dyno indexes in use for process type %q: %s", process, err)
+			}
+			for _, dyno := range dynos {
+				if dyno.Version == dg.version && dyno.Index > 0 {
+					taken[dyno.Index] = true
+				}
+			}
+		}
+		dg.indexes[process] = taken
+	}
+	index := 1
+	for taken[index] {
+		index++
+	}
+	taken[index] = true
+	return index
+}
+
 // NodeStatus sorting.
 func (ns NodeStatuses) Len() int { return len(ns) } // boilerplate.
 
@@ -306,54 +486,18 @@ func (ns NodeStatuses) Less(i int, j int) bool { // actual sorting logic.
 	return ns[i].status.FreeMemoryMb > ns[j].status.FreeMemoryMb
 }
 
-func AppendIfMissing(slice []int, i int) []int {
-	for _, ele := range slice {
-		if ele == i {
-			return slice
-		}
-	}
-	return append(slice, i)
-}
-
-// Get the next available port for a node.
-func (server *Server) getNextPort(nodeStatus *NodeStatus, usedPorts *[]int) int {
-	port := server.GlobalPortTracker.Next()
-	for _, container := range nodeStatus.Containers {
-		dyno, err := ContainerToDyno(nodeStatus.Host, container)
-		if err != nil {
-			log.Warnf("Server.getNextPort :: Failed to create Dyno from container %q: %v", container, err)
-			continue
-		}
-		if dyno.State == DYNO_STATE_RUNNING && dyno.PortNumber > 0 {
-			*usedPorts = AppendIfMissing(*usedPorts, dyno.PortNumber)
-		}
-	}
-	sort.Ints(*usedPorts)
-	log.Infof("Server.getNextPort :: Found used ports: %v", *usedPorts)
-	for _, usedPort := range *usedPorts {
-		if port == usedPort || dynoPortTracker.AlreadyInUse(nodeStatus.Host, port) {
-			port++
-		} else if usedPort > port {
-			break
-		}
-	}
-	if err := dynoPortTracker.Allocate(nodeStatus.Host, port); err != nil {
-		log.Infof("Server.getNextPort :: host/port combination %v/%v already in use, will find another", nodeStatus.Host, port)
-		*usedPorts = AppendIfMissing(*usedPorts, port)
-		return server.getNextPort(nodeStatus, usedPorts)
-	}
-	log.Infof("Server.getNextPort :: Result port: %v", port)
-	*usedPorts = AppendIfMissing(*usedPorts, port)
-	server.GlobalPortTracker.Using(port)
-	return port
-}
-
-// normalizeAppProcessName converts dashes to camelCase.
+// normalizeAppProcessName converts dashes and underscores to camelCase, which
+// is the form process names take in container names.
 func normalizeAppProcessName(process string) string {
-	// Camel-case process name to align with conversion in container creation
-	// shell script.
 	p := regexp.MustCompile(`[-_]+([a-zA-Z0-9])`).ReplaceAllStringFunc(process, func(s string) string {
 		return strings.ToUpper(string(s[1]))
 	})
 	return p
 }
+
+// sameProcess returns true when two process names refer to the same process
+// type.  Dyno metadata records process names verbatim while legacy dynos only
+// carry the normalized form parsed from their container name.
+func sameProcess(a string, b string) bool {
+	return normalizeAppProcessName(a) == normalizeAppProcessName(b)
+}
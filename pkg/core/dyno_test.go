@@ -1,6 +1,8 @@
 package core
 
 import (
+	"os/exec"
+	"strings"
 	"testing"
 
 	"github.com/jaytaylor/shipbuilder/pkg/bindata_buildpacks"
@@ -53,3 +55,86 @@ func TestContainerToDyno(t *testing.T) {
 		t.Logf("dyno=%# v", dyno)
 	}
 }
+
+func TestNodeStatusDyno(t *testing.T) {
+	ns := &NodeStatus{
+		Host:       "node-a",
+		Containers: []string{"myapp-v3-web-10001-Running", "my-app-v3-web-10002-Running", "legacy-v2-worker-10003-Running", "my-app-v3-webApi-10004-Running"},
+		DynoMetadata: map[string]map[string]string{
+			"my-app-v3-web-10002":    {"app": "my-app", "version": "v3", "process": "web", "port": "10002", "release": "abc123", "started": "2018-01-02T03:04:05Z"},
+			"myapp-v3-web-10001":     {"app": "myapp", "version": "v3"},
+			"my-app-v3-webApi-10004": {"app": "my-app", "version": "v3", "process": "web-api", "port": "10004", "index": "2"},
+		},
+	}
+
+	// Metadata is preferred over parsing the container name.
+	dyno, err := ns.Dyno("my-app-v3-web-10002-Running")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dyno.Application != "my-app" || dyno.PortNumber != 10002 || dyno.VersionNumber != 3 || dyno.State != DYNO_STATE_RUNNING || dyno.Release != "abc123" || dyno.StartedTs.IsZero() || dyno.Container != "my-app-v3-web-10002" {
+		t.Errorf("Unexpected dyno from metadata: %+v", dyno)
+	}
+
+	// Incomplete metadata is an error.
+	if _, err := ns.Dyno("myapp-v3-web-10001-Running"); err == nil {
+		t.Errorf("Expected error for incomplete dyno metadata")
+	}
+
+	// Process names are recorded verbatim, and match their legacy normalized
+	// form.
+	if dyno, err = ns.Dyno("my-app-v3-webApi-10004-Running"); err != nil || dyno.Process != "web-api" || dyno.Name() != "web-api.2" {
+		t.Errorf("Unexpected dyno=%+v err=%v", dyno, err)
+	}
+	if !sameProcess(dyno.Process, "webApi") || !sameProcess(dyno.Process, "web_api") || sameProcess(dyno.Process, "web") {
+		t.Errorf("Expected process %q to only match its normalized forms", dyno.Process)
+	}
+	if expected, actual := "app=my-app version=v3 process=web-api port=10004", strings.Join(dyno.identityOptions(), " "); actual != expected {
+		t.Errorf("Expected identity options=%q but actual=%q", expected, actual)
+	}
+
+	// Legacy containers without metadata fall back to name parsing.
+	if dyno, err = ns.Dyno("legacy-v2-worker-10003-Running"); err != nil || dyno.Application != "legacy" || dyno.Process != "worker" || dyno.Release != "" {
+		t.Errorf("Unexpected legacy dyno=%+v err=%v", dyno, err)
+	}
+}
+
+func TestRemoteAppShellArgs(t *testing.T) {
+	// The remote shell must see the same arguments as a local exec would.
+	args := []string{"ps", "aux", "|", "grep", "'[n]ode'"}
+	remote := remoteAppShellArgs(args...)
+	out, err := exec.Command("/bin/bash", "-c", `printf '%s\n' `+strings.Join(remote, " ")).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := strings.Join(appShellArgs(args...), "\n")+"\n", string(out); actual != expected {
+		t.Errorf("Expected remote args=%q but actual=%q", expected, actual)
+	}
+}
+
+func TestDynoIndexes(t *testing.T) {
+	dynos := []Dyno{
+		{Host: "node-b", Process: "web", Port: "10001", PortNumber: 10001},
+		{Host: "node-a", Process: "web", Port: "10003", PortNumber: 10003, Index: 2},
+		{Host: "node-a", Process: "web", Port: "10002", PortNumber: 10002, Index: 1},
+	}
+	sortDynos(dynos)
+	names := []string{}
+	for _, dyno := range dynos {
+		names = append(names, dyno.Name())
+	}
+	if expected := "web.1 web.2 web.10001"; strings.Join(names, " ") != expected {
+		t.Errorf("Expected dynos=%v but actual=%v", expected, names)
+	}
+
+	// Free indexes are claimed lowest first.
+	dg := &DynoGenerator{indexes: map[string]map[int]bool{"web": {1: true, 3: true}}}
+	for _, expected := range []int{2, 4} {
+		if actual := dg.nextIndex("web"); actual != expected {
+			t.Errorf("Expected index=%v but actual=%v", expected, actual)
+		}
+	}
+	if actual := dg.nextIndex("worker"); actual != 1 {
+		t.Errorf("Expected index=1 for new process type but actual=%v", actual)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -273,6 +274,14 @@ func (exe *Executor) AttachContainer(name string, args ...string) *exec.Cmd {
 	// if err != nil {
 	// 	fmt.Fprintf(exe.logger, "warn: host fix command failed for container '%v': %v\n", name, err)
 	// }
+	prefixedArgs := append([]string{"exec", name, "--"}, appShellArgs(args...)...)
+	log.Infof("AttachContainer name=%v, completeCommand=%v %v", name, LXC_BIN, args)
+	return logcmd(exec.Command(LXC_BIN, prefixedArgs...))
+}
+
+// appShellArgs returns the command which runs args, or an interactive shell
+// when args is empty, inside an app container with the app's environment.
+func appShellArgs(args ...string) []string {
 	// Build command to be run, prefixing any .shipbuilder `bin` directories to the environment $PATH.
 	command := `export PATH="$(find /app/.shipbuilder -maxdepth 2 -type d -wholename '*bin'):${PATH}" && /usr/bin/envdir ` + ENV_DIR + " "
 	if len(args) == 0 {
@@ -280,13 +289,21 @@ func (exe *Executor) AttachContainer(name string, args ...string) *exec.Cmd {
 	} else {
 		command += strings.Join(args, " ")
 	}
-	prefixedArgs := []string{
-		"exec", name, "--",
+	return []string{
 		"sudo", "-u", "ubuntu", "-n", "-i", "--",
 		"/bin/bash", "-c", command,
 	}
-	log.Infof("AttachContainer name=%v, completeCommand=%v %v", name, LXC_BIN, args)
-	return logcmd(exec.Command(LXC_BIN, prefixedArgs...))
+}
+
+// exitStatus returns the exit status of a command which ran to completion but
+// failed.
+func exitStatus(err error) (int, bool) {
+	if exiterr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus(), true
+		}
+	}
+	return 0, false
 }
 
 func (exe *Executor) ContainerFSMountpoint(name string) (string, error) {
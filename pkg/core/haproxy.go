@@ -0,0 +1,477 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gigawattio/errorlib"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	haProxyAdminSocket = "/run/haproxy/admin.sock"
+
+	HAProxyServerReady = "ready"
+	HAProxyServerDrain = "drain"
+	HAProxyServerMaint = "maint"
+)
+
+// haProxyAdminCommand returns the shell command which sends a command to the
+// HAProxy runtime API on a load-balancer.
+func haProxyAdminCommand(command string) string {
+	return fmt.Sprintf("echo %v | sudo socat stdio %v", bashQuote(command), haProxyAdminSocket)
+}
+
+// haProxyServerName returns the backend/server name a web dyno's server is
+// given when rendered into the HAProxy config.  Dynos added through the runtime
+// API occupy a server-template slot instead, see findHAProxyServer.
+func haProxyServerName(dyno Dyno) string {
+	return fmt.Sprintf("%v/%v-%v", dyno.Application, dyno.Host, dyno.Port)
+}
+
+// haProxyAddr returns the ip:port HAProxy reports for a server, resolving host
+// names as HAProxy does when loading its config.
+func haProxyAddr(host string, port string) string {
+	if net.ParseIP(host) == nil {
+		if ips, err := net.LookupIP(host); err == nil && len(ips) > 0 {
+			host = ips[0].String()
+			for _, ip := range ips {
+				if ip.To4() != nil {
+					host = ip.String()
+					break
+				}
+			}
+		}
+	}
+	return host + ":" + port
+}
+
+// isHAProxyMaint returns true when a server status reported by HAProxy, e.g.
+// "MAINT" or "MAINT (via app/other)", means the server is in maintenance.
+func isHAProxyMaint(status string) bool {
+	return strings.HasPrefix(status, "MAINT")
+}
+
+// findHAProxyServer returns the backend/server name and stats row of a web
+// dyno's server, found by address so servers in server-template slots are
+// found too.  Servers which are in service are preferred over those in
+// maintenance.
+func findHAProxyServer(stats map[string]map[string]string, dyno Dyno) (string, map[string]string, bool) {
+	var (
+		addr  = haProxyAddr(dyno.Host, dyno.Port)
+		names = []string{}
+	)
+	for name, row := range stats {
+		if row["pxname"] == dyno.Application && (row["addr"] == addr || name == haProxyServerName(dyno)) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", nil, false
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !isHAProxyMaint(stats[name]["status"]) {
+			return name, stats[name], true
+		}
+	}
+	return names[0], stats[names[0]], true
+}
+
+// setHAProxyServerState sets the administrative state of a web dyno's server on
+// each of the load-balancers, one of HAProxyServerReady, HAProxyServerDrain or
+// HAProxyServerMaint.
+func (server *Server) setHAProxyServerState(loadBalancers []string, dyno Dyno, state string) error {
+	errs := []error{}
+	for _, host := range loadBalancers {
+		stats, err := server.haProxyServerStats(host)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		name, _, ok := findHAProxyServer(stats, dyno)
+		if !ok {
+			errs = append(errs, fmt.Errorf("server for dyno %v not found on load-balancer %v", dyno.Name(), host))
+			continue
+		}
+		if err := haProxyRuntimeCommands(host, fmt.Sprintf("set server %v state %v", name, state)); err != nil {
+			errs = append(errs, fmt.Errorf("setting %v state to %v on load-balancer %v: %s", name, state, host, err))
+		}
+	}
+	return errorlib.Merge(errs)
+}
+
+// haProxyRuntimeNotices prefix the output of runtime API commands which
+// succeeded, e.g. "IP changed from '10.0.0.1' to '10.0.0.2', port changed from
+// '0' to '8080' by 'stats socket command'" from "set server ... addr".
+var haProxyRuntimeNotices = []string{
+	"IP changed from ",
+	"port changed from ",
+	"no need to change the addr",
+	"no need to change the port",
+}
+
+// haProxyRuntimeCommands runs one or more commands through the HAProxy runtime
+// API on a load-balancer, one at a time, stopping at the first which fails.
+func haProxyRuntimeCommands(host string, commands ...string) error {
+	for _, command := range commands {
+		output, err := RemoteCommand(host, haProxyAdminCommand(command))
+		if err != nil {
+			return err
+		}
+		if err := haProxyRuntimeError(command, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// haProxyRuntimeError returns the error reported by a runtime API command, if
+// any.  Most successful commands produce no output, the rest a known notice.
+func haProxyRuntimeError(command string, output string) error {
+	output = strings.TrimSpace(output)
+	if len(output) == 0 {
+		return nil
+	}
+	for _, notice := range haProxyRuntimeNotices {
+		if strings.HasPrefix(output, notice) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%v: %v", command, output)
+}
+
+// haProxyServerStats returns the HAProxy stats rows of a load-balancer keyed by
+// backend/server name.
+func (server *Server) haProxyServerStats(host string) (map[string]map[string]string, error) {
+	output, err := RemoteCommand(host, haProxyStatsCommand)
+	if err != nil {
+		return nil, fmt.Errorf("querying HAProxy stats on load-balancer %v: %s", host, err)
+	}
+	rows, err := parseHAProxyStatsRows(output)
+	if err != nil {
+		return nil, fmt.Errorf("parsing HAProxy stats from load-balancer %v: %s", host, err)
+	}
+	stats := map[string]map[string]string{}
+	for _, row := range rows {
+		stats[row["pxname"]+"/"+row["svname"]] = row
+	}
+	return stats, nil
+}
+
+// haProxyServerStatus returns the status of a web dyno's server on a
+// load-balancer as reported by HAProxy, e.g. "UP", "DOWN" or "MAINT".
+func (server *Server) haProxyServerStatus(host string, dyno Dyno) (string, error) {
+	stats, err := server.haProxyServerStats(host)
+	if err != nil {
+		return "", err
+	}
+	_, row, ok := findHAProxyServer(stats, dyno)
+	if !ok {
+		return "", fmt.Errorf("server for dyno %v not found on load-balancer %v", dyno.Name(), host)
+	}
+	return row["status"], nil
+}
+
+// busyHAProxyServers returns the servers of the given web dynos which still
+// have active sessions on a load-balancer.  Servers the load-balancer doesn't
+// know about have nothing to drain.
+func busyHAProxyServers(stats map[string]map[string]string, dynos []Dyno) []string {
+	busy := []string{}
+	for _, dyno := range dynos {
+		if name, row, ok := findHAProxyServer(stats, dyno); ok && row["scur"] != "" && row["scur"] != "0" {
+			busy = append(busy, fmt.Sprintf("%v (%v sessions)", name, row["scur"]))
+		}
+	}
+	return busy
+}
+
+// waitForHAProxyServersIdle waits until none of the web dynos' servers have
+// active sessions on any of the load-balancers.
+func (server *Server) waitForHAProxyServersIdle(loadBalancers []string, dynos []Dyno, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, host := range loadBalancers {
+		for {
+			stats, err := server.haProxyServerStats(host)
+			var busy []string
+			if err == nil {
+				if busy = busyHAProxyServers(stats, dynos); len(busy) == 0 {
+					break
+				}
+			}
+			if time.Now().After(deadline) {
+				if err == nil {
+					err = fmt.Errorf("%v still active", strings.Join(busy, ", "))
+				}
+				return fmt.Errorf("timed out after %v waiting for dynos to drain on load-balancer %v: %s", timeout, host, err)
+			}
+			time.Sleep(time.Second)
+		}
+	}
+	return nil
+}
+
+// webDynos returns the dynos which are routed to by the load-balancers.
+func webDynos(dynos []Dyno) []Dyno {
+	web := []Dyno{}
+	for _, dyno := range dynos {
+		if dyno.Process == "web" {
+			web = append(web, dyno)
+		}
+	}
+	return web
+}
+
+// drainDynos stops the load-balancers sending new requests to any web dynos
+// among those given, then waits up to timeout for their in-flight requests to
+// finish.  Problems are only logged since the dynos are on their way out
+// regardless.
+func (server *Server) drainDynos(logger io.Writer, loadBalancers []string, dynos []Dyno, timeout time.Duration) {
+	web := webDynos(dynos)
+	if len(web) == 0 || len(loadBalancers) == 0 {
+		return
+	}
+	fmt.Fprintf(logger, "Draining %v web dyno(s) from the load-balancers (timeout=%v)\n", len(web), timeout)
+	for _, dyno := range web {
+		if err := server.setHAProxyServerState(loadBalancers, dyno, HAProxyServerDrain); err != nil {
+			fmt.Fprintf(logger, "Warning: %s\n", err)
+		}
+	}
+	started := time.Now()
+	if err := server.waitForHAProxyServersIdle(loadBalancers, web, timeout); err != nil {
+		fmt.Fprintf(logger, "Warning: %s\n", err)
+		return
+	}
+	fmt.Fprintf(logger, "Drained %v web dyno(s) in %v\n", len(web), time.Since(started).Round(time.Second))
+}
+
+// waitForHAProxyServerUp waits until each of the load-balancers reports a web
+// dyno's server as passing its health check.
+func (server *Server) waitForHAProxyServerUp(loadBalancers []string, dyno Dyno, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, host := range loadBalancers {
+		for {
+			status, err := server.haProxyServerStatus(host, dyno)
+			if err == nil && status == "UP" {
+				break
+			}
+			if time.Now().After(deadline) {
+				if err == nil {
+					err = fmt.Errorf("status is %v", status)
+				}
+				return fmt.Errorf("timed out after %v waiting for dyno %v to become healthy on load-balancer %v: %s", timeout, dyno.Name(), host, err)
+			}
+			time.Sleep(time.Second)
+		}
+	}
+	return nil
+}
+
+// haProxyServerSlotPrefix returns the name prefix of the server-template slots
+// an app backend keeps spare for dynos added through the runtime API, e.g.
+// myapp-slot1, myapp-slot2, ...
+func haProxyServerSlotPrefix(backend string) string {
+	return backend + "-slot"
+}
+
+// haProxyDesiredServers returns the addresses of the servers each app backend
+// should be routing to.
+func haProxyDesiredServers(lbSpec *LBSpec) map[string][]string {
+	desired := map[string][]string{}
+	for _, app := range lbSpec.Applications {
+		addrs := []string{}
+		for _, s := range app.Servers {
+			addrs = append(addrs, haProxyAddr(s.Host, strconv.Itoa(s.Port)))
+		}
+		desired[app.Name] = addrs
+	}
+	return desired
+}
+
+// planHAProxyServerUpdates returns the runtime API commands which bring the
+// servers of each backend in line with the desired addresses.  Servers no
+// longer wanted are put into maintenance, and new addresses are given to free
+// server-template slots.  An error is returned when the change can't be made at
+// runtime, e.g. the backend doesn't exist yet or has run out of free slots.
+func planHAProxyServerUpdates(stats map[string]map[string]string, desired map[string][]string) ([]string, error) {
+	backends := []string{}
+	for backend, _ := range desired {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+
+	commands := []string{}
+	for _, backend := range backends {
+		if _, ok := stats[backend+"/BACKEND"]; !ok {
+			return nil, fmt.Errorf("backend %v not found", backend)
+		}
+		names := []string{}
+		for _, row := range stats {
+			if row["pxname"] == backend && row["svname"] != "FRONTEND" && row["svname"] != "BACKEND" {
+				names = append(names, row["svname"])
+			}
+		}
+		sort.Strings(names)
+
+		want := map[string]bool{}
+		for _, addr := range desired[backend] {
+			want[addr] = true
+		}
+
+		// Take servers which aren't wanted out of service.
+		serving := map[string]bool{}
+		for _, name := range names {
+			row := stats[backend+"/"+name]
+			if isHAProxyMaint(row["status"]) {
+				continue
+			}
+			if want[row["addr"]] && !serving[row["addr"]] {
+				serving[row["addr"]] = true
+				continue
+			}
+			commands = append(commands, fmt.Sprintf("set server %v/%v state maint", backend, name))
+		}
+
+		// Put the rest into service, preferring servers already at the address.
+		used := map[string]bool{}
+		for _, addr := range desired[backend] {
+			if serving[addr] {
+				continue
+			}
+			serving[addr] = true
+			slot := ""
+			for _, name := range names {
+				if row := stats[backend+"/"+name]; isHAProxyMaint(row["status"]) && row["addr"] == addr && !used[name] {
+					slot = name
+					break
+				}
+			}
+			if slot != "" {
+				used[slot] = true
+				commands = append(commands, fmt.Sprintf("set server %v/%v state ready", backend, slot))
+				continue
+			}
+			for _, name := range names {
+				if row := stats[backend+"/"+name]; strings.HasPrefix(name, haProxyServerSlotPrefix(backend)) && isHAProxyMaint(row["status"]) && !want[row["addr"]] && !used[name] {
+					slot = name
+					break
+				}
+			}
+			if slot == "" {
+				return nil, fmt.Errorf("no free server slots left in backend %v", backend)
+			}
+			used[slot] = true
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			commands = append(commands,
+				fmt.Sprintf("set server %v/%v addr %v port %v", backend, slot, host, port),
+				fmt.Sprintf("set server %v/%v state ready", backend, slot),
+			)
+		}
+	}
+	return commands, nil
+}
+
+// syncHAProxyRuntime brings the servers of a load-balancer's app backends in
+// line with the desired addresses through the runtime API, without a reload.
+// The number of commands run is returned.
+func (server *Server) syncHAProxyRuntime(host string, desired map[string][]string) (int, error) {
+	stats, err := server.haProxyServerStats(host)
+	if err != nil {
+		return 0, err
+	}
+	commands, err := planHAProxyServerUpdates(stats, desired)
+	if err != nil {
+		return 0, err
+	}
+	if len(commands) == 0 {
+		return 0, nil
+	}
+	if err := haProxyRuntimeCommands(host, commands...); err != nil {
+		return 0, fmt.Errorf("updating servers through the runtime API: %s", err)
+	}
+	return len(commands), nil
+}
+
+// renderLoadBalancerStructure renders the HAProxy config without any app
+// servers.  When it's unchanged between syncs only servers have changed, which
+// the runtime API can apply without a reload.
+func renderLoadBalancerStructure(lbSpec *LBSpec) (string, error) {
+	structure := *lbSpec
+	structure.Applications = []*LBApp{}
+	for _, app := range lbSpec.Applications {
+		a := *app
+		a.Servers = []*LBAppDyno{}
+		structure.Applications = append(structure.Applications, &a)
+	}
+	return renderLoadBalancerConfig(&structure)
+}
+
+// validateHAProxyConfig checks a rendered config with the local haproxy binary,
+// when one is installed.
+func validateHAProxyConfig(path string) error {
+	bin, err := exec.LookPath("haproxy")
+	if err != nil {
+		log.Debugf("Skipping local validation of %q, haproxy isn't installed", path)
+		return nil
+	}
+	if output, err := exec.Command(bin, "-c", "-q", "-f", path).CombinedOutput(); err != nil {
+		return fmt.Errorf("rendered HAProxy config failed validation, no load-balancers were updated: %s: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// haProxyDriver is the LoadBalancerDriver of HAProxy load-balancers, the
+// default.  Changes to app servers alone can be applied through the runtime
+// API without a reload.
+type haProxyDriver struct {
+	server *Server
+}
+
+func (driver *haProxyDriver) Name() string {
+	return LBDriverHAProxy
+}
+
+func (driver *haProxyDriver) ConfigPath() string {
+	return haProxyConfigPath
+}
+
+func (driver *haProxyDriver) Service() string {
+	return "haproxy"
+}
+
+func (driver *haProxyDriver) Render(lbSpec *LBSpec) (string, error) {
+	return renderLoadBalancerConfig(lbSpec)
+}
+
+func (driver *haProxyDriver) Validate(path string) error {
+	return validateHAProxyConfig(path)
+}
+
+func (driver *haProxyDriver) Deploy(e *Executor, host string, path string) error {
+	return deployLoadBalancerConfig(e, host, path, haProxyConfigPath, bashHAProxyValidateCommand)
+}
+
+func (driver *haProxyDriver) Reload(e *Executor, host string) error {
+	return reloadLoadBalancer(e, host, driver.Service(), haProxyConfigPath)
+}
+
+func (driver *haProxyDriver) ActiveConfig(host string) (string, error) {
+	return readLoadBalancerConfig(host, haProxyConfigPath)
+}
+
+func (driver *haProxyDriver) Routes(config string) (LBRoutes, error) {
+	return parseLBRoutes(config)
+}
+
+func (driver *haProxyDriver) UpdateServers(host string, desired map[string][]string) (int, error) {
+	return driver.server.syncHAProxyRuntime(host, desired)
+}
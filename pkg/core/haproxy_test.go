@@ -0,0 +1,93 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func parseTestHAProxyStats(t *testing.T, output string) map[string]map[string]string {
+	rows, err := parseHAProxyStatsRows(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := map[string]map[string]string{}
+	for _, row := range rows {
+		stats[row["pxname"]+"/"+row["svname"]] = row
+	}
+	return stats
+}
+
+func TestBusyHAProxyServers(t *testing.T) {
+	stats := parseTestHAProxyStats(t, `# pxname,svname,qcur,qmax,scur,smax,slim,stot,status,addr,
+myapp,10.0.0.1-10001,0,0,3,5,,60,DRAIN,10.0.0.1:10001,
+myapp,myapp-slot1,0,0,0,5,,60,DRAIN,10.0.0.2:10002,
+myapp,BACKEND,0,0,3,8,200,120,UP,,
+`)
+	dynos := []Dyno{
+		{Application: "myapp", Host: "10.0.0.1", Port: "10001"},
+		{Application: "myapp", Host: "10.0.0.2", Port: "10002"},
+		{Application: "myapp", Host: "10.0.0.3", Port: "10003"}, // Unknown to the load-balancer.
+	}
+	if expected, actual := []string{"myapp/10.0.0.1-10001 (3 sessions)"}, busyHAProxyServers(stats, dynos); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected busy servers=%v but actual=%v", expected, actual)
+	}
+	if actual := busyHAProxyServers(stats, dynos[1:]); len(actual) != 0 {
+		t.Errorf("Expected no busy servers but actual=%v", actual)
+	}
+}
+
+func TestPlanHAProxyServerUpdates(t *testing.T) {
+	stats := parseTestHAProxyStats(t, `# pxname,svname,scur,status,addr,
+myapp,10.0.0.1-10001,0,UP,10.0.0.1:10001,
+myapp,10.0.0.2-10002,2,DRAIN,10.0.0.2:10002,
+myapp,10.0.0.3-10003,0,MAINT,10.0.0.3:10003,
+myapp,myapp-slot1,0,UP,10.0.0.4:10004,
+myapp,myapp-slot2,0,MAINT,127.0.0.1:1,
+myapp,myapp-slot3,0,MAINT,127.0.0.1:1,
+myapp,BACKEND,2,UP,,
+`)
+
+	commands, err := planHAProxyServerUpdates(stats, map[string][]string{
+		"myapp": {"10.0.0.1:10001", "10.0.0.3:10003", "10.0.0.5:10005"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"set server myapp/10.0.0.2-10002 state maint",
+		"set server myapp/myapp-slot1 state maint",
+		"set server myapp/10.0.0.3-10003 state ready",
+		"set server myapp/myapp-slot2 addr 10.0.0.5 port 10005",
+		"set server myapp/myapp-slot2 state ready",
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Expected commands=%q but actual=%q", expected, commands)
+	}
+
+	if _, err := planHAProxyServerUpdates(stats, map[string][]string{"myapp": {"10.0.0.6:1", "10.0.0.7:1", "10.0.0.8:1"}}); err == nil {
+		t.Errorf("Expected running out of free slots to be an error")
+	}
+	if _, err := planHAProxyServerUpdates(stats, map[string][]string{"newapp": {}}); err == nil {
+		t.Errorf("Expected a missing backend to be an error")
+	}
+}
+
+func TestHAProxyRuntimeError(t *testing.T) {
+	testCases := []struct {
+		output   string
+		expected bool
+	}{
+		{"", false},
+		{"\n", false},
+		{"IP changed from '10.0.0.1' to '10.0.0.2', port changed from '0' to '8080' by 'stats socket command'\n", false},
+		{"no need to change the addr, port changed from '8080' to '8081' by 'stats socket command'\n", false},
+		{"No such server.\n", true},
+		{"Unknown command. Please enter one of the following commands only :\n", true},
+	}
+	for i, testCase := range testCases {
+		err := haProxyRuntimeError("set server app/slot1 addr 10.0.0.2 port 8080", testCase.output)
+		if (err != nil) != testCase.expected {
+			t.Errorf("[i=%v] Expected error=%v but actual=%v for output=%q", i, testCase.expected, err, testCase.output)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// LBDrift is the difference between the desired config of a load-balancer and
+// the config it actually has.
+type LBDrift struct {
+	Host   string
+	Driver string // Name of the load-balancer's driver.
+	Diff   string // Unified diff, empty when the load-balancer is in sync.
+	Err    error  // Set when the load-balancer's config couldn't be read.
+}
+
+// setLoadBalancerRoutes records the routes a load-balancer is serving.
+//
+// NB: syncLoadBalancerLock must be held.
+func (server *Server) setLoadBalancerRoutes(host string, routes LBRoutes) {
+	if server.loadBalancerRoutes == nil {
+		server.loadBalancerRoutes = map[string]LBRoutes{}
+	}
+	server.loadBalancerRoutes[host] = routes
+}
+
+// lbConfigRead is the outcome of reading the installed config of a
+// load-balancer.
+type lbConfigRead struct {
+	driver LoadBalancerDriver
+	config string
+	routes LBRoutes
+	err    error
+}
+
+// loadBalancerDrivers returns the driver each of the load-balancers uses.
+func (server *Server) loadBalancerDrivers(cfg *Config, hosts []string) (map[string]LoadBalancerDriver, error) {
+	drivers := map[string]LoadBalancerDriver{}
+	for _, host := range hosts {
+		driver, err := server.loadBalancerDriver(cfg, host)
+		if err != nil {
+			return nil, err
+		}
+		drivers[host] = driver
+	}
+	return drivers, nil
+}
+
+// readLoadBalancerConfigs concurrently reads the installed config of each
+// load-balancer with its driver, and the routes out of it.  Failures are
+// recorded per load-balancer.
+//
+// NB: syncLoadBalancerLock must not be held, the reads are over SSH.
+func readLoadBalancerConfigs(drivers map[string]LoadBalancerDriver) map[string]lbConfigRead {
+	var (
+		reads = map[string]lbConfigRead{}
+		lock  sync.Mutex
+		wg    sync.WaitGroup
+	)
+	for host, driver := range drivers {
+		wg.Add(1)
+		go func(host string, driver LoadBalancerDriver) {
+			defer wg.Done()
+			read := lbConfigRead{driver: driver}
+			if read.config, read.err = driver.ActiveConfig(host); read.err == nil {
+				if read.routes, read.err = driver.Routes(read.config); read.err != nil {
+					read.err = fmt.Errorf("load-balancer %v: %s", host, read.err)
+				}
+			}
+			lock.Lock()
+			reads[host] = read
+			lock.Unlock()
+		}(host, driver)
+	}
+	wg.Wait()
+	return reads
+}
+
+// ActiveLoadBalancerRoutes returns the routes served by each load-balancer.
+// Load-balancers which haven't been synced since startup, or whose last sync
+// failed, have their routes read from their installed config.  Those whose
+// config couldn't be read are left out, with the reason returned per host.
+func (server *Server) ActiveLoadBalancerRoutes() (map[string]LBRoutes, map[string]error, error) {
+	cfg, err := server.getConfig(true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		active  = map[string]LBRoutes{}
+		failed  = map[string]error{}
+		unknown = []string{}
+	)
+	syncLoadBalancerLock.Lock()
+	syncs := server.loadBalancerSyncs
+	for _, host := range cfg.LoadBalancers {
+		if routes, ok := server.loadBalancerRoutes[host]; ok {
+			active[host] = routes
+		} else {
+			unknown = append(unknown, host)
+		}
+	}
+	syncLoadBalancerLock.Unlock()
+
+	if len(unknown) == 0 {
+		return active, failed, nil
+	}
+	drivers, err := server.loadBalancerDrivers(cfg, unknown)
+	if err != nil {
+		return nil, nil, err
+	}
+	reads := readLoadBalancerConfigs(drivers)
+
+	syncLoadBalancerLock.Lock()
+	defer syncLoadBalancerLock.Unlock()
+	for host, read := range reads {
+		if read.err != nil {
+			failed[host] = read.err
+			continue
+		}
+		// Routes recorded by a sync which started after the snapshot are newer
+		// than the ones read.
+		if routes, ok := server.loadBalancerRoutes[host]; ok && server.loadBalancerSyncs != syncs {
+			active[host] = routes
+			continue
+		}
+		server.setLoadBalancerRoutes(host, read.routes)
+		active[host] = read.routes
+	}
+	return active, failed, nil
+}
+
+// LoadBalancerDrift compares the desired config of each load-balancer with the
+// config it has installed.  The desired config is the one last synced for the
+// load-balancer's driver, or when there hasn't been a sync since startup, one
+// rendered from the current state.  The recorded routes of each load-balancer
+// are refreshed from its installed config along the way.
+func (server *Server) LoadBalancerDrift() ([]LBDrift, error) {
+	cfg, err := server.getConfig(true)
+	if err != nil {
+		return nil, err
+	}
+
+	desiredConfigs := map[string]string{}
+	syncLoadBalancerLock.Lock()
+	syncs := server.loadBalancerSyncs
+	for name, config := range server.desiredLoadBalancerConfigs {
+		desiredConfigs[name] = config
+	}
+	syncLoadBalancerLock.Unlock()
+
+	drivers, err := server.loadBalancerDrivers(cfg, cfg.LoadBalancers)
+	if err != nil {
+		return nil, err
+	}
+	reads := readLoadBalancerConfigs(drivers)
+
+	var (
+		drifts = []LBDrift{}
+		lbSpec *LBSpec
+	)
+	for _, host := range cfg.LoadBalancers {
+		var (
+			driver = drivers[host]
+			read   = reads[host]
+		)
+		desired, ok := desiredConfigs[driver.Name()]
+		if !ok {
+			if lbSpec == nil {
+				if lbSpec, err = server.loadBalancerSpec(cfg, []Dyno{}, []Dyno{}); err != nil {
+					return nil, err
+				}
+			}
+			if desired, err = driver.Render(lbSpec); err != nil {
+				return nil, err
+			}
+			desiredConfigs[driver.Name()] = desired
+		}
+
+		drift := LBDrift{Host: host, Driver: driver.Name(), Err: read.err}
+		if read.err == nil {
+			drift.Diff, drift.Err = unifiedDiff(desired, read.config, "desired", host+":"+driver.ConfigPath())
+		}
+		drifts = append(drifts, drift)
+	}
+
+	// Unless a sync has since recorded newer routes.
+	syncLoadBalancerLock.Lock()
+	defer syncLoadBalancerLock.Unlock()
+	if server.loadBalancerSyncs == syncs {
+		for host, read := range reads {
+			if read.err == nil {
+				server.setLoadBalancerRoutes(host, read.routes)
+			}
+		}
+	}
+	return drifts, nil
+}
+
+// unifiedDiff returns the unified diff between two texts, empty when they're
+// the same.
+func unifiedDiff(a string, b string, aLabel string, bLabel string) (string, error) {
+	paths := []string{}
+	defer func() {
+		for _, path := range paths {
+			os.Remove(path)
+		}
+	}()
+	for _, content := range []string{a, b} {
+		f, err := ioutil.TempFile("", "sb-diff-")
+		if err != nil {
+			return "", err
+		}
+		paths = append(paths, f.Name())
+		_, err = f.WriteString(content)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	output, err := exec.Command("diff", "-u", "--label", aLabel, "--label", bLabel, paths[0], paths[1]).Output()
+	if err != nil {
+		if status, ok := exitStatus(err); ok && status == 1 {
+			// Exit status 1 means the inputs differ.
+			return string(output), nil
+		}
+		return "", fmt.Errorf("diffing %v and %v: %s", aLabel, bLabel, err)
+	}
+	return "", nil
+}
+
+// sysCheckLoadBalancerDrift reports load-balancers whose installed config
+// differs from the desired one, e.g. after a partially failed sync.
+func (server *Server) sysCheckLoadBalancerDrift(logger io.Writer) error {
+	drifts, err := server.LoadBalancerDrift()
+	if err != nil {
+		return err
+	}
+	drifted := []string{}
+	for _, drift := range drifts {
+		switch {
+		case drift.Err != nil:
+			fmt.Fprintf(logger, "Unable to check load-balancer %v: %s\n", drift.Host, drift.Err)
+			drifted = append(drifted, drift.Host)
+		case len(drift.Diff) > 0:
+			fmt.Fprintf(logger, "Load-balancer %v has drifted from the desired config (%v changed lines), run lb:diff for details or lb:sync to fix\n", drift.Host, diffChangedLines(drift.Diff))
+			drifted = append(drifted, drift.Host)
+		}
+	}
+	if len(drifted) > 0 {
+		return fmt.Errorf("%v of %v load-balancer(s) out of sync: %v", len(drifted), len(drifts), strings.Join(drifted, ", "))
+	}
+	fmt.Fprintf(logger, "All %v load-balancer(s) in sync\n", len(drifts))
+	return nil
+}
+
+// diffChangedLines counts the added and removed lines of a unified diff.
+func diffChangedLines(diff string) int {
+	n := 0
+	for _, line := range strings.Split(diff, "\n") {
+		if (strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "+++")) || (strings.HasPrefix(line, "-") && !strings.HasPrefix(line, "---")) {
+			n++
+		}
+	}
+	return n
+}
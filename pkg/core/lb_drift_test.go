@@ -0,0 +1,79 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/jaytaylor/shipbuilder/pkg/scripts"
+)
+
+func TestParseLBRoutes(t *testing.T) {
+	HAPROXY_CONFIG = template.Must(template.New("HAPROXY_CONFIG").Parse(scripts.HAProxySrc))
+
+	lbSpec := &LBSpec{
+		LogServerIpAndPort: "10.0.0.100:9998",
+		ServerSlots:        HAPROXY_SERVER_SLOTS,
+		Applications: []*LBApp{
+			{
+				Name:    "myapp",
+				Domains: []string{"myapp.example.com"},
+				Servers: []*LBAppDyno{{Host: "10.0.0.1", Port: 10001}, {Host: "10.0.0.2", Port: 10002}},
+			},
+			{
+				Name:    "otherapp",
+				Domains: []string{"otherapp.example.com"},
+				Servers: []*LBAppDyno{},
+			},
+		},
+	}
+	config, err := renderLoadBalancerConfig(lbSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err := parseLBRoutes(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, app := range []string{"myapp", "otherapp"} {
+		if expected := lbSpec.Routes()[app]; !reflect.DeepEqual(routes[app], expected) {
+			t.Errorf("Expected %v routes=%v but actual=%v", app, expected, routes[app])
+		}
+	}
+	if !routes.RoutesTo("myapp", "10.0.0.2", 10002) || routes.RoutesTo("myapp", "10.0.0.2", 10003) || routes.RoutesTo("otherapp", "10.0.0.1", 10001) {
+		t.Errorf("Unexpected routes: %v", routes)
+	}
+
+	diff, err := unifiedDiff(config, strings.Replace(config, "10.0.0.2:10002", "10.0.0.3:10003", 1), "desired", "actual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := diffChangedLines(diff); n != 2 {
+		t.Errorf("Expected 2 changed lines but actual=%v, diff:\n%v", n, diff)
+	}
+	if diff, err := unifiedDiff(config, config, "desired", "actual"); err != nil || diff != "" {
+		t.Errorf("Expected no diff but actual=%q (err=%v)", diff, err)
+	}
+}
+
+func TestReadLoadBalancerConfigs(t *testing.T) {
+	reads := readLoadBalancerConfigs(map[string]LoadBalancerDriver{
+		"lb-a": &fakeLBDriver{config: "myapp"},
+		"lb-b": &fakeLBDriver{configErr: errors.New("connection refused")},
+		"lb-c": &fakeLBDriver{config: "otherapp"},
+	})
+	if len(reads) != 3 {
+		t.Fatalf("Expected a read per load-balancer but actual=%+v", reads)
+	}
+	// A failure is recorded for its load-balancer alone.
+	for host, expected := range map[string]string{"lb-a": "myapp", "lb-c": "otherapp"} {
+		if read := reads[host]; read.err != nil || read.config != expected || !reflect.DeepEqual(read.routes, LBRoutes{expected: []LBAppDyno{}}) {
+			t.Errorf("[host=%v] Unexpected read=%+v", host, read)
+		}
+	}
+	if read := reads["lb-b"]; read.err == nil || read.routes != nil {
+		t.Errorf("Expected lb-b read to fail but actual=%+v", read)
+	}
+}
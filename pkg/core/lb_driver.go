@@ -0,0 +1,211 @@
+package core
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Load-balancer driver names, as given to lb:add.
+const (
+	LBDriverHAProxy = "haproxy"
+	LBDriverNginx   = "nginx"
+)
+
+// LBDrivers lists the names of the available load-balancer drivers.
+var LBDrivers = []string{LBDriverHAProxy, LBDriverNginx}
+
+// LoadBalancerDriver manages the software a load-balancer routes requests
+// with: rendering an LBSpec into its config format, and getting that config
+// validated and running on the load-balancer.
+type LoadBalancerDriver interface {
+	// Name returns the name the driver is selected by.
+	Name() string
+	// ConfigPath returns where the config is installed on a load-balancer.
+	ConfigPath() string
+	// Service returns the systemd service the load-balancer software runs as.
+	Service() string
+	// Render renders the config for a spec.
+	Render(lbSpec *LBSpec) (string, error)
+	// Validate checks the rendered config at a local path, when the
+	// load-balancer software is installed locally.
+	Validate(path string) error
+	// Deploy copies the rendered config at a local path to a load-balancer,
+	// validates it there and swaps it in, keeping the config it replaces.  The
+	// current config is left in place when validation fails.
+	Deploy(e *Executor, host string, path string) error
+	// Reload makes a load-balancer serve its installed config, restoring the
+	// previous config when that fails.
+	Reload(e *Executor, host string) error
+	// ActiveConfig returns the config a load-balancer has installed.
+	ActiveConfig(host string) (string, error)
+	// Routes reads the servers each app routes to out of a config.
+	Routes(config string) (LBRoutes, error)
+}
+
+// lbRuntimeUpdater is implemented by drivers which can change the servers of a
+// load-balancer without a reload.
+type lbRuntimeUpdater interface {
+	// UpdateServers brings the servers of each app in line with the desired
+	// addresses, returning the number of changes made.
+	UpdateServers(host string, desired map[string][]string) (int, error)
+}
+
+// newLoadBalancerDriver returns the driver with the given name.
+func (server *Server) newLoadBalancerDriver(name string) (LoadBalancerDriver, error) {
+	switch name {
+	case LBDriverHAProxy:
+		return &haProxyDriver{server: server}, nil
+	case LBDriverNginx:
+		return &nginxDriver{}, nil
+	}
+	return nil, fmt.Errorf("unknown load-balancer driver %q, must be one of: %v", name, strings.Join(LBDrivers, ", "))
+}
+
+// loadBalancerDriver returns the driver a load-balancer uses.
+func (server *Server) loadBalancerDriver(cfg *Config, host string) (LoadBalancerDriver, error) {
+	driver, err := server.newLoadBalancerDriver(cfg.LoadBalancerDriverName(host))
+	if err != nil {
+		return nil, fmt.Errorf("load-balancer %v: %s", host, err)
+	}
+	return driver, nil
+}
+
+// LoadBalancerDriverName returns the name of the driver a load-balancer uses.
+func (cfg *Config) LoadBalancerDriverName(host string) string {
+	if name := cfg.LoadBalancerDrivers[host]; len(name) > 0 {
+		return name
+	}
+	return LBDriverHAProxy
+}
+
+// LoadBalancersUsing returns the load-balancers which use the named driver.
+func (cfg *Config) LoadBalancersUsing(name string) []string {
+	hosts := []string{}
+	for _, host := range cfg.LoadBalancers {
+		if cfg.LoadBalancerDriverName(host) == name {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// LoadBalancerDriverSwitches returns the previous driver name of each of the
+// addresses which is already a load-balancer using a driver other than the
+// named one.
+func (cfg *Config) LoadBalancerDriverSwitches(addresses []string, name string) map[string]string {
+	switches := map[string]string{}
+	for _, address := range addresses {
+		for _, host := range cfg.LoadBalancers {
+			if host == address && cfg.LoadBalancerDriverName(host) != name {
+				switches[address] = cfg.LoadBalancerDriverName(host)
+			}
+		}
+	}
+	return switches
+}
+
+// syncLoadBalancer pushes the rendered config at path to a load-balancer with
+// its driver.  The config is validated on the load-balancer and swapped in
+// first, and only then applied either through the driver's runtime API, when
+// it has one and runtime is true, or with a reload.  That way the installed
+// config always matches the running state, and a later reload can't undo
+// runtime changes.  A description of how the config was applied is returned.
+func syncLoadBalancer(e *Executor, host string, driver LoadBalancerDriver, path string, runtime bool, desired map[string][]string) (string, error) {
+	if err := driver.Deploy(e, host, path); err != nil {
+		return "", err
+	}
+	if updater, ok := driver.(lbRuntimeUpdater); ok && runtime {
+		n, err := updater.UpdateServers(host, desired)
+		if err == nil {
+			return fmt.Sprintf("applied %v server update(s) through the runtime API without a reload", n), nil
+		}
+		fmt.Fprintf(e.Logger, "Runtime update of load-balancer %v not possible, falling back to a reload: %s\n", host, err)
+	}
+	if err := driver.Reload(e, host); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%v config installed and reloaded", driver.Name()), nil
+}
+
+// deployLoadBalancerConfig copies a rendered config to the staging path next
+// to configPath on a load-balancer, checks it with validateCommand and swaps it
+// in.
+func deployLoadBalancerConfig(e *Executor, host string, path string, configPath string, validateCommand string) error {
+	err := e.Run("rsync",
+		"-azve", "ssh "+DEFAULT_SSH_PARAMETERS,
+		path, "root@"+host+":"+configPath+stagedConfigSuffix,
+	)
+	if err != nil {
+		return fmt.Errorf("copying config to load-balancer %v: %s", host, err)
+	}
+	sshHost := DEFAULT_NODE_USERNAME + "@" + host
+	if err := e.Run("ssh", sshHost, validateCommand); err != nil {
+		return fmt.Errorf("config failed validation on load-balancer %v, its current config was left in place: %s", host, err)
+	}
+	if err := e.Run("ssh", sshHost, bashInstallConfigCommand(configPath)); err != nil {
+		return fmt.Errorf("installing config on load-balancer %v: %s", host, err)
+	}
+	return nil
+}
+
+// reloadLoadBalancer starts or reloads a service on a load-balancer.  When that
+// fails the config which configPath replaced is restored.
+func reloadLoadBalancer(e *Executor, host string, service string, configPath string) error {
+	if err := e.Run("ssh", DEFAULT_NODE_USERNAME+"@"+host, bashReloadServiceCommand(service, configPath)); err != nil {
+		return fmt.Errorf("reload failed on load-balancer %v, its previous config was restored: %s", host, err)
+	}
+	return nil
+}
+
+// switchLoadBalancerService stops and disables the service of the driver a
+// load-balancer is switching from, which would otherwise keep the ports bound,
+// and enables the one it is switching to.  The new service is started by the
+// next sync.
+func switchLoadBalancerService(e *Executor, host string, from LoadBalancerDriver, to LoadBalancerDriver) error {
+	if err := e.Run("ssh", DEFAULT_NODE_USERNAME+"@"+host, bashSwitchServiceCommand(from.Service(), to.Service())); err != nil {
+		return fmt.Errorf("switching load-balancer %v from %v to %v: %s", host, from.Name(), to.Name(), err)
+	}
+	return nil
+}
+
+// bashSwitchServiceCommand returns the command which stops and disables one
+// service in favor of another.
+func bashSwitchServiceCommand(from string, to string) string {
+	return `/bin/bash -c 'set -o errexit ; set -o pipefail ; sudo systemctl disable --now ` + from + ` ; sudo systemctl enable ` + to + `'`
+}
+
+// bashInstallConfigCommand returns the command which swaps in the staged
+// config for the one at path, keeping the previous one around.
+func bashInstallConfigCommand(path string) string {
+	var (
+		staged   = path + stagedConfigSuffix
+		previous = path + previousConfigSuffix
+	)
+	return `/bin/bash -c 'set -o errexit ; set -o pipefail ; if sudo test -f ` + path + ` ; then sudo cp -p ` + path + ` ` + previous + ` ; fi ; sudo mv ` + staged + ` ` + path + `'`
+}
+
+// bashReloadServiceCommand returns the command which starts a service if it
+// isn't running, otherwise reloads it.  When that fails the previous config is
+// restored to path and the service reloaded again, and the command exits 1.
+func bashReloadServiceCommand(service string, path string) string {
+	var (
+		previous = path + previousConfigSuffix
+		reload   = `if sudo systemctl is-active --quiet ` + service + ` ; then sudo systemctl reload ` + service + ` ; else sudo systemctl start ` + service + ` ; fi`
+	)
+	return `/bin/bash -c 'set -o errexit ; set -o pipefail ; if ! ( ` + reload + ` ) || ! sudo systemctl is-active --quiet ` + service + ` ; then echo "` + service + ` reload failed, restoring the previous config" >&2 ; if sudo test -f ` + previous + ` ; then sudo cp -p ` + previous + ` ` + path + ` ; ( ` + reload + ` ) || true ; fi ; exit 1 ; fi'`
+}
+
+// readLoadBalancerConfig returns the contents of the config at path on a
+// load-balancer.
+func readLoadBalancerConfig(host string, path string) (string, error) {
+	args := append([]string{"1m", "ssh", DEFAULT_NODE_USERNAME + "@" + host}, defaultSSHParametersList...)
+	args = append(args, "sudo", "cat", path)
+	// NB: Only stdout is wanted, ssh warnings would otherwise end up in the
+	// config.
+	bs, err := exec.Command("timeout", args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("reading %v from load-balancer %v: %s", path, host, err)
+	}
+	return string(bs), nil
+}
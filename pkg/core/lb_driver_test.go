@@ -0,0 +1,189 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeLBDriver records the calls made to it by syncLoadBalancer.
+type fakeLBDriver struct {
+	calls     []string
+	updateErr error
+	config    string
+	configErr error
+}
+
+func (driver *fakeLBDriver) Name() string                          { return "fake" }
+func (driver *fakeLBDriver) Service() string                       { return "fake" }
+func (driver *fakeLBDriver) ConfigPath() string                    { return "/etc/fake.cfg" }
+func (driver *fakeLBDriver) Render(lbSpec *LBSpec) (string, error) { return "", nil }
+func (driver *fakeLBDriver) Validate(path string) error            { return nil }
+func (driver *fakeLBDriver) ActiveConfig(host string) (string, error) {
+	return driver.config, driver.configErr
+}
+
+func (driver *fakeLBDriver) Routes(config string) (LBRoutes, error) {
+	return LBRoutes{config: []LBAppDyno{}}, nil
+}
+
+func (driver *fakeLBDriver) Deploy(e *Executor, host string, path string) error {
+	driver.calls = append(driver.calls, "deploy")
+	return nil
+}
+
+func (driver *fakeLBDriver) Reload(e *Executor, host string) error {
+	driver.calls = append(driver.calls, "reload")
+	return nil
+}
+
+func (driver *fakeLBDriver) UpdateServers(host string, desired map[string][]string) (int, error) {
+	driver.calls = append(driver.calls, "update")
+	return 1, driver.updateErr
+}
+
+func TestSyncLoadBalancerOrder(t *testing.T) {
+	e := &Executor{Logger: ioutil.Discard}
+	testCases := []struct {
+		runtime   bool
+		updateErr error
+		expected  []string
+	}{
+		// The staged config is always installed before runtime updates.
+		{true, nil, []string{"deploy", "update"}},
+		{true, errors.New("no free slots"), []string{"deploy", "update", "reload"}},
+		{false, nil, []string{"deploy", "reload"}},
+	}
+	for i, testCase := range testCases {
+		driver := &fakeLBDriver{updateErr: testCase.updateErr}
+		if _, err := syncLoadBalancer(e, "lb-a", driver, "/tmp/fake.cfg", testCase.runtime, nil); err != nil {
+			t.Fatalf("[i=%v] %s", i, err)
+		}
+		if !reflect.DeepEqual(driver.calls, testCase.expected) {
+			t.Errorf("[i=%v] Expected calls=%v but actual=%v", i, testCase.expected, driver.calls)
+		}
+	}
+}
+
+// withFakeSystemctl puts stand-ins for sudo and systemctl on the PATH.  The
+// service is active while dir/active exists, and reloads and starts fail while
+// dir/fail exists.
+func withFakeSystemctl(t *testing.T, dir string) func() {
+	bin := filepath.Join(dir, "bin")
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	scripts := map[string]string{
+		"sudo": `#!/bin/sh
+exec "$@"
+`,
+		"systemctl": `#!/bin/sh
+echo "$@" >> ` + dir + `/systemctl.log
+case "$1" in
+    is-active) test -f ` + dir + `/active ;;
+    reload|start) test ! -f ` + dir + `/fail && touch ` + dir + `/active ;;
+esac
+`,
+	}
+	for name, script := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	previous := os.Getenv("PATH")
+	os.Setenv("PATH", bin+":"+previous)
+	return func() { os.Setenv("PATH", previous) }
+}
+
+func TestLoadBalancerConfigCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shipbuilder-lb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer withFakeSystemctl(t, dir)()
+
+	var (
+		path = filepath.Join(dir, "haproxy.cfg")
+		read = func(path string) string {
+			bs, err := ioutil.ReadFile(path)
+			if err != nil {
+				return "<" + err.Error() + ">"
+			}
+			return string(bs)
+		}
+		write = func(path string, content string) {
+			if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		run = func(command string) error {
+			return exec.Command("/bin/bash", "-c", command).Run()
+		}
+	)
+
+	// The validate commands check the staged config.
+	for _, command := range []string{bashHAProxyValidateCommand, bashNginxValidateCommand} {
+		if !strings.HasSuffix(command, stagedConfigSuffix) {
+			t.Errorf("Expected validate command to check the staged config but actual=%v", command)
+		}
+	}
+
+	// First install, there's no previous config to keep.
+	write(path+stagedConfigSuffix, "v1")
+	if err := run(bashInstallConfigCommand(path)); err != nil {
+		t.Fatal(err)
+	}
+	if read(path) != "v1" {
+		t.Errorf("Expected installed config=v1 but actual=%v", read(path))
+	}
+	if _, err := os.Stat(path + previousConfigSuffix); !os.IsNotExist(err) {
+		t.Errorf("Expected no previous config after first install but err=%v", err)
+	}
+
+	// The installed config is kept as the previous one.
+	write(path+stagedConfigSuffix, "v2")
+	if err := run(bashInstallConfigCommand(path)); err != nil {
+		t.Fatal(err)
+	}
+	if read(path) != "v2" || read(path+previousConfigSuffix) != "v1" {
+		t.Errorf("Expected installed=v2 previous=v1 but actual installed=%v previous=%v", read(path), read(path+previousConfigSuffix))
+	}
+	if _, err := os.Stat(path + stagedConfigSuffix); !os.IsNotExist(err) {
+		t.Errorf("Expected staged config to be consumed but err=%v", err)
+	}
+
+	// A stopped service is started, a running one reloaded.
+	if err := run(bashReloadServiceCommand("haproxy", path)); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(bashReloadServiceCommand("haproxy", path)); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := "is-active --quiet haproxy\nstart haproxy\nis-active --quiet haproxy\nis-active --quiet haproxy\nreload haproxy\nis-active --quiet haproxy\n", read(filepath.Join(dir, "systemctl.log")); actual != expected {
+		t.Errorf("Expected systemctl calls:\n%v\nbut actual:\n%v", expected, actual)
+	}
+
+	// Switching drivers stops and disables the previous service.
+	os.Remove(filepath.Join(dir, "systemctl.log"))
+	if err := run(bashSwitchServiceCommand("haproxy", "nginx")); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := "disable --now haproxy\nenable nginx\n", read(filepath.Join(dir, "systemctl.log")); actual != expected {
+		t.Errorf("Expected systemctl calls:\n%v\nbut actual:\n%v", expected, actual)
+	}
+
+	// A failed reload restores the previous config and fails.
+	write(filepath.Join(dir, "fail"), "")
+	if err := run(bashReloadServiceCommand("haproxy", path)); err == nil {
+		t.Errorf("Expected failed reload to fail the command")
+	}
+	if read(path) != "v1" {
+		t.Errorf("Expected previous config=v1 to be restored but actual=%v", read(path))
+	}
+}
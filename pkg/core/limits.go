@@ -0,0 +1,144 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProcessLimits are the resource limits applied to each dyno container of a
+// process type.  Zero values mean unlimited.
+type ProcessLimits struct {
+	MemoryMb  int `json:"memoryMb,omitempty"`  // LXC limits.memory.
+	CPUs      int `json:"cpus,omitempty"`      // LXC limits.cpu, number of cores.
+	CPU       int `json:"cpu,omitempty"`       // LXC limits.cpu.allowance, percent of CPU time under contention (i.e. CPU shares).
+	Processes int `json:"processes,omitempty"` // LXC limits.processes.
+}
+
+// IsZero returns true when no limits are set.
+func (limits ProcessLimits) IsZero() bool {
+	return limits == ProcessLimits{}
+}
+
+// Merge returns the limits with any non-zero overrides applied.
+func (limits ProcessLimits) Merge(overrides ProcessLimits) ProcessLimits {
+	if overrides.MemoryMb > 0 {
+		limits.MemoryMb = overrides.MemoryMb
+	}
+	if overrides.CPUs > 0 {
+		limits.CPUs = overrides.CPUs
+	}
+	if overrides.CPU > 0 {
+		limits.CPU = overrides.CPU
+	}
+	if overrides.Processes > 0 {
+		limits.Processes = overrides.Processes
+	}
+	return limits
+}
+
+// Options returns the limits as postdeploy.py key=value options.
+func (limits ProcessLimits) Options() []string {
+	options := []string{}
+	if limits.MemoryMb > 0 {
+		options = append(options, fmt.Sprintf("memory=%vMB", limits.MemoryMb))
+	}
+	if limits.CPUs > 0 {
+		options = append(options, fmt.Sprintf("cpus=%v", limits.CPUs))
+	}
+	if limits.CPU > 0 {
+		options = append(options, fmt.Sprintf("cpu=%v%%", limits.CPU))
+	}
+	if limits.Processes > 0 {
+		options = append(options, fmt.Sprintf("processes=%v", limits.Processes))
+	}
+	return options
+}
+
+func (limits ProcessLimits) String() string {
+	if limits.IsZero() {
+		return "unlimited"
+	}
+	return strings.Join(limits.Options(), " ")
+}
+
+// ParseProcessLimits applies key=value limit settings, e.g. memory=512MB,
+// cpus=2, cpu=50% and processes=200, on top of existing limits.  An empty
+// value removes the limit.
+func ParseProcessLimits(limits ProcessLimits, args map[string]string) (ProcessLimits, error) {
+	keys := []string{}
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var (
+			value = strings.TrimSpace(args[key])
+			n     int
+			err   error
+		)
+		switch key {
+		case "memory":
+			if len(value) > 0 {
+				n, err = parseMemoryMb(value)
+			}
+			limits.MemoryMb = n
+
+		case "cpus":
+			if len(value) > 0 {
+				if n, err = strconv.Atoi(value); err == nil && n < 1 {
+					err = fmt.Errorf("must be at least 1")
+				}
+			}
+			limits.CPUs = n
+
+		case "cpu":
+			if len(value) > 0 {
+				if n, err = strconv.Atoi(strings.TrimSuffix(value, "%")); err == nil && (n < 1 || n > 100) {
+					err = fmt.Errorf("must be a percentage between 1%% and 100%%")
+				}
+			}
+			limits.CPU = n
+
+		case "processes":
+			if len(value) > 0 {
+				if n, err = strconv.Atoi(value); err == nil && n < 1 {
+					err = fmt.Errorf("must be at least 1")
+				}
+			}
+			limits.Processes = n
+
+		default:
+			return limits, fmt.Errorf("unrecognized limit %q, must be one of: memory, cpus, cpu, processes", key)
+		}
+		if err != nil {
+			return limits, fmt.Errorf("invalid %v limit %q: %s", key, value, err)
+		}
+	}
+	return limits, nil
+}
+
+// processLimits returns the effective limits for a process type, which are
+// those declared in the app manifest overridden by any set via `limits:set'.
+func (app *Application) processLimits(manifest *Manifest, process string) ProcessLimits {
+	process = normalizeAppProcessName(process)
+	limits := manifest.Limits(process)
+	if appLimits, ok := app.Limits[process]; ok {
+		limits = limits.Merge(appLimits)
+	}
+	return limits
+}
+
+// processLimits returns the effective limits for a process type of the
+// deployment's app.
+func (d *Deployment) processLimits(process string) ProcessLimits {
+	return d.Application.processLimits(d.manifest, process)
+}
+
+// dynoOptions returns the postdeploy.py options for starting a dyno of the
+// given process type.
+func (d *Deployment) dynoOptions(process string) []string {
+	return append(d.manifest.CheckOptions(process), d.processLimits(process).Options()...)
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProcessLimits(t *testing.T) {
+	testCases := []struct {
+		existing ProcessLimits
+		args     map[string]string
+		expected ProcessLimits
+		err      bool
+	}{
+		{
+			args:     map[string]string{"memory": "512MB", "cpus": "2", "cpu": "50%", "processes": "200"},
+			expected: ProcessLimits{MemoryMb: 512, CPUs: 2, CPU: 50, Processes: 200},
+		},
+		{
+			existing: ProcessLimits{MemoryMb: 512, CPUs: 2},
+			args:     map[string]string{"memory": "2GB", "cpus": ""},
+			expected: ProcessLimits{MemoryMb: 2048},
+		},
+		{args: map[string]string{"memory": "512"}, err: true},
+		{args: map[string]string{"cpus": "0"}, err: true},
+		{args: map[string]string{"cpu": "101%"}, err: true},
+		{args: map[string]string{"swap": "1GB"}, err: true},
+	}
+
+	for i, testCase := range testCases {
+		limits, err := ParseProcessLimits(testCase.existing, testCase.args)
+		if testCase.err {
+			if err == nil {
+				t.Errorf("[i=%v] Expected error for args=%v but got nil", i, testCase.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[i=%v] Unexpected error: %s", i, err)
+			continue
+		}
+		if limits != testCase.expected {
+			t.Errorf("[i=%v] Expected limits=%+v but actual=%+v", i, testCase.expected, limits)
+		}
+	}
+}
+
+func TestDeploymentProcessLimits(t *testing.T) {
+	m, err := ParseManifest([]byte("resources:\n  background_worker:\n    memory: 1GB\n    cpus: 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Deployment{
+		Application: &Application{
+			Name:   "test-app",
+			Limits: map[string]ProcessLimits{"backgroundWorker": {CPUs: 4, Processes: 100}},
+		},
+		manifest:       m,
+		manifestLoaded: true,
+	}
+
+	expected := []string{"memory=1024MB", "cpus=4", "processes=100"}
+	if actual := d.dynoOptions("background_worker"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected options=%v but actual=%v", expected, actual)
+	}
+	if actual := d.processLimits("web"); !actual.IsZero() {
+		t.Errorf("Expected no limits for web but actual=%v", actual)
+	}
+}
@@ -1,5 +1,12 @@
 package core
 
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
 // Load-balancer types.
 
 // LBAppDyno is a component of LBApp.
@@ -22,13 +29,15 @@ type LBApp struct {
 	SSLForwarding           bool // Whether or not to enable automatic SSL redirection.
 }
 
-// LBSpec contains information required to feed the HAProxy template generator.
+// LBSpec contains information required to feed the load-balancer config
+// templates.
 type LBSpec struct {
 	LogServerIpAndPort  string // ShipBuilder server ip:port to send HAProxy UDP logs to.
 	Applications        []*LBApp
 	LoadBalancers       []string
 	HaProxyStatsEnabled bool
 	HaProxyCredentials  string
+	ServerSlots         int // Spare server-template slots per app backend for servers added at runtime.
 }
 
 // SSLForwardingDomains returns the list of domain names which have SSL
@@ -52,3 +61,67 @@ func (lbSpec LBSpec) DynHdrFlags() string {
 	}
 	return ""
 }
+
+// LBRoutes maps each app to the servers a load-balancer routes it to.
+type LBRoutes map[string][]LBAppDyno
+
+// Routes returns the servers each app routes to according to the spec.
+func (lbSpec LBSpec) Routes() LBRoutes {
+	routes := LBRoutes{}
+	for _, app := range lbSpec.Applications {
+		servers := []LBAppDyno{}
+		for _, s := range app.Servers {
+			servers = append(servers, *s)
+		}
+		routes[app.Name] = servers
+	}
+	return routes
+}
+
+// RoutesTo returns true when a backend routes to the server at host:port.
+func (routes LBRoutes) RoutesTo(backend string, host string, port int) bool {
+	for _, s := range routes[backend] {
+		if s.Host == host && s.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLBRoutes reads the servers of each backend out of an HAProxy config.
+// Spare server-template slots aren't routes, as they're disabled until filled
+// through the runtime API.
+func parseLBRoutes(config string) (LBRoutes, error) {
+	var (
+		routes  = LBRoutes{}
+		backend string
+	)
+	for _, line := range strings.Split(config, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			// Start of a new section.
+			backend = ""
+			if fields[0] == "backend" && len(fields) > 1 {
+				backend = fields[1]
+				routes[backend] = []LBAppDyno{}
+			}
+			continue
+		}
+		if backend == "" || fields[0] != "server" || len(fields) < 3 {
+			continue
+		}
+		host, portStr, err := net.SplitHostPort(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("parsing server address of %q: %s", strings.TrimSpace(line), err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing server port of %q: %s", strings.TrimSpace(line), err)
+		}
+		routes[backend] = append(routes[backend], LBAppDyno{Host: host, Port: port})
+	}
+	return routes, nil
+}
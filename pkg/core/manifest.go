@@ -0,0 +1,433 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gigawattio/errorlib"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// MANIFEST_FILE is the optional in-repo app manifest.
+const MANIFEST_FILE = "shipbuilder.yml"
+
+const defaultManifestCheckTimeout = 60 * time.Second
+
+// Manifest is the app setup declared in the app repository, so that it can be
+// reviewed along with the code.
+type Manifest struct {
+	BuildPack      string                              `yaml:"buildpack"`
+	Formation      map[string]int                      `yaml:"formation"`       // Default number of dynos per process type.
+	Checks         map[string]ManifestCheck            `yaml:"checks"`          // HTTP health-checks per process type.
+	RequiredConfig []string                            `yaml:"required_config"` // Environment variables which must be set before deploying.
+	Resources      map[string]ManifestProcessResources `yaml:"resources"`       // Container limits per process type.
+
+	root *yaml.Node
+}
+
+// ManifestCheck is an HTTP health-check which a dyno must pass before it is
+// considered started.
+type ManifestCheck struct {
+	Path    string `yaml:"path"`
+	Timeout string `yaml:"timeout"`
+}
+
+// ManifestProcessResources are the container limits for dynos of a process
+// type.
+type ManifestProcessResources struct {
+	Memory    string `yaml:"memory"`    // e.g. "512MB" or "1GB".
+	CPUs      int    `yaml:"cpus"`      // Number of cores.
+	CPU       string `yaml:"cpu"`       // Percent of CPU time under contention, e.g. "50%".
+	Processes int    `yaml:"processes"` // Maximum number of processes.
+}
+
+// args returns the resources in the key=value form accepted by
+// ParseProcessLimits.
+func (resources ManifestProcessResources) args() map[string]string {
+	args := map[string]string{}
+	if len(resources.Memory) > 0 {
+		args["memory"] = resources.Memory
+	}
+	if resources.CPUs != 0 {
+		args["cpus"] = strconv.Itoa(resources.CPUs)
+	}
+	if len(resources.CPU) > 0 {
+		args["cpu"] = resources.CPU
+	}
+	if resources.Processes != 0 {
+		args["processes"] = strconv.Itoa(resources.Processes)
+	}
+	return args
+}
+
+// TimeoutDuration returns the parsed check timeout, or the default when unset.
+func (check ManifestCheck) TimeoutDuration() (time.Duration, error) {
+	if len(check.Timeout) == 0 {
+		return defaultManifestCheckTimeout, nil
+	}
+	return time.ParseDuration(check.Timeout)
+}
+
+// ParseManifest parses manifest content.  Errors include the offending line
+// number.
+func ParseManifest(data []byte) (*Manifest, error) {
+	root := &yaml.Node{}
+	if err := yaml.Unmarshal(data, root); err != nil {
+		return nil, fmt.Errorf("%v %s", MANIFEST_FILE, err)
+	}
+
+	m := &Manifest{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(m); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%v %s", MANIFEST_FILE, err)
+	}
+	m.root = root
+	return m, nil
+}
+
+// lookup returns the node at the given path along with the line number of its
+// key, falling back to the line of the closest parent which exists.
+func (m *Manifest) lookup(path ...string) (int, *yaml.Node) {
+	if m.root == nil || len(m.root.Content) == 0 {
+		return 1, nil
+	}
+	var (
+		node = m.root.Content[0]
+		line = node.Line
+	)
+	for _, key := range path {
+		var found *yaml.Node
+		for i := 0; node.Kind == yaml.MappingNode && i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				line = node.Content[i].Line
+				found = node.Content[i+1]
+				break
+			}
+		}
+		if found == nil {
+			return line, nil
+		}
+		node = found
+	}
+	return line, node
+}
+
+// line returns the line number of the key at the given path.
+func (m *Manifest) line(path ...string) int {
+	line, _ := m.lookup(path...)
+	return line
+}
+
+// sequenceLine returns the line number of the i'th item of the sequence at the
+// given path.
+func (m *Manifest) sequenceLine(i int, path ...string) int {
+	line, node := m.lookup(path...)
+	if node != nil && node.Kind == yaml.SequenceNode && i < len(node.Content) {
+		return node.Content[i].Line
+	}
+	return line
+}
+
+func (m *Manifest) errorf(path []string, format string, args ...interface{}) error {
+	return fmt.Errorf("%v line %v: %s", MANIFEST_FILE, m.line(path...), fmt.Sprintf(format, args...))
+}
+
+// Validate checks the manifest against the processes declared in the
+// Procfile, the app environment and the available buildpacks.
+func (m *Manifest) Validate(processes map[string]struct{}, environment map[string]string, buildpacks []string) error {
+	errs := []error{}
+
+	if len(m.BuildPack) > 0 {
+		found := false
+		for _, bp := range buildpacks {
+			if bp == m.BuildPack {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, m.errorf([]string{"buildpack"}, "unknown buildpack %q, must be one of: %v", m.BuildPack, strings.Join(buildpacks, ", ")))
+		}
+	}
+
+	checkProcess := func(section string, process string) error {
+		if _, ok := processes[normalizeAppProcessName(process)]; !ok {
+			return m.errorf([]string{section, process}, "process type %q is not declared in the Procfile", process)
+		}
+		return nil
+	}
+
+	for _, process := range sortedIntKeys(m.Formation) {
+		if err := checkProcess("formation", process); err != nil {
+			errs = append(errs, err)
+		}
+		if m.Formation[process] < 0 {
+			errs = append(errs, m.errorf([]string{"formation", process}, "number of dynos for %q must not be negative", process))
+		}
+	}
+
+	checkProcesses := []string{}
+	for process := range m.Checks {
+		checkProcesses = append(checkProcesses, process)
+	}
+	sort.Strings(checkProcesses)
+	for _, process := range checkProcesses {
+		check := m.Checks[process]
+		if err := checkProcess("checks", process); err != nil {
+			errs = append(errs, err)
+		}
+		if !strings.HasPrefix(check.Path, "/") {
+			errs = append(errs, m.errorf([]string{"checks", process, "path"}, "check path for %q must begin with a slash", process))
+		}
+		if timeout, err := check.TimeoutDuration(); err != nil {
+			errs = append(errs, m.errorf([]string{"checks", process, "timeout"}, "invalid check timeout for %q: %s", process, err))
+		} else if timeout < time.Second || timeout >= DYNO_START_TIMEOUT_SECONDS*time.Second {
+			errs = append(errs, m.errorf([]string{"checks", process, "timeout"}, "check timeout for %q must be at least 1s and less than %vs", process, DYNO_START_TIMEOUT_SECONDS))
+		}
+	}
+
+	for i, key := range m.RequiredConfig {
+		if _, ok := environment[key]; !ok {
+			errs = append(errs, fmt.Errorf("%v line %v: required config %q is not set, add it with `config:set %v=...`", MANIFEST_FILE, m.sequenceLine(i, "required_config"), key, key))
+		}
+	}
+
+	resourceProcesses := []string{}
+	for process := range m.Resources {
+		resourceProcesses = append(resourceProcesses, process)
+	}
+	sort.Strings(resourceProcesses)
+	for _, process := range resourceProcesses {
+		resources := m.Resources[process]
+		if err := checkProcess("resources", process); err != nil {
+			errs = append(errs, err)
+		}
+		args := resources.args()
+		for _, key := range sortedStringKeys(args) {
+			if _, err := ParseProcessLimits(ProcessLimits{}, map[string]string{key: args[key]}); err != nil {
+				errs = append(errs, m.errorf([]string{"resources", process, key}, "%s", err))
+			}
+		}
+	}
+
+	return errorlib.Merge(errs)
+}
+
+// CheckOptions returns the postdeploy.py key=value health-check options for
+// starting a dyno of the given process type.
+func (m *Manifest) CheckOptions(process string) []string {
+	options := []string{}
+	if m == nil {
+		return options
+	}
+	for p, check := range m.Checks {
+		if normalizeAppProcessName(p) != normalizeAppProcessName(process) {
+			continue
+		}
+		timeout, _ := check.TimeoutDuration()
+		options = append(options, "check="+check.Path, fmt.Sprintf("checkTimeout=%v", int(timeout.Seconds())))
+	}
+	return options
+}
+
+// Limits returns the container limits declared for a process type.
+func (m *Manifest) Limits(process string) ProcessLimits {
+	limits := ProcessLimits{}
+	if m == nil {
+		return limits
+	}
+	for p, resources := range m.Resources {
+		if normalizeAppProcessName(p) == normalizeAppProcessName(process) {
+			// NB: Already checked by Validate.
+			limits, _ = ParseProcessLimits(limits, resources.args())
+		}
+	}
+	return limits
+}
+
+var memoryExpr = regexp.MustCompile(`^([0-9]+)(MB|GB|TB|MiB|GiB|TiB)$`)
+
+// parseMemoryMb converts an LXC memory limit such as "512MB" or "2GB" into
+// megabytes.
+func parseMemoryMb(s string) (int, error) {
+	matches := memoryExpr.FindStringSubmatch(s)
+	if len(matches) != 3 {
+		return 0, fmt.Errorf("invalid memory limit %q, must be a whole number followed by one of: MB, GB, TB, MiB, GiB, TiB", s)
+	}
+	n, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory limit %q: %s", s, err)
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("invalid memory limit %q, must be greater than zero", s)
+	}
+	switch matches[2] {
+	case "GB", "GiB":
+		n *= 1024
+	case "TB", "TiB":
+		n *= 1024 * 1024
+	}
+	return n, nil
+}
+
+// procfileProcesses returns the set of normalized process types declared in a
+// Procfile.
+func procfileProcesses(r io.Reader) map[string]struct{} {
+	var (
+		processes = map[string]struct{}{}
+		scanner   = bufio.NewScanner(r)
+	)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if i := strings.Index(line, ":"); i > 0 {
+			processes[normalizeAppProcessName(line[0:i])] = struct{}{}
+		}
+	}
+	return processes
+}
+
+// loadManifest reads and parses the app manifest at a git revision, HEAD when
+// empty.  Returns a nil manifest when the app doesn't have one.
+func (app *Application) loadManifest(revision string) (*Manifest, error) {
+	return readManifest(app.gitContent(revision, MANIFEST_FILE))
+}
+
+// readManifest parses the manifest content found by a source lookup.  Returns
+// a nil manifest when the lookup didn't find one.
+func readManifest(r io.Reader, err error) (*Manifest, error) {
+	if err != nil {
+		if err == os.ErrNotExist {
+			return nil, nil
+		}
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading %v: %s", MANIFEST_FILE, err)
+	}
+	return ParseManifest(data)
+}
+
+// loadManifest reads and parses the app manifest from the deployment source.
+// Deployments without a source of their own, e.g. scaling, use the manifest of
+// the app's current release.  Returns a nil manifest when the app doesn't have
+// one.
+func (d *Deployment) loadManifest() (*Manifest, error) {
+	if d.manifestLoaded {
+		return d.manifest, nil
+	}
+	var (
+		m   *Manifest
+		err error
+	)
+	if len(d.Archive) == 0 && len(d.Revision) == 0 {
+		m, err = d.Application.loadManifest(d.Application.deployedRevision())
+	} else {
+		m, err = readManifest(d.sourceContent(MANIFEST_FILE))
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.manifest = m
+	d.manifestLoaded = true
+	return m, nil
+}
+
+// validateManifest validates the app manifest, if one exists.
+func (d *Deployment) validateManifest() error {
+	m, err := d.loadManifest()
+	if err != nil || m == nil {
+		return err
+	}
+	r, err := d.sourceContent("Procfile")
+	if err != nil {
+		if err == os.ErrNotExist {
+			// Reported by validateProcfile.
+			return nil
+		}
+		return err
+	}
+	return m.Validate(procfileProcesses(r), d.Application.Environment, d.Server.BuildpacksProvider.Available())
+}
+
+// applyManifestBuildPack switches the app to the buildpack declared in the
+// manifest.  The app image container is rebuilt from the new base.
+func (d *Deployment) applyManifestBuildPack() error {
+	m, err := d.loadManifest()
+	if err != nil || m == nil || len(m.BuildPack) == 0 || m.BuildPack == d.Application.BuildPack {
+		return err
+	}
+	if _, err := d.Server.BuildpacksProvider.New(m.BuildPack); err != nil {
+		return m.errorf([]string{"buildpack"}, "unknown buildpack %q: %s", m.BuildPack, err)
+	}
+
+	fmt.Fprintf(NewFormatter(d.Logger, GREEN), "Switching buildpack from %v to %v per %v\n", d.Application.BuildPack, m.BuildPack, MANIFEST_FILE)
+	if err := d.exe.DestroyContainer(d.Application.Name); err != nil && err != ErrContainerNotFound {
+		return fmt.Errorf("destroying app image container: %s", err)
+	}
+	if err := d.Server.WithPersistentApplication(d.Application.Name, func(app *Application, cfg *Config) error {
+		app.BuildPack = m.BuildPack
+		return nil
+	}); err != nil {
+		return err
+	}
+	d.Application.BuildPack = m.BuildPack
+	return nil
+}
+
+// applyManifestFormation scales process types according to the manifest
+// formation.  Defaults only apply to process types which haven't been scaled
+// yet, or to all of them upon the first deploy of an app, so scaling done via
+// `ps:scale' is preserved.
+func (d *Deployment) applyManifestFormation() error {
+	m, err := d.loadManifest()
+	if err != nil || m == nil || len(m.Formation) == 0 {
+		return err
+	}
+	firstDeploy := d.Version == "v1"
+	formation := map[string]int{}
+	for process, n := range m.Formation {
+		if current, ok := d.Application.Processes[process]; !ok || (firstDeploy && current != n) {
+			formation[process] = n
+		}
+	}
+	if len(formation) == 0 {
+		return nil
+	}
+
+	if err := d.Server.WithPersistentApplication(d.Application.Name, func(app *Application, cfg *Config) error {
+		if app.Processes == nil {
+			app.Processes = map[string]int{}
+		}
+		for process, n := range formation {
+			app.Processes[process] = n
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if d.Application.Processes == nil {
+		d.Application.Processes = map[string]int{}
+	}
+	titleLogger := NewFormatter(d.Logger, GREEN)
+	for _, process := range sortedIntKeys(formation) {
+		d.Application.Processes[process] = formation[process]
+		fmt.Fprintf(titleLogger, "Scaled %v to %v per %v\n", process, formation[process], MANIFEST_FILE)
+	}
+	log.WithField("app", d.Application.Name).Debugf("Applied manifest formation: %v", formation)
+	return nil
+}
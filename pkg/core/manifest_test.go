@@ -0,0 +1,169 @@
+package core
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestManifestValidate(t *testing.T) {
+	var (
+		processes   = procfileProcesses(strings.NewReader("web: ./run-server\nbackground_worker: ./run-worker\n# cron: ./nope\n"))
+		environment = map[string]string{"DATABASE_URL": "postgres://"}
+		buildpacks  = []string{"python", "nodejs"}
+	)
+
+	testCases := []struct {
+		manifest string
+		errs     []string
+	}{
+		{
+			manifest: `buildpack: python
+formation:
+  web: 2
+  background_worker: 1
+checks:
+  web:
+    path: /healthz
+    timeout: 30s
+required_config:
+  - DATABASE_URL
+resources:
+  web:
+    memory: 512MB
+    cpus: 2
+`,
+		},
+		{
+			manifest: ``,
+		},
+		{
+			manifest: `buildpack: cobol
+formation:
+  web: 2
+  cron: 1
+checks:
+  web:
+    path: healthz
+    timeout: 5m
+required_config:
+  - DATABASE_URL
+  - SECRET_KEY
+resources:
+  web:
+    memory: lots
+    cpu: 150%
+`,
+			errs: []string{
+				"shipbuilder.yml line 1: unknown buildpack",
+				"shipbuilder.yml line 4: process type \"cron\"",
+				"shipbuilder.yml line 7: check path",
+				"shipbuilder.yml line 8: check timeout",
+				"shipbuilder.yml line 11: required config \"SECRET_KEY\"",
+				"shipbuilder.yml line 14: invalid memory limit",
+				"shipbuilder.yml line 15: invalid cpu limit",
+			},
+		},
+		{
+			manifest: "formation:\n\tweb: 1\n",
+			errs:     []string{"shipbuilder.yml yaml: line 2:"},
+		},
+		{
+			manifest: "formation:\n  web: 1\nscale: 2\n",
+			errs:     []string{"line 3: field scale not found"},
+		},
+	}
+
+	for i, testCase := range testCases {
+		m, err := ParseManifest([]byte(testCase.manifest))
+		if err == nil {
+			err = m.Validate(processes, environment, buildpacks)
+		}
+		if len(testCase.errs) == 0 {
+			if err != nil {
+				t.Errorf("[i=%v] Unexpected error: %s", i, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("[i=%v] Expected errors=%v but got nil", i, testCase.errs)
+			continue
+		}
+		for _, expected := range testCase.errs {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("[i=%v] Expected error to contain %q but actual=%s", i, expected, err)
+			}
+		}
+	}
+}
+
+func TestManifestDynoOptions(t *testing.T) {
+	m, err := ParseManifest([]byte(`checks:
+  background_worker:
+    path: /status
+resources:
+  backgroundWorker:
+    memory: 1GB
+    cpu: 25%
+    processes: 100
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"check=/status", "checkTimeout=60"}
+	if actual := m.CheckOptions("backgroundWorker"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected check options=%v but actual=%v", expected, actual)
+	}
+	if expected := (ProcessLimits{MemoryMb: 1024, CPU: 25, Processes: 100}); m.Limits("backgroundWorker") != expected {
+		t.Errorf("Expected limits=%+v but actual=%+v", expected, m.Limits("backgroundWorker"))
+	}
+	if actual := m.CheckOptions("web"); len(actual) != 0 {
+		t.Errorf("Expected no check options for web but actual=%v", actual)
+	}
+	if actual := (*Manifest)(nil).CheckOptions("web"); len(actual) != 0 {
+		t.Errorf("Expected no check options for nil manifest but actual=%v", actual)
+	}
+	if actual := (*Manifest)(nil).Limits("web"); !actual.IsZero() {
+		t.Errorf("Expected no limits for nil manifest but actual=%+v", actual)
+	}
+}
+
+func TestLoadManifestFromGit(t *testing.T) {
+	revision, cleanup := withTestGitRepo(t, "myapp", map[string]string{
+		"Procfile":    "web: ./run-server\n",
+		MANIFEST_FILE: "buildpack: python\nformation:\n  web: 2\n",
+	})
+	defer cleanup()
+
+	for _, rev := range []string{revision, ""} {
+		d := &Deployment{Application: &Application{Name: "myapp"}, Revision: rev}
+		m, err := d.loadManifest()
+		if err != nil {
+			t.Fatalf("[revision=%q] %s", rev, err)
+		}
+		if m == nil || m.BuildPack != "python" || m.Formation["web"] != 2 {
+			t.Errorf("[revision=%q] Unexpected manifest: %+v", rev, m)
+		}
+	}
+
+	app := &Application{Name: "myapp", LastDeploy: "v1", Limits: map[string]ProcessLimits{"web": {Processes: 100}}}
+	if err := app.createReleaseRef(app.LastDeploy, revision); err != nil {
+		t.Fatal(err)
+	}
+	if actual := app.deployedRevision(); actual != releaseRef("v1") {
+		t.Errorf("Expected deployed revision=%v but actual=%v", releaseRef("v1"), actual)
+	}
+	m, err := app.loadManifest(app.deployedRevision())
+	if err != nil || m == nil {
+		t.Fatalf("Expected the manifest of the deployed release but actual=%+v (err=%v)", m, err)
+	}
+	if limits := app.processLimits(m, "web"); limits.Processes != 100 {
+		t.Errorf("Expected app limits to apply but actual=%v", limits)
+	}
+
+	if _, err := app.gitContent(revision, "missing.txt"); err != os.ErrNotExist {
+		t.Errorf("Expected err=%v for a missing file but actual=%v", os.ErrNotExist, err)
+	}
+}
@@ -15,8 +15,14 @@ const (
 	Hijack
 	ReadLineRequest
 	ReadLineResponse
+	UploadRequest
+	UploadData
 )
 
+// uploadChunkSize is the maximum number of bytes sent per UploadData message.
+// An empty UploadData message signals the end of the upload.
+const uploadChunkSize = 1024 * 1024
+
 type MessageType byte
 type Message struct {
 	Type MessageType
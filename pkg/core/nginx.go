@@ -0,0 +1,117 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// nginxDriver is the LoadBalancerDriver of nginx load-balancers.  nginx has no
+// runtime API, so every change is applied with a reload, which lets in-flight
+// requests finish on the old worker processes.
+type nginxDriver struct{}
+
+func (driver *nginxDriver) Name() string {
+	return LBDriverNginx
+}
+
+func (driver *nginxDriver) ConfigPath() string {
+	return nginxConfigPath
+}
+
+func (driver *nginxDriver) Service() string {
+	return "nginx"
+}
+
+func (driver *nginxDriver) Render(lbSpec *LBSpec) (string, error) {
+	buf := bytes.Buffer{}
+	if err := NGINX_CONFIG.Execute(&buf, lbSpec); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Validate checks a rendered config with the local nginx binary, when one is
+// installed.
+func (driver *nginxDriver) Validate(path string) error {
+	bin, err := exec.LookPath("nginx")
+	if err != nil {
+		log.Debugf("Skipping local validation of %q, nginx isn't installed", path)
+		return nil
+	}
+	if output, err := exec.Command(bin, "-t", "-q", "-c", path).CombinedOutput(); err != nil {
+		return fmt.Errorf("rendered nginx config failed validation, no load-balancers were updated: %s: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (driver *nginxDriver) Deploy(e *Executor, host string, path string) error {
+	return deployLoadBalancerConfig(e, host, path, nginxConfigPath, bashNginxValidateCommand)
+}
+
+func (driver *nginxDriver) Reload(e *Executor, host string) error {
+	return reloadLoadBalancer(e, host, driver.Service(), nginxConfigPath)
+}
+
+func (driver *nginxDriver) ActiveConfig(host string) (string, error) {
+	return readLoadBalancerConfig(host, nginxConfigPath)
+}
+
+func (driver *nginxDriver) Routes(config string) (LBRoutes, error) {
+	return parseNginxRoutes(config)
+}
+
+// parseNginxRoutes reads the servers of each upstream out of an nginx config.
+// Servers marked down aren't routes, e.g. the placeholder of an app without
+// any web dynos.
+func parseNginxRoutes(config string) (LBRoutes, error) {
+	var (
+		routes   = LBRoutes{}
+		upstream string
+	)
+	for _, line := range strings.Split(config, "\n") {
+		fields := strings.Fields(strings.Replace(line, ";", " ;", -1))
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "upstream" && len(fields) > 1 {
+			upstream = fields[1]
+			routes[upstream] = []LBAppDyno{}
+			continue
+		}
+		if upstream == "" {
+			continue
+		}
+		if fields[0] == "}" {
+			upstream = ""
+			continue
+		}
+		if fields[0] != "server" || len(fields) < 2 {
+			continue
+		}
+		down := false
+		for _, param := range fields[2:] {
+			if param == "down" {
+				down = true
+			}
+		}
+		if down {
+			continue
+		}
+		host, portStr, err := net.SplitHostPort(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("parsing server address of %q: %s", strings.TrimSpace(line), err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing server port of %q: %s", strings.TrimSpace(line), err)
+		}
+		routes[upstream] = append(routes[upstream], LBAppDyno{Host: host, Port: port})
+	}
+	return routes, nil
+}
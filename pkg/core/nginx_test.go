@@ -0,0 +1,63 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/jaytaylor/shipbuilder/pkg/scripts"
+)
+
+func TestNginxDriverRoutes(t *testing.T) {
+	NGINX_CONFIG = template.Must(template.New("NGINX_CONFIG").Parse(scripts.NginxSrc))
+
+	lbSpec := &LBSpec{
+		LogServerIpAndPort: "10.0.0.100:9998",
+		Applications: []*LBApp{
+			{
+				Name:          "myapp",
+				Domains:       []string{"myapp.example.com", "www.myapp.example.com"},
+				Servers:       []*LBAppDyno{{Host: "10.0.0.1", Port: 10001}, {Host: "10.0.0.2", Port: 10002}},
+				SSL:           true,
+				SSLForwarding: true,
+			},
+			{
+				Name:                    "otherapp",
+				Domains:                 []string{"otherapp.example.com"},
+				Servers:                 []*LBAppDyno{},
+				Maintenance:             true,
+				MaintenancePageFullPath: "/maintenance/index.html",
+				MaintenancePageBasePath: "/maintenance",
+				MaintenancePageDomain:   "pages.example.com",
+			},
+		},
+	}
+	driver := &nginxDriver{}
+	config, err := driver.Render(lbSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"server_name myapp.example.com www.myapp.example.com;",
+		"return 301 https://$host$request_uri;",
+		"proxy_pass http://myapp;",
+		"server 127.0.0.1:1 down;",
+		"error_page 503 @maintenance;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected rendered config to contain %q:\n%v", expected, config)
+		}
+	}
+	if strings.Count(config, "{") != strings.Count(config, "}") {
+		t.Errorf("Unbalanced braces in rendered config:\n%v", config)
+	}
+
+	routes, err := driver.Routes(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := lbSpec.Routes(); !reflect.DeepEqual(routes, expected) {
+		t.Errorf("Expected routes=%v but actual=%v", expected, routes)
+	}
+}
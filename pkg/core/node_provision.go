@@ -0,0 +1,183 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+const (
+	NodeCheckPass = "pass"
+	NodeCheckFail = "FAIL"
+	NodeCheckSkip = "skip"
+)
+
+// NodeCheck is the outcome of one node provisioning step.
+type NodeCheck struct {
+	Name   string
+	Result string // One of NodeCheckPass, NodeCheckFail or NodeCheckSkip.
+	Detail string
+}
+
+// nodeProvisionStep bootstraps or verifies one aspect of a node.  A non-empty
+// skip reason indicates the step didn't apply.
+type nodeProvisionStep struct {
+	name string
+	fn   func(e *Executor, host string) (skip string, err error)
+}
+
+// baseImagesLock keeps the base images from being re-published while nodes are
+// copying them.
+var baseImagesLock sync.RWMutex
+
+// nodeProvisionSteps returns the steps which bootstrap and verify a node, in
+// order.  The published base images are copied to the node.
+func (server *Server) nodeProvisionSteps(images []string) []nodeProvisionStep {
+	return []nodeProvisionStep{
+		{"ssh", func(e *Executor, host string) (string, error) {
+			if err := e.Run("ssh", DEFAULT_NODE_USERNAME+"@"+host, "true"); err != nil {
+				return "", fmt.Errorf("ssh as %v: %s", DEFAULT_NODE_USERNAME, err)
+			}
+			if err := e.Run("ssh", "root@"+host, "true"); err != nil {
+				return "", fmt.Errorf("ssh as root: %s", err)
+			}
+			return "", nil
+		}},
+		{"lxd", func(e *Executor, host string) (string, error) {
+			return "", e.Run("ssh", "root@"+host, LXC_BIN, "info")
+		}},
+		{"storage", func(e *Executor, host string) (string, error) {
+			if DefaultLXCFS == "zfs" {
+				if err := e.Run("ssh", "root@"+host, "/bin/bash", "-c", fmt.Sprintf(`%vzpool list -H -o health %v | grep -q ONLINE && zfs list -H -o name %v`, bashSafeEnvSetup, DefaultZFSPool, ZFS_CONTAINER_MOUNT)); err != nil {
+					return "", fmt.Errorf("zfs pool %q or dataset %q unavailable: %s", DefaultZFSPool, ZFS_CONTAINER_MOUNT, err)
+				}
+			}
+			if err := e.Run("ssh", "root@"+host, "/bin/bash", "-c", fmt.Sprintf(`%vtest -n "$(%v storage list --format csv)"`, bashSafeEnvSetup, LXC_BIN)); err != nil {
+				return "", fmt.Errorf("no lxd storage pool configured: %s", err)
+			}
+			return "", nil
+		}},
+		{"remote", func(e *Executor, host string) (string, error) {
+			// NB: The leading ":" below is a no-op to prevent extraneous useless bash
+			// output.
+			bashCmds := fmt.Sprintf(`:
+set -o errexit
+set -o pipefail
+test -n "$(%[1]v remote list | sed '1,3d' | grep -v '^+' | awk '{print $2}' | grep %[2]v)" || %[1]v remote add --accept-certificate --public %[2]v https://%[2]v:8443`,
+				LXC_BIN,
+				DefaultSSHHost,
+			)
+			return "", e.Run("ssh", "root@"+host, "/bin/bash", "-c", bashCmds)
+		}},
+		{"images", func(e *Executor, host string) (string, error) {
+			if len(images) == 0 {
+				return "no base containers found", nil
+			}
+			baseImagesLock.RLock()
+			defer baseImagesLock.RUnlock()
+			for _, image := range images {
+				if err := e.Run("ssh", "root@"+host, LXC_BIN, "image", "copy", "--copy-aliases", DefaultSSHHost+":"+image, "local:"); err != nil {
+					return "", fmt.Errorf("copying image %v: %s", image, err)
+				}
+				if err := e.Run("ssh", "root@"+host, LXC_BIN, "image", "info", image); err != nil {
+					return "", fmt.Errorf("image %v missing after copy: %s", image, err)
+				}
+			}
+			return "", nil
+		}},
+		{"scripts", func(e *Executor, host string) (string, error) {
+			return "", e.SyncContainerScripts("root@" + host + ":/tmp/")
+		}},
+	}
+}
+
+// publishBaseImages publishes the base-<buildpack> containers on the build box
+// as images so nodes can copy them, returning the names of the images.
+func (server *Server) publishBaseImages(e *Executor) ([]string, error) {
+	baseImagesLock.Lock()
+	defer baseImagesLock.Unlock()
+	images := []string{}
+	for _, buildpack := range server.BuildpacksProvider.All() {
+		image := "base-" + buildpack.Name()
+		exists, err := e.ContainerExists(image)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		if err := publishBaseImage(e, image); err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// publishBaseImage publishes a base container on the build box as a public
+// image of the same name, replacing any previously published one.
+func publishBaseImage(e *Executor, name string) error {
+	exists, err := e.ImageExists(name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := e.Run(LXC_BIN, "image", "delete", name); err != nil {
+			return fmt.Errorf("removing previously published image %v: %s", name, err)
+		}
+	}
+	if err := e.Run(LXC_BIN, "publish", "--force", "--public", name, "--alias", name); err != nil {
+		return fmt.Errorf("publishing image %v: %s", name, err)
+	}
+	return nil
+}
+
+// provisionNode runs the provisioning steps against a node.
+func (server *Server) provisionNode(host string, images []string, logger io.Writer) ([]NodeCheck, error) {
+	return runNodeProvisionSteps(server.nodeProvisionSteps(images), host, logger)
+}
+
+// runNodeProvisionSteps runs provisioning steps against a node.  Once a step
+// fails the remaining ones are skipped.
+func runNodeProvisionSteps(steps []nodeProvisionStep, host string, logger io.Writer) ([]NodeCheck, error) {
+	var (
+		e      = &Executor{Logger: logger}
+		checks = []NodeCheck{}
+		failed error
+	)
+	for _, step := range steps {
+		if failed != nil {
+			checks = append(checks, NodeCheck{Name: step.name, Result: NodeCheckSkip, Detail: "previous check failed"})
+			continue
+		}
+		fmt.Fprintf(logger, "Checking %v\n", step.name)
+		skip, err := step.fn(e, host)
+		switch {
+		case err != nil:
+			failed = fmt.Errorf("%v check failed: %s", step.name, err)
+			checks = append(checks, NodeCheck{Name: step.name, Result: NodeCheckFail, Detail: err.Error()})
+		case len(skip) > 0:
+			checks = append(checks, NodeCheck{Name: step.name, Result: NodeCheckSkip, Detail: skip})
+		default:
+			checks = append(checks, NodeCheck{Name: step.name, Result: NodeCheckPass})
+		}
+	}
+	return checks, failed
+}
+
+// nodeChecksTable renders node checks as an aligned table.
+func nodeChecksTable(host string, checks []NodeCheck) string {
+	var (
+		buf = &bytes.Buffer{}
+		w   = tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+	)
+	fmt.Fprintf(w, "NODE\tCHECK\tRESULT\tDETAIL\n")
+	for _, check := range checks {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", host, check.Name, check.Result, strings.Replace(check.Detail, "\n", " ", -1))
+	}
+	w.Flush()
+	return buf.String()
+}
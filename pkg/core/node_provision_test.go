@@ -0,0 +1,66 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestRunNodeProvisionSteps(t *testing.T) {
+	var (
+		hosts []string
+		steps = []nodeProvisionStep{
+			{"ssh", func(e *Executor, host string) (string, error) { hosts = append(hosts, host); return "", nil }},
+			{"storage", func(e *Executor, host string) (string, error) {
+				hosts = append(hosts, host)
+				return "zfs not in use", nil
+			}},
+			{"remote", func(e *Executor, host string) (string, error) {
+				hosts = append(hosts, host)
+				return "", errors.New("connection refused\nexit status 1")
+			}},
+			{"scripts", func(e *Executor, host string) (string, error) { hosts = append(hosts, host); return "", nil }},
+		}
+	)
+
+	checks, err := runNodeProvisionSteps(steps, "node-a", ioutil.Discard)
+	if err == nil || err.Error() != "remote check failed: connection refused\nexit status 1" {
+		t.Errorf("Expected remote check failure but actual err=%v", err)
+	}
+	if expected := []string{"node-a", "node-a", "node-a"}; !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("Expected steps to run against hosts=%v but actual=%v", expected, hosts)
+	}
+	expected := []NodeCheck{
+		{Name: "ssh", Result: NodeCheckPass},
+		{Name: "storage", Result: NodeCheckSkip, Detail: "zfs not in use"},
+		{Name: "remote", Result: NodeCheckFail, Detail: "connection refused\nexit status 1"},
+		{Name: "scripts", Result: NodeCheckSkip, Detail: "previous check failed"},
+	}
+	if !reflect.DeepEqual(checks, expected) {
+		t.Errorf("Expected checks=%+v but actual=%+v", expected, checks)
+	}
+
+	expectedTable := `NODE    CHECK    RESULT  DETAIL
+node-a  ssh      pass    
+node-a  storage  skip    zfs not in use
+node-a  remote   FAIL    connection refused exit status 1
+node-a  scripts  skip    previous check failed
+`
+	if actual := nodeChecksTable("node-a", checks); actual != expectedTable {
+		t.Errorf("Expected table:\n%v\nbut actual:\n%v", expectedTable, actual)
+	}
+}
+
+func TestNodeProvisionImagesStepWithoutBaseImages(t *testing.T) {
+	for _, step := range (&Server{}).nodeProvisionSteps(nil) {
+		if step.name != "images" {
+			continue
+		}
+		if skip, err := step.fn(nil, "node-a"); err != nil || skip != "no base containers found" {
+			t.Errorf("Expected the images step to be skipped but actual skip=%q err=%v", skip, err)
+		}
+		return
+	}
+	t.Errorf("Expected an images step")
+}
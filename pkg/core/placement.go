@@ -0,0 +1,324 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	PlacementMemory  = "memory"  // Prefer nodes already running the version, then those with the most free memory, round-robin.
+	PlacementSpread  = "spread"  // Spread each process type of an app across as many nodes as possible.
+	PlacementBinpack = "binpack" // Fill up the fullest node which still has room before using others.
+
+	DefaultPlacement = PlacementMemory
+
+	// placementDynoMemoryMb is the memory reserved per dyno when estimating how
+	// much room remains on a node.
+	placementDynoMemoryMb = 256
+
+	ConstraintRequires = "requires" // Only run dynos on nodes with the label.
+	ConstraintAvoids   = "avoids"   // Never run dynos on nodes with the label.
+)
+
+// PlacementNode is a node which dynos may be placed on, along with the
+// placement decisions made so far.
+type PlacementNode struct {
+	Host         string
+	FreeMemoryMb int            // Remaining free memory, less the memory reserved for dynos placed so far.
+	Running      bool           // Whether the app version being placed is already running on the node.
+	Dynos        map[string]int // Number of the app's dynos per process type, including those placed so far.
+	Labels       map[string]string
+}
+
+// NumDynos returns the total number of the app's dynos on the node.
+func (node *PlacementNode) NumDynos() int {
+	n := 0
+	for _, count := range node.Dynos {
+		n += count
+	}
+	return n
+}
+
+// PlacementStrategy decides which node the next dyno of a process type runs
+// on.
+type PlacementStrategy interface {
+	// Name identifier of the strategy.
+	Name() string
+
+	// Next returns the index of the node to run the next dyno on.  Nodes are
+	// sorted by the memory strategy ordering.
+	Next(nodes []*PlacementNode, process string, memoryMb int) int
+}
+
+// NewPlacementStrategy constructs the named strategy.  An empty name selects
+// the default.
+func NewPlacementStrategy(name string) (PlacementStrategy, error) {
+	switch name {
+	case PlacementMemory, "":
+		return &memoryPlacement{}, nil
+	case PlacementSpread:
+		return &spreadPlacement{}, nil
+	case PlacementBinpack:
+		return &binpackPlacement{}, nil
+	}
+	return nil, fmt.Errorf("unrecognized placement strategy %q, must be one of: %v", name, strings.Join(PlacementStrategies(), ", "))
+}
+
+// PlacementStrategies returns the names of all available strategies.
+func PlacementStrategies() []string {
+	return []string{PlacementMemory, PlacementSpread, PlacementBinpack}
+}
+
+// memoryPlacement round-robins over the nodes in their sorted order.
+type memoryPlacement struct {
+	position int
+}
+
+func (s *memoryPlacement) Name() string { return PlacementMemory }
+
+func (s *memoryPlacement) Next(nodes []*PlacementNode, process string, memoryMb int) int {
+	i := s.position % len(nodes)
+	s.position++
+	return i
+}
+
+// spreadPlacement picks the node with the fewest dynos of the process type,
+// then the fewest dynos of the app overall, then the most free memory.
+type spreadPlacement struct{}
+
+func (s *spreadPlacement) Name() string { return PlacementSpread }
+
+func (s *spreadPlacement) Next(nodes []*PlacementNode, process string, memoryMb int) int {
+	best := 0
+	for i := 1; i < len(nodes); i++ {
+		if spreadLess(nodes[i], nodes[best], process) {
+			best = i
+		}
+	}
+	return best
+}
+
+func spreadLess(a *PlacementNode, b *PlacementNode, process string) bool {
+	if a.Dynos[process] != b.Dynos[process] {
+		return a.Dynos[process] < b.Dynos[process]
+	}
+	if a.NumDynos() != b.NumDynos() {
+		return a.NumDynos() < b.NumDynos()
+	}
+	return a.FreeMemoryMb > b.FreeMemoryMb
+}
+
+// binpackPlacement picks the node with the least free memory which still has
+// room for the dyno.  When none have room, the node with the most free memory
+// is used.
+type binpackPlacement struct{}
+
+func (s *binpackPlacement) Name() string { return PlacementBinpack }
+
+func (s *binpackPlacement) Next(nodes []*PlacementNode, process string, memoryMb int) int {
+	var (
+		best     = -1
+		fallback = 0
+	)
+	for i, node := range nodes {
+		if node.FreeMemoryMb > nodes[fallback].FreeMemoryMb {
+			fallback = i
+		}
+		if node.FreeMemoryMb < memoryMb {
+			continue
+		}
+		if best == -1 || node.FreeMemoryMb < nodes[best].FreeMemoryMb {
+			best = i
+		}
+	}
+	if best == -1 {
+		return fallback
+	}
+	return best
+}
+
+// placementNodesWithCapacity returns the nodes which have enough free memory
+// for a dyno.
+func placementNodesWithCapacity(nodes []*PlacementNode, memoryMb int) []*PlacementNode {
+	candidates := []*PlacementNode{}
+	for _, node := range nodes {
+		if node.FreeMemoryMb >= memoryMb {
+			candidates = append(candidates, node)
+		}
+	}
+	return candidates
+}
+
+// PlacementConstraint restricts which nodes an app's dynos may run on based on
+// node labels, e.g. "worker requires disk=ssd" or "web avoids zone=a".  When
+// the value is empty, any node with the label key matches.
+type PlacementConstraint struct {
+	Process string `json:",omitempty"` // Normalized process type, or empty for all of the app's processes.
+	Op      string
+	Key     string
+	Value   string `json:",omitempty"`
+}
+
+// ParsePlacementConstraint parses an operator and key=value (or bare key)
+// label expression into a constraint for a process type.
+func ParsePlacementConstraint(process string, op string, expr string) (PlacementConstraint, error) {
+	op = strings.ToLower(strings.TrimSpace(op))
+	if op != ConstraintRequires && op != ConstraintAvoids {
+		return PlacementConstraint{}, fmt.Errorf("unrecognized constraint operator %q, must be one of: %v, %v", op, ConstraintRequires, ConstraintAvoids)
+	}
+	pieces := strings.SplitN(strings.TrimSpace(expr), "=", 2)
+	constraint := PlacementConstraint{
+		Process: normalizeAppProcessName(strings.TrimSpace(process)),
+		Op:      op,
+		Key:     pieces[0],
+	}
+	if len(pieces) == 2 {
+		constraint.Value = pieces[1]
+	}
+	if err := validateNodeLabelKey(constraint.Key); err != nil {
+		return PlacementConstraint{}, err
+	}
+	return constraint, nil
+}
+
+// AppliesTo returns true when the constraint applies to dynos of the process
+// type.
+func (constraint PlacementConstraint) AppliesTo(process string) bool {
+	return len(constraint.Process) == 0 || constraint.Process == normalizeAppProcessName(process)
+}
+
+// Allows returns true when dynos subject to the constraint may run on a node
+// with the given labels.
+func (constraint PlacementConstraint) Allows(labels map[string]string) bool {
+	value, ok := labels[constraint.Key]
+	matches := ok && (len(constraint.Value) == 0 || value == constraint.Value)
+	if constraint.Op == ConstraintAvoids {
+		return !matches
+	}
+	return matches
+}
+
+func (constraint PlacementConstraint) String() string {
+	s := constraint.Op + " " + constraint.Key
+	if len(constraint.Value) > 0 {
+		s += "=" + constraint.Value
+	}
+	if len(constraint.Process) > 0 {
+		s = constraint.Process + " " + s
+	}
+	return s
+}
+
+// placementConstraintsFor returns the constraints which apply to a process
+// type.
+func placementConstraintsFor(constraints []PlacementConstraint, process string) []PlacementConstraint {
+	applicable := []PlacementConstraint{}
+	for _, constraint := range constraints {
+		if constraint.AppliesTo(process) {
+			applicable = append(applicable, constraint)
+		}
+	}
+	return applicable
+}
+
+// nodeSatisfies returns true when a node's labels satisfy all the constraints.
+func nodeSatisfies(labels map[string]string, constraints []PlacementConstraint) bool {
+	for _, constraint := range constraints {
+		if !constraint.Allows(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// eligibleNodes returns the nodes which dynos of the process type may run on.
+// An error describing the constraints is returned when there are none.
+func eligibleNodes(nodes []*Node, constraints []PlacementConstraint, process string) ([]*Node, error) {
+	var (
+		applicable = placementConstraintsFor(constraints, process)
+		eligible   = []*Node{}
+	)
+	for _, node := range nodes {
+		if nodeSatisfies(node.Labels, applicable) {
+			eligible = append(eligible, node)
+		}
+	}
+	if len(eligible) == 0 && len(nodes) > 0 {
+		return nil, fmt.Errorf("no node satisfies the placement constraints for process type %q: %v", process, placementConstraintsString(applicable))
+	}
+	return eligible, nil
+}
+
+// schedulableNodes filters out cordoned nodes.
+func schedulableNodes(nodes []*Node) []*Node {
+	schedulable := []*Node{}
+	for _, node := range nodes {
+		if !node.Cordoned {
+			schedulable = append(schedulable, node)
+		}
+	}
+	return schedulable
+}
+
+func placementConstraintsString(constraints []PlacementConstraint) string {
+	strs := make([]string, len(constraints))
+	for i, constraint := range constraints {
+		strs[i] = constraint.String()
+	}
+	return strings.Join(strs, ", ")
+}
+
+// validateNodeLabelKey requires label keys be non-empty and free of
+// whitespace and '='.
+func validateNodeLabelKey(key string) error {
+	if len(key) == 0 || strings.ContainsAny(key, "= \t\n") {
+		return fmt.Errorf("invalid label key %q", key)
+	}
+	return nil
+}
+
+// placementNodesSatisfying returns the nodes whose labels satisfy all the
+// constraints.
+func placementNodesSatisfying(nodes []*PlacementNode, constraints []PlacementConstraint) []*PlacementNode {
+	candidates := []*PlacementNode{}
+	for _, node := range nodes {
+		if nodeSatisfies(node.Labels, constraints) {
+			candidates = append(candidates, node)
+		}
+	}
+	return candidates
+}
+
+// newPlacementNodes converts node statuses into placement nodes for an app
+// version, sorted by the memory strategy ordering.
+func newPlacementNodes(statuses []NodeStatus, application string, version string) []*PlacementNode {
+	var (
+		allStatuses = []NodeStatusRunning{}
+		dynos       = map[string]map[string]int{}
+	)
+	for _, status := range statuses {
+		// Only dynos of the same version remain after the deploy completes.
+		dynos[status.Host] = map[string]int{}
+		for _, container := range status.Containers {
+			dyno, err := status.Dyno(container)
+			if err == nil && dyno.State == DYNO_STATE_RUNNING && dyno.Application == application && dyno.Version == version {
+				dynos[status.Host][normalizeAppProcessName(dyno.Process)]++
+			}
+		}
+		allStatuses = append(allStatuses, NodeStatusRunning{status, len(dynos[status.Host]) > 0})
+	}
+
+	sort.Stable(NodeStatuses(allStatuses))
+
+	nodes := make([]*PlacementNode, len(allStatuses))
+	for i, s := range allStatuses {
+		nodes[i] = &PlacementNode{
+			Host:         s.status.Host,
+			FreeMemoryMb: s.status.FreeMemoryMb,
+			Running:      s.running,
+			Dynos:        dynos[s.status.Host],
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlacementStrategies(t *testing.T) {
+	// node-a is the roomiest, node-c already runs two web dynos of the version
+	// being placed.
+	statuses := []NodeStatus{
+		{Host: "node-a", FreeMemoryMb: 4096},
+		{Host: "node-b", FreeMemoryMb: 1024, Containers: []string{"other-v3-web-10001-running"}},
+		{Host: "node-c", FreeMemoryMb: 2048, Containers: []string{"myapp-v7-web-10002-running", "myapp-v7-web-10003-running", "myapp-v6-worker-10004-stopped"}},
+	}
+
+	testCases := []struct {
+		strategy  string
+		processes []string
+		expected  []string
+	}{
+		{
+			// Nodes already running the version first, then by free memory.
+			strategy:  PlacementMemory,
+			processes: []string{"web", "web", "web", "web"},
+			expected:  []string{"node-c", "node-a", "node-b", "node-c"},
+		},
+		{
+			strategy:  "",
+			processes: []string{"web", "web"},
+			expected:  []string{"node-c", "node-a"},
+		},
+		{
+			// Every node gets a web dyno before any gets a second, and worker dynos
+			// avoid the nodes with the most app dynos.
+			strategy:  PlacementSpread,
+			processes: []string{"web", "web", "web", "worker", "worker"},
+			expected:  []string{"node-a", "node-b", "node-a", "node-b", "node-a"},
+		},
+		{
+			// Fill the fullest node first until it is out of room.
+			strategy:  PlacementBinpack,
+			processes: []string{"web", "web", "web", "web", "web", "web", "web"},
+			expected:  []string{"node-b", "node-b", "node-b", "node-b", "node-c", "node-c", "node-c"},
+		},
+	}
+
+	for i, testCase := range testCases {
+		strategy, err := NewPlacementStrategy(testCase.strategy)
+		if err != nil {
+			t.Fatalf("[i=%v] %s", i, err)
+		}
+		var (
+			nodes  = newPlacementNodes(statuses, "myapp", "v7")
+			actual = []string{}
+		)
+		for _, process := range testCase.processes {
+			node := nodes[strategy.Next(nodes, process, placementDynoMemoryMb)]
+			node.FreeMemoryMb -= placementDynoMemoryMb
+			node.Dynos[process]++
+			actual = append(actual, node.Host)
+		}
+		if !reflect.DeepEqual(actual, testCase.expected) {
+			t.Errorf("[i=%v] Expected %v placements=%v but actual=%v", i, testCase.strategy, testCase.expected, actual)
+		}
+	}
+}
+
+func TestPlacementBinpackFull(t *testing.T) {
+	nodes := newPlacementNodes([]NodeStatus{
+		{Host: "node-a", FreeMemoryMb: 100},
+		{Host: "node-b", FreeMemoryMb: 200},
+	}, "myapp", "v1")
+
+	// When no node has room, fall back to the one with the most free memory.
+	if i := (&binpackPlacement{}).Next(nodes, "web", placementDynoMemoryMb); nodes[i].Host != "node-b" {
+		t.Errorf("Expected binpack fallback to node-b but actual=%v", nodes[i].Host)
+	}
+}
+
+func TestNewPlacementStrategyUnknown(t *testing.T) {
+	if _, err := NewPlacementStrategy("random"); err == nil {
+		t.Errorf("Expected error for unknown placement strategy")
+	}
+}
+
+func TestPlacementConstraints(t *testing.T) {
+	nodes := []*Node{
+		{Host: "node-a", Labels: map[string]string{"disk": "ssd", "zone": "a"}},
+		{Host: "node-b", Labels: map[string]string{"disk": "hdd", "zone": "b"}},
+		{Host: "node-c"},
+	}
+
+	constraints, err := parseConstraintsArgs([]string{"background_worker", "requires"}, map[string]string{"disk": "ssd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	avoids, err := parseConstraintsArgs([]string{"avoids", "zone"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	constraints = append(constraints, avoids...)
+
+	testCases := []struct {
+		process  string
+		expected []string
+		err      bool
+	}{
+		{process: "web", expected: []string{"node-c"}},
+		{process: "backgroundWorker", err: true},
+		{process: "cron", expected: []string{"node-c"}},
+	}
+	for i, testCase := range testCases {
+		eligible, err := eligibleNodes(nodes, constraints, testCase.process)
+		if testCase.err {
+			if err == nil {
+				t.Errorf("[i=%v] Expected error for process=%v but got eligible=%v", i, testCase.process, eligible)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[i=%v] Unexpected error: %s", i, err)
+			continue
+		}
+		actual := []string{}
+		for _, node := range eligible {
+			actual = append(actual, node.Host)
+		}
+		if !reflect.DeepEqual(actual, testCase.expected) {
+			t.Errorf("[i=%v] Expected eligible=%v but actual=%v", i, testCase.expected, actual)
+		}
+	}
+
+	// Without the app-wide constraint, only node-a has an SSD.
+	eligible, err := eligibleNodes(nodes, constraints[:1], "background-worker")
+	if err != nil || len(eligible) != 1 || eligible[0].Host != "node-a" {
+		t.Errorf("Expected only node-a to be eligible but actual=%v err=%v", eligible, err)
+	}
+
+	if _, err := parseConstraintsArgs([]string{"web", "prefers"}, map[string]string{"disk": "ssd"}); err == nil {
+		t.Errorf("Expected error for unrecognized constraint operator")
+	}
+}
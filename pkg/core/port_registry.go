@@ -0,0 +1,242 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// portAllocationGracePeriod is how long an allocation which isn't backed by a
+// running dyno is retained, e.g. while the dyno is starting up.
+const portAllocationGracePeriod = 10 * time.Minute
+
+var portRegistry = NewPortRegistry(PORTS_FILE, MinDynoPort, MaxDynoPort)
+
+// PortAllocation is a dyno port in use, or about to be, on a node.
+type PortAllocation struct {
+	Port      int
+	Container string    // Dyno container name, i.e. app-version-process-port.
+	Ts        time.Time // When the port was allocated or last seen in use by a running dyno.
+}
+
+type portRegistryState struct {
+	Allocations map[string][]PortAllocation // Per node host, sorted by port.
+	Next        map[string]int              // Per node host, where the search for the next free port begins.
+}
+
+// PortRegistry tracks dyno port allocations per node, persisted to disk so
+// allocations survive server restarts.  The registry is reconciled against the
+// containers actually running on each node by the status monitor, and ports
+// are released explicitly when dynos are shut down.
+type PortRegistry struct {
+	path string
+	min  int
+	max  int
+	lock sync.Mutex
+}
+
+// NewPortRegistry creates a registry persisted at path which allocates ports
+// in the range [min, max].
+func NewPortRegistry(path string, min int, max int) *PortRegistry {
+	return &PortRegistry{
+		path: path,
+		min:  min,
+		max:  max,
+	}
+}
+
+// Allocate reserves a free port on a node for a dyno container, where
+// container is the app-version-process portion of the name.  Ports in inUse
+// are avoided in addition to those already allocated.
+func (pr *PortRegistry) Allocate(host string, container string, inUse []int) (int, error) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	state, err := pr.load()
+	if err != nil {
+		return 0, err
+	}
+
+	taken := map[int]bool{}
+	for _, port := range inUse {
+		taken[port] = true
+	}
+	for _, allocation := range state.Allocations[host] {
+		taken[allocation.Port] = true
+	}
+
+	start := state.Next[host]
+	if start < pr.min || start > pr.max {
+		start = pr.min
+	}
+	port := start
+	for taken[port] {
+		if port++; port > pr.max {
+			port = pr.min
+		}
+		if port == start {
+			return 0, fmt.Errorf("no free ports remain in the range %v-%v on node %v", pr.min, pr.max, host)
+		}
+	}
+
+	state.Allocations[host] = append(state.Allocations[host], PortAllocation{
+		Port:      port,
+		Container: container + DYNO_DELIMITER + strconv.Itoa(port),
+		Ts:        time.Now(),
+	})
+	sortPortAllocations(state.Allocations[host])
+	// NB: Continuing on from the allocated port avoids immediately re-using
+	// recently released ports.
+	state.Next[host] = port + 1
+	if err := pr.save(state); err != nil {
+		return 0, err
+	}
+	log.Infof("PortRegistry.Allocate :: host=%v port=%v container=%v", host, port, container)
+	return port, nil
+}
+
+// Release frees a port on a node.
+func (pr *PortRegistry) Release(host string, port int) error {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	state, err := pr.load()
+	if err != nil {
+		return err
+	}
+	allocations := []PortAllocation{}
+	for _, allocation := range state.Allocations[host] {
+		if allocation.Port != port {
+			allocations = append(allocations, allocation)
+		}
+	}
+	if len(allocations) == len(state.Allocations[host]) {
+		return nil
+	}
+	state.Allocations[host] = allocations
+	log.Infof("PortRegistry.Release :: host=%v port=%v", host, port)
+	return pr.save(state)
+}
+
+// Reconcile brings the allocations for a node in line with the containers
+// running on it: ports of running dynos are registered, and allocations which
+// haven't been backed by a running dyno within the grace period are released.
+func (pr *PortRegistry) Reconcile(nodeStatus *NodeStatus, now time.Time) error {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	state, err := pr.load()
+	if err != nil {
+		return err
+	}
+
+	var (
+		host    = nodeStatus.Host
+		running = map[int]string{}
+	)
+	for _, container := range nodeStatus.Containers {
+		dyno, err := nodeStatus.Dyno(container)
+		if err == nil && dyno.State == DYNO_STATE_RUNNING && dyno.PortNumber > 0 {
+			running[dyno.PortNumber] = dyno.Container
+		}
+	}
+
+	allocations := []PortAllocation{}
+	for _, allocation := range state.Allocations[host] {
+		if container, ok := running[allocation.Port]; ok {
+			allocation.Container = container
+			allocation.Ts = now
+			delete(running, allocation.Port)
+		} else if now.Sub(allocation.Ts) >= portAllocationGracePeriod {
+			log.Infof("PortRegistry.Reconcile :: releasing host=%v port=%v, no running dyno since %v", host, allocation.Port, allocation.Ts)
+			continue
+		}
+		allocations = append(allocations, allocation)
+	}
+	for port, container := range running {
+		allocations = append(allocations, PortAllocation{Port: port, Container: container, Ts: now})
+	}
+	sortPortAllocations(allocations)
+	state.Allocations[host] = allocations
+	return pr.save(state)
+}
+
+// List returns the port allocations for a node, sorted by port.
+func (pr *PortRegistry) List(host string) ([]PortAllocation, error) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	state, err := pr.load()
+	if err != nil {
+		return nil, err
+	}
+	return state.Allocations[host], nil
+}
+
+func (pr *PortRegistry) load() (*portRegistryState, error) {
+	state := &portRegistryState{}
+	data, err := ioutil.ReadFile(pr.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading port registry %q: %s", pr.path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("decoding port registry %q: %s", pr.path, err)
+		}
+	}
+	if state.Allocations == nil {
+		state.Allocations = map[string][]PortAllocation{}
+	}
+	if state.Next == nil {
+		state.Next = map[string]int{}
+	}
+	return state, nil
+}
+
+func (pr *PortRegistry) save(state *portRegistryState) error {
+	data, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(pr.path), os.FileMode(int(0700))); err != nil {
+		return fmt.Errorf("creating port registry directory: %s", err)
+	}
+	// Write to a temporary file and rename it into place so the registry is
+	// never left half-written.
+	tmp := pr.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, os.FileMode(int(0600))); err != nil {
+		return fmt.Errorf("writing port registry %q: %s", tmp, err)
+	}
+	if err := os.Rename(tmp, pr.path); err != nil {
+		return fmt.Errorf("replacing port registry %q: %s", pr.path, err)
+	}
+	return nil
+}
+
+func sortPortAllocations(allocations []PortAllocation) {
+	sort.Slice(allocations, func(i, j int) bool { return allocations[i].Port < allocations[j].Port })
+}
+
+// runningDynoPorts returns the ports of the dynos running on a node.
+func runningDynoPorts(nodeStatus *NodeStatus) []int {
+	ports := []int{}
+	for _, container := range nodeStatus.Containers {
+		dyno, err := nodeStatus.Dyno(container)
+		if err != nil {
+			log.Warnf("runningDynoPorts :: Failed to create Dyno from container %q: %v", container, err)
+			continue
+		}
+		if dyno.State == DYNO_STATE_RUNNING && dyno.PortNumber > 0 {
+			ports = append(ports, dyno.PortNumber)
+		}
+	}
+	return ports
+}
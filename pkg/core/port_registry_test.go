@@ -0,0 +1,68 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPortRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shipbuilder-ports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pr := NewPortRegistry(filepath.Join(dir, "ports.json"), 10000, 10004)
+
+	// Ports used by running dynos are skipped, and allocations persist.
+	for i, expected := range []int{10001, 10003} {
+		port, err := pr.Allocate("node-a", "myapp-v3-web", []int{10000, 10002})
+		if err != nil {
+			t.Fatalf("[i=%v] %s", i, err)
+		}
+		if port != expected {
+			t.Errorf("[i=%v] Expected port=%v but actual=%v", i, expected, port)
+		}
+	}
+	pr = NewPortRegistry(pr.path, pr.min, pr.max)
+	if allocations, _ := pr.List("node-a"); len(allocations) != 2 || allocations[0].Container != "myapp-v3-web-10001" {
+		t.Errorf("Expected persisted allocations for ports 10001 and 10003 but actual=%+v", allocations)
+	}
+
+	// Released ports aren't immediately re-used.
+	if err := pr.Release("node-a", 10001); err != nil {
+		t.Fatal(err)
+	}
+	if port, err := pr.Allocate("node-a", "myapp-v3-web", []int{10000, 10002}); err != nil || port != 10004 {
+		t.Errorf("Expected port=10004 but actual=%v (err=%v)", port, err)
+	}
+	if port, err := pr.Allocate("node-a", "myapp-v3-web", []int{10000, 10002}); err != nil || port != 10001 {
+		t.Errorf("Expected port=10001 after wrapping around but actual=%v (err=%v)", port, err)
+	}
+	if _, err := pr.Allocate("node-a", "myapp-v3-web", []int{10000, 10002}); err == nil {
+		t.Errorf("Expected error when no ports remain")
+	}
+
+	// Running dynos are registered, recent allocations are kept and stale ones
+	// are released.
+	var (
+		now        = time.Now().Add(portAllocationGracePeriod / 2)
+		nodeStatus = &NodeStatus{Host: "node-a", Containers: []string{"myapp-v3-web-10001-running", "other-v1-worker-10002-running"}}
+	)
+	if err := pr.Reconcile(nodeStatus, now); err != nil {
+		t.Fatal(err)
+	}
+	if allocations, _ := pr.List("node-a"); len(allocations) != 4 {
+		t.Errorf("Expected 4 allocations within the grace period but actual=%+v", allocations)
+	}
+	if err := pr.Reconcile(nodeStatus, now.Add(portAllocationGracePeriod)); err != nil {
+		t.Fatal(err)
+	}
+	allocations, _ := pr.List("node-a")
+	if len(allocations) != 2 || allocations[0].Port != 10001 || allocations[1].Container != "other-v1-worker-10002" {
+		t.Errorf("Expected only the running dynos' allocations but actual=%+v", allocations)
+	}
+}
@@ -0,0 +1,271 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	reconcileMaxDynosPerAttempt = 5 // Most dynos started for an app per reconciliation attempt.
+	reconcileBaseBackoff        = 30 * time.Second
+	reconcileMaxBackoff         = 30 * time.Minute
+)
+
+// reconcileRequests carries node status snapshots from the status monitor to
+// the reconciler.  Snapshots are dropped while the reconciler is busy.
+var reconcileRequests = make(chan map[string]NodeStatus, 1)
+
+var reconcileTracker = ReconcileTracker{backoffs: map[string]ReconcileBackoff{}}
+
+// ReconcileBackoff is the reconciliation backoff state of an app.
+type ReconcileBackoff struct {
+	Attempts int       // Consecutive attempts made without the app becoming healthy.
+	Next     time.Time // Earliest time of the next attempt.
+}
+
+// ReconcileTracker rate-limits reconciliation attempts per app with
+// exponential backoff, until the app is observed to be healthy.
+type ReconcileTracker struct {
+	backoffs map[string]ReconcileBackoff
+	lock     sync.Mutex
+}
+
+// Ready returns true when an attempt may be made for the app.
+func (rt *ReconcileTracker) Ready(applicationName string, now time.Time) bool {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return !now.Before(rt.backoffs[applicationName].Next)
+}
+
+// Attempt records an attempt for the app and schedules the earliest next one.
+func (rt *ReconcileTracker) Attempt(applicationName string, now time.Time) ReconcileBackoff {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	backoff := rt.backoffs[applicationName]
+	backoff.Attempts++
+	delay := reconcileBaseBackoff
+	for i := 1; i < backoff.Attempts && delay < reconcileMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > reconcileMaxBackoff {
+		delay = reconcileMaxBackoff
+	}
+	backoff.Next = now.Add(delay)
+	rt.backoffs[applicationName] = backoff
+	return backoff
+}
+
+// Reset clears the backoff for an app once it is healthy.
+func (rt *ReconcileTracker) Reset(applicationName string) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	delete(rt.backoffs, applicationName)
+}
+
+// Get returns the current backoff state of an app.
+func (rt *ReconcileTracker) Get(applicationName string) (ReconcileBackoff, bool) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	backoff, ok := rt.backoffs[applicationName]
+	return backoff, ok
+}
+
+// requestReconcile hands a copy of the latest node statuses to the reconciler,
+// unless it is still busy with a previous snapshot.
+func requestReconcile(hostStatusMap map[string]NodeStatus) {
+	statuses := map[string]NodeStatus{}
+	for host, status := range hostStatusMap {
+		statuses[host] = status
+	}
+	select {
+	case reconcileRequests <- statuses:
+	default:
+	}
+}
+
+// reconcile starts replacements for missing dynos as node statuses arrive from
+// the status monitor.
+func (server *Server) reconcile() {
+	for statuses := range reconcileRequests {
+		if err := server.reconcileApps(statuses); err != nil {
+			log.Errorf("[reconcile] %s", err)
+		}
+	}
+}
+
+func (server *Server) reconcileApps(statuses map[string]NodeStatus) error {
+	// Only act on statuses gathered since the last deploy finished.
+	if len(statuses) == 0 {
+		return nil
+	}
+	for _, status := range statuses {
+		if !deployLock.validateLatest(status.DeployMarker) {
+			return nil
+		}
+	}
+
+	cfg, err := server.getConfig(true)
+	if err != nil {
+		return err
+	}
+	allocations, err := unreachableAllocations(statuses)
+	if err != nil {
+		return err
+	}
+	for _, app := range cfg.Applications {
+		if app.NoReconcile || len(app.LastDeploy) == 0 {
+			continue
+		}
+		missing := missingDynos(app, statuses, allocations)
+		if len(missing) == 0 {
+			reconcileTracker.Reset(app.Name)
+			continue
+		}
+		server.reconcileApp(app.Name, missing)
+	}
+	return nil
+}
+
+// missingDynos returns the number of dynos missing per process type for an app,
+// comparing the desired scale against the dynos running at the latest version
+// on reachable nodes.  Process types with dynos at the latest version on
+// unreachable nodes, according to their last known containers or port
+// allocations, are skipped since those dynos may well still be running.
+func missingDynos(app *Application, statuses map[string]NodeStatus, allocations map[string][]PortAllocation) map[string]int {
+	var (
+		running     = map[string]int{}
+		unreachable = map[string]bool{}
+		prefix      = app.Name + DYNO_DELIMITER + app.LastDeploy + DYNO_DELIMITER
+	)
+	for host, status := range statuses {
+		if status.Err != nil {
+			for _, container := range status.Containers {
+				if process, ok := containerProcess(container, prefix); ok {
+					unreachable[process] = true
+				}
+			}
+			for _, allocation := range allocations[host] {
+				if process, ok := containerProcess(allocation.Container, prefix); ok {
+					unreachable[process] = true
+				}
+			}
+			continue
+		}
+		for _, container := range status.Containers {
+			dyno, err := status.Dyno(container)
+			if err == nil && dyno.State == DYNO_STATE_RUNNING && dyno.Application == app.Name && dyno.Version == app.LastDeploy {
+				running[normalizeAppProcessName(dyno.Process)]++
+			}
+		}
+	}
+	missing := map[string]int{}
+	for process, numDynos := range app.Processes {
+		normalized := normalizeAppProcessName(process)
+		if unreachable[normalized] {
+			continue
+		}
+		if n := numDynos - running[normalized]; n > 0 {
+			missing[process] = n
+		}
+	}
+	return missing
+}
+
+// containerProcess extracts the process type from a dyno container name when
+// it begins with the app-version prefix.
+func containerProcess(container string, prefix string) (string, bool) {
+	if !strings.HasPrefix(container, prefix) {
+		return "", false
+	}
+	return strings.SplitN(strings.TrimPrefix(container, prefix), DYNO_DELIMITER, 2)[0], true
+}
+
+// unreachableAllocations returns the port allocations of unreachable nodes,
+// which reveal the dynos last placed on them.
+func unreachableAllocations(statuses map[string]NodeStatus) (map[string][]PortAllocation, error) {
+	allocations := map[string][]PortAllocation{}
+	for host, status := range statuses {
+		if status.Err == nil {
+			continue
+		}
+		hostAllocations, err := portRegistry.List(host)
+		if err != nil {
+			return nil, err
+		}
+		allocations[host] = hostAllocations
+	}
+	return allocations, nil
+}
+
+// limitReconcileDynos caps the total number of dynos started in one attempt.
+func limitReconcileDynos(missing map[string]int, max int) map[string]int {
+	limited := map[string]int{}
+	for _, process := range sortedIntKeys(missing) {
+		if max <= 0 {
+			break
+		}
+		n := missing[process]
+		if n > max {
+			n = max
+		}
+		limited[process] = n
+		max -= n
+	}
+	return limited
+}
+
+// reconcileApp starts replacement dynos for an app and re-syncs the
+// load-balancers, subject to the app's backoff.
+func (server *Server) reconcileApp(applicationName string, missing map[string]int) {
+	now := time.Now()
+	if !reconcileTracker.Ready(applicationName, now) {
+		return
+	}
+	// Don't interfere with deploys or other commands which are running.
+	if !tryLockApp(applicationName) {
+		return
+	}
+	defer unlockApp(applicationName)
+
+	var (
+		backoff = reconcileTracker.Attempt(applicationName, now)
+		start   = limitReconcileDynos(missing, reconcileMaxDynosPerAttempt)
+		logger  = NewLogger(os.Stdout, fmt.Sprintf("[reconcile:%v] ", applicationName))
+	)
+	fmt.Fprintf(logger, "Missing dynos: %v, starting replacements: %v (attempt #%v, next attempt no sooner than %v)\n", missing, start, backoff.Attempts, backoff.Next.Format(time.RFC3339))
+
+	if err := server.startAdditionalDynos(logger, applicationName, start); err != nil {
+		log.WithField("app", applicationName).Errorf("Reconciliation attempt #%v failed: %s", backoff.Attempts, err)
+	}
+}
+
+// startAdditionalDynos starts the given number of dynos per process type for
+// an app at its latest version, in addition to those already running and
+// without altering its configured scale.
+//
+// NB: The app lock must be held.
+func (server *Server) startAdditionalDynos(logger io.Writer, applicationName string, processes map[string]int) error {
+	deployLock.start()
+	defer deployLock.finish()
+
+	return server.WithApplication(applicationName, func(app *Application, cfg *Config) error {
+		// Temporarily replace Processes with the additional dynos to start.
+		app.Processes = processes
+		deployment := NewDeployment(DeploymentOptions{
+			Server:      server,
+			Logger:      logger,
+			Config:      cfg,
+			Application: app,
+			Version:     app.LastDeploy,
+			StartedTs:   time.Now(),
+			ScalingOnly: true,
+		})
+		return deployment.Deploy()
+	})
+}
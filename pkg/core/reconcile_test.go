@@ -0,0 +1,73 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMissingDynos(t *testing.T) {
+	app := &Application{
+		Name:       "myapp",
+		LastDeploy: "v7",
+		Processes:  map[string]int{"web": 3, "background_worker": 2, "cron": 0},
+	}
+	statuses := map[string]NodeStatus{
+		"node-a": {Host: "node-a", Containers: []string{"myapp-v7-web-10001-running", "myapp-v6-web-10002-running", "myapp-v7-backgroundWorker-10003-running"}},
+		"node-b": {Host: "node-b", Containers: []string{"myapp-v7-web-10004-running", "other-v7-web-10005-running"}},
+		// Process types with dynos on unreachable nodes are skipped.
+		"node-c": {Host: "node-c", Containers: []string{"myapp-v7-backgroundWorker-10006-running"}, Err: errors.New("check timed out")},
+	}
+
+	expected := map[string]int{"web": 1}
+	if actual := missingDynos(app, statuses, nil); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected missing=%v but actual=%v", expected, actual)
+	}
+
+	// Unreachable nodes without any known containers are consulted via port
+	// allocations, which may not have a port suffix yet.
+	statuses["node-d"] = NodeStatus{Host: "node-d", Err: errors.New("check timed out")}
+	allocations := map[string][]PortAllocation{
+		"node-d": {{Port: 10007, Container: "myapp-v7-web"}, {Port: 10008, Container: "myapp-v6-cron-10008"}},
+	}
+	expected = map[string]int{}
+	if actual := missingDynos(app, statuses, allocations); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected missing=%v but actual=%v", expected, actual)
+	}
+
+	if expected, actual := map[string]int{"background_worker": 2, "web": 1}, limitReconcileDynos(map[string]int{"web": 4, "background_worker": 2}, 3); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected limited=%v but actual=%v", expected, actual)
+	}
+}
+
+func TestReconcileTrackerBackoff(t *testing.T) {
+	var (
+		rt  = ReconcileTracker{backoffs: map[string]ReconcileBackoff{}}
+		now = time.Now()
+	)
+	expectedDelays := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute}
+	for i, expected := range expectedDelays {
+		if !rt.Ready("myapp", now) {
+			t.Fatalf("[i=%v] Expected tracker to be ready", i)
+		}
+		backoff := rt.Attempt("myapp", now)
+		if actual := backoff.Next.Sub(now); actual != expected {
+			t.Errorf("[i=%v] Expected backoff delay=%v but actual=%v", i, expected, actual)
+		}
+		if rt.Ready("myapp", now) {
+			t.Errorf("[i=%v] Expected tracker to be backing off", i)
+		}
+		now = backoff.Next
+	}
+	for i := 0; i < 20; i++ {
+		rt.Attempt("myapp", now)
+	}
+	if backoff, _ := rt.Get("myapp"); backoff.Next.Sub(now) != reconcileMaxBackoff {
+		t.Errorf("Expected backoff delay to be capped at %v but actual=%v", reconcileMaxBackoff, backoff.Next.Sub(now))
+	}
+	rt.Reset("myapp")
+	if !rt.Ready("myapp", now) {
+		t.Errorf("Expected tracker to be ready after reset")
+	}
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/jaytaylor/shipbuilder/pkg/domain"
+)
+
+// releaseRefPrefix is the namespace of the refs which keep each released
+// revision reachable in the app's git repository, protecting them from being
+// garbage collected after a force-push.
+const releaseRefPrefix = "refs/shipbuilder/releases/"
+
+func releaseRef(version string) string {
+	return releaseRefPrefix + version
+}
+
+// git runs a git command against the app's bare git repository and returns
+// the trimmed output.
+func (app *Application) git(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", app.BareGitDir()}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %v: %s (output=%v)", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// createReleaseRef points the protected ref for a release version at the
+// deployed revision.
+func (app *Application) createReleaseRef(version string, revision string) error {
+	if len(revision) == 0 || isArchiveRevision(revision) {
+		return nil
+	}
+	if _, err := app.git("update-ref", releaseRef(version), revision); err != nil {
+		return fmt.Errorf("creating release ref for app=%v version=%v: %s", app.Name, version, err)
+	}
+	return nil
+}
+
+// deployedRevision returns the git revision of the app's current release, or
+// HEAD when it isn't recorded, e.g. for releases deployed from an archive.
+func (app *Application) deployedRevision() string {
+	if len(app.LastDeploy) > 0 {
+		if _, err := app.git("rev-parse", "--verify", "--quiet", releaseRef(app.LastDeploy)); err == nil {
+			return releaseRef(app.LastDeploy)
+		}
+	}
+	return "HEAD"
+}
+
+// pruneReleaseRefs removes the protected refs for all versions which are no
+// longer in the retained list of releases.
+func (app *Application) pruneReleaseRefs(releases []domain.Release) error {
+	out, err := app.git("for-each-ref", "--format=%(refname)", releaseRefPrefix)
+	if err != nil {
+		return fmt.Errorf("listing release refs for app=%v: %s", app.Name, err)
+	}
+	retained := map[string]struct{}{}
+	for _, r := range releases {
+		retained[releaseRef(r.Version)] = struct{}{}
+	}
+	for _, ref := range strings.Split(out, "\n") {
+		if len(ref) == 0 {
+			continue
+		}
+		if _, ok := retained[ref]; ok {
+			continue
+		}
+		if _, err := app.git("update-ref", "-d", ref); err != nil {
+			return fmt.Errorf("removing release ref for app=%v: %s", app.Name, err)
+		}
+	}
+	return nil
+}
+
+// releaseRevision returns the recorded revision of a release, which is empty
+// for releases which predate revisions being recorded.
+func (app *Application) releaseRevision(releases []domain.Release, version string) (string, error) {
+	for _, r := range releases {
+		if r.Version == version {
+			return r.Revision, nil
+		}
+	}
+	return "", fmt.Errorf("release %v of app %v not found, see releases:list for the available versions", version, app.Name)
+}
+
+// verifyRevision ensures the revision of a release is still present in the
+// app's git repository.
+func (app *Application) verifyRevision(version string, revision string) error {
+	if len(revision) == 0 {
+		return fmt.Errorf("release %v of app %v has no recorded revision", version, app.Name)
+	}
+	if isArchiveRevision(revision) {
+		return nil
+	}
+	if _, err := app.git("cat-file", "-e", revision+"^{commit}"); err != nil {
+		return fmt.Errorf("revision %v of release %v is no longer available in the git repository for app %v; push it again to restore it", revision, version, app.Name)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jaytaylor/shipbuilder/pkg/domain"
+)
+
+// withTestGitRepo creates a bare git repository for an app holding a single
+// commit of the given files, and returns the commit's revision.
+func withTestGitRepo(t *testing.T, applicationName string, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "shipbuilder-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := gitDirectory
+	gitDirectory = dir
+	cleanup := func() {
+		gitDirectory = previous
+		os.RemoveAll(dir)
+	}
+
+	work := filepath.Join(dir, "work")
+	git := func(args ...string) string {
+		args = append([]string{"-C", work, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		out, err := exec.Command("git", args...).CombinedOutput()
+		if err != nil {
+			cleanup()
+			t.Fatalf("git %v: %s: %s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if err := os.MkdirAll(work, os.FileMode(int(0700))); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	git("init", "--quiet")
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(work, name), []byte(content), os.FileMode(int(0600))); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+	git("add", "--all")
+	git("commit", "--quiet", "--message", "Initial commit")
+	git("clone", "--quiet", "--bare", work, filepath.Join(dir, applicationName))
+	return git("rev-parse", "HEAD"), cleanup
+}
+
+func TestReleaseRevision(t *testing.T) {
+	revision, cleanup := withTestGitRepo(t, "myapp", map[string]string{"Procfile": "web: ./run-server\n"})
+	defer cleanup()
+
+	var (
+		app      = &Application{Name: "myapp"}
+		missing  = strings.Repeat("0", len(revision))
+		releases = []domain.Release{
+			{Version: "v1", Revision: revision},
+			{Version: "v2", Revision: missing},
+			{Version: "v3", Revision: archiveRevisionPrefix + "abc"},
+			{Version: "v4"},
+		}
+	)
+
+	for _, r := range releases {
+		if actual, err := app.releaseRevision(releases, r.Version); err != nil || actual != r.Revision {
+			t.Errorf("Expected the revision of %v=%q but actual=%q (err=%v)", r.Version, r.Revision, actual, err)
+		}
+	}
+	if _, err := app.releaseRevision(releases, "v5"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected a missing release to be an error but actual=%v", err)
+	}
+
+	for _, r := range []domain.Release{releases[0], releases[2]} {
+		if err := app.verifyRevision(r.Version, r.Revision); err != nil {
+			t.Errorf("Expected the revision of %v to be available but err=%v", r.Version, err)
+		}
+	}
+	if err := app.verifyRevision("v2", missing); err == nil || !strings.Contains(err.Error(), "no longer available") {
+		t.Errorf("Expected a missing revision to be an error but actual=%v", err)
+	}
+	if err := app.verifyRevision("v4", ""); err == nil || !strings.Contains(err.Error(), "no recorded revision") {
+		t.Errorf("Expected an unrecorded revision to be an error but actual=%v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+	log "github.com/sirupsen/logrus"
+)
+
+// ScheduledJob is a command run periodically in a one-off dyno from the app's
+// current release.
+type ScheduledJob struct {
+	Id       int
+	Schedule string // Standard 5-field cron expression, e.g. "0 3 * * *".
+	Command  string
+}
+
+func (job ScheduledJob) String() string {
+	return fmt.Sprintf("#%v [%v] %v", job.Id, job.Schedule, job.Command)
+}
+
+var scheduledJobTracker = ScheduledJobTracker{running: map[string]bool{}}
+
+// ScheduledJobTracker prevents a scheduled job from being started while its
+// previous run is still going.
+type ScheduledJobTracker struct {
+	running map[string]bool
+	lock    sync.Mutex
+}
+
+// TryStart marks the job as running, and returns false if it already was.
+func (st *ScheduledJobTracker) TryStart(applicationName string, job ScheduledJob) bool {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	key := fmt.Sprintf("%v#%v", applicationName, job.Id)
+	if st.running[key] {
+		return false
+	}
+	st.running[key] = true
+	return true
+}
+
+// Finish marks the job as no longer running.
+func (st *ScheduledJobTracker) Finish(applicationName string, job ScheduledJob) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	delete(st.running, fmt.Sprintf("%v#%v", applicationName, job.Id))
+}
+
+// parseSchedule parses a standard 5-field cron expression or descriptor, e.g.
+// "@hourly".
+func parseSchedule(spec string) (cron.Schedule, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %s", spec, err)
+	}
+	return schedule, nil
+}
+
+// dueScheduledJobs returns the jobs which were scheduled to run after since and
+// up to and including now.
+func dueScheduledJobs(jobs []ScheduledJob, since time.Time, now time.Time) []ScheduledJob {
+	due := []ScheduledJob{}
+	for _, job := range jobs {
+		schedule, err := parseSchedule(job.Schedule)
+		if err != nil {
+			log.Warnf("[scheduler] Skipping job %v: %s", job, err)
+			continue
+		}
+		if next := schedule.Next(since); !next.IsZero() && !next.After(now) {
+			due = append(due, job)
+		}
+	}
+	return due
+}
+
+func (server *Server) scheduler() {
+	since := time.Now()
+	for now := range time.Tick(SCHEDULER_INTERVAL_SECONDS * time.Second) {
+		if err := server.runScheduledJobs(since, now); err != nil {
+			log.Errorf("[scheduler] %s", err)
+		}
+		since = now
+	}
+}
+
+func (server *Server) runScheduledJobs(since time.Time, now time.Time) error {
+	cfg, err := server.getConfig(true)
+	if err != nil {
+		return err
+	}
+	nodes := schedulableNodes(cfg.Nodes)
+	for _, app := range cfg.Applications {
+		if len(app.Schedules) == 0 || len(app.LastDeploy) == 0 {
+			continue
+		}
+		for _, job := range dueScheduledJobs(app.Schedules, since, now) {
+			go server.runScheduledJob(app, nodes, job)
+		}
+	}
+	return nil
+}
+
+// runScheduledJob starts a scheduled job in a one-off dyno, unless its previous
+// run is still going.  Failures are reported through the app's notification
+// deploy-hooks.
+func (server *Server) runScheduledJob(app *Application, nodes []*Node, job ScheduledJob) {
+	logger := NewLogger(os.Stdout, fmt.Sprintf("[scheduler] [%v] ", app.Name))
+	if !scheduledJobTracker.TryStart(app.Name, job) {
+		fmt.Fprintf(logger, "Skipping scheduled job %v, its previous run is still going\n", job)
+		return
+	}
+	fmt.Fprintf(logger, "Running scheduled job %v\n", job)
+	_, err := server.launchRun(app, nodes, job.Command, logger, func(record RunRecord) {
+		scheduledJobTracker.Finish(app.Name, job)
+		if record.State == RunFailed {
+			duration := record.FinishedTs.Sub(record.StartedTs).Truncate(time.Second)
+			server.reportScheduledJobFailure(app, job, fmt.Errorf("%v exited with status %v after %v", record.ProcessName(), record.ExitStatus, duration))
+		}
+	})
+	if err != nil {
+		scheduledJobTracker.Finish(app.Name, job)
+		server.reportScheduledJobFailure(app, job, err)
+	}
+}
+
+func (server *Server) reportScheduledJobFailure(app *Application, job ScheduledJob, err error) {
+	log.WithField("app", app.Name).Errorf("[scheduler] Scheduled job %v failed: %s", job, err)
+	d := NewDeployment(DeploymentOptions{
+		Server:      server,
+		Logger:      NewLogger(os.Stdout, "[scheduler] "),
+		Application: app,
+		Version:     app.LastDeploy,
+		StartedTs:   time.Now(),
+	})
+	hookURLs := notificationHookURLs(d.deployHookURLs())
+	if len(hookURLs) == 0 {
+		return
+	}
+	d.dispatchDeployHooks(hookURLs, fmt.Sprintf("%v: Scheduled job %v failed: %s", app.Name, job, err), true)
+}
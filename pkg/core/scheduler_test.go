@@ -0,0 +1,89 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDueScheduledJobs(t *testing.T) {
+	jobs := []ScheduledJob{
+		{Id: 1, Schedule: "0 3 * * *", Command: "./bin/cleanup"},
+		{Id: 2, Schedule: "*/15 * * * *", Command: "./bin/sync"},
+		{Id: 3, Schedule: "@hourly", Command: "./bin/report"},
+		{Id: 4, Schedule: "not a schedule", Command: "./bin/broken"},
+	}
+	testCases := []struct {
+		since    string
+		now      string
+		expected []int
+	}{
+		{"02:59:30", "03:00:30", []int{1, 2, 3}},
+		{"03:00:30", "03:01:30", []int{}},
+		{"03:14:10", "03:15:10", []int{2}},
+		// Jobs aren't run more than once when the scheduler falls behind.
+		{"03:20:00", "04:20:00", []int{2, 3}},
+	}
+	at := func(clock string) time.Time {
+		ts, err := time.ParseInLocation("2006-01-02 15:04:05", "2018-06-01 "+clock, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	for i, testCase := range testCases {
+		due := dueScheduledJobs(jobs, at(testCase.since), at(testCase.now))
+		ids := []int{}
+		for _, job := range due {
+			ids = append(ids, job.Id)
+		}
+		if len(ids) != len(testCase.expected) {
+			t.Errorf("[i=%v] Expected due jobs=%v but actual=%v", i, testCase.expected, ids)
+			continue
+		}
+		for j := range ids {
+			if ids[j] != testCase.expected[j] {
+				t.Errorf("[i=%v] Expected due jobs=%v but actual=%v", i, testCase.expected, ids)
+				break
+			}
+		}
+	}
+
+	if _, err := parseSchedule("0 3 * *"); err == nil {
+		t.Errorf("Expected error for schedule with too few fields")
+	}
+}
+
+func TestScheduledJobTracker(t *testing.T) {
+	var (
+		st  = ScheduledJobTracker{running: map[string]bool{}}
+		job = ScheduledJob{Id: 1, Schedule: "@hourly", Command: "./bin/report"}
+	)
+	if !st.TryStart("myapp", job) {
+		t.Fatalf("Expected first run to start")
+	}
+	if st.TryStart("myapp", job) {
+		t.Errorf("Expected overlapping run to be skipped")
+	}
+	if !st.TryStart("otherapp", job) || !st.TryStart("myapp", ScheduledJob{Id: 2}) {
+		t.Errorf("Expected other jobs to be unaffected")
+	}
+	st.Finish("myapp", job)
+	if !st.TryStart("myapp", job) {
+		t.Errorf("Expected job to start again once its previous run finished")
+	}
+}
+
+func TestNotificationHookURLs(t *testing.T) {
+	hookURLs := []string{
+		"https://api.hipchat.com/v1/rooms/message?auth_token=x&room_id=1",
+		"https://api.newrelic.com/v2/applications/123/deployments.json",
+		"https://app.datadoghq.com/api/v1/events?api_key=x",
+		"https://example.com/hook",
+		"https://hooks.slack.com/services/T0/B0/x",
+	}
+	expected := []string{hookURLs[0], hookURLs[2], hookURLs[4]}
+	if actual := notificationHookURLs(hookURLs); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected notification hooks=%v but actual=%v", expected, actual)
+	}
+}
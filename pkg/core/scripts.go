@@ -449,6 +449,7 @@ import sys
 log = lambda message: sys.stdout.write('%s\n' % (message,))
 
 dynoDelimiter = '-'
+dynoMetadataPrefix = '''` + DYNO_METADATA_PREFIX + `'''
 
 lsCmd = '''lxc list | sed 1,3d | grep -v '^+' | awk '$4 == "RUNNING" { print $2 " " $6 }' '''.strip()
 
@@ -458,6 +459,19 @@ def requireRoot(argv):
         sys.stderr.write('FATAL: %s must be run under root user\n' % (argv[0],))
         sys.exit(1)
 
+def dynoPort(container):
+    """
+    Read the port from the dyno metadata, falling back to parsing the container
+    name for dynos started before metadata was recorded.
+    """
+    try:
+        port = subprocess.check_output(['lxc', 'config', 'get', container, dynoMetadataPrefix + 'port']).strip()
+    except (subprocess.CalledProcessError, OSError):
+        port = ''
+    if port:
+        return port
+    return container.rsplit(dynoDelimiter, 1)[1] # Format is app-version-process-port.
+
 def main(argv):
     requireRoot(argv)
 
@@ -468,10 +482,10 @@ def main(argv):
     for line in out.split('\n'):
         try:
             container, ip = line.split(' ', 2)
-            app, version, process, port = container.rsplit(dynoDelimiter, 3) # Format is app-version-process-port.
+            port = dynoPort(container)
             portForward('add', container, ip, port)
-            print('app=%s v=%s process=%s ort=%s' % (app, version, process, port))
-        except ValueError:
+            print('container=%s port=%s' % (container, port))
+        except (ValueError, IndexError):
             print('WARNING: Ignoring unrecognized container/ip %s' % (line,))
     print('----')
 
@@ -495,6 +509,7 @@ lxcDir='''` + LXC_DIR + `'''
 lxcBin='''` + LXC_BIN + `'''
 zfsContainerMount='''` + ZFS_CONTAINER_MOUNT + `'''
 dynoDelimiter = '''` + DYNO_DELIMITER + `'''
+dynoMetadataPrefix = '''` + DYNO_METADATA_PREFIX + `'''
 defaultSshHost = '''` + DefaultSSHHost + `'''
 envDir = '''` + ENV_DIR + `'''
 container = None
@@ -562,9 +577,19 @@ def unmountContainerFs(container):
         stderr=sys.stderr,
     )
 
+# Options which map to LXC container limits.
+limitKeys = (
+    ('memory', 'limits.memory'),
+    ('cpus', 'limits.cpu'),
+    ('cpu', 'limits.cpu.allowance'),
+    ('processes', 'limits.processes'),
+)
+# Options which identify the dyno, all are required.
+identityKeys = ('app', 'version', 'process', 'port')
+optionNames = list(identityKeys) + ['check', 'checkTimeout', 'index'] + [option for option, _ in limitKeys]
+
 def showHelpAndExit(argv):
-    message = '''usage: {} [container-name]
-       of the form: [app]_[version]_[process]_[port]
+    message = '''usage: {} [container-name] app=.. version=.. process=.. port=.. [option=value]...
 
        For example, here is how you would boot a container with the following attributes:
 
@@ -575,8 +600,21 @@ def showHelpAndExit(argv):
                "port-forward": "10001"
            }}
 
-       $ {} myApp-v1337-web-10001
-'''.format(argv[0])
+       $ {} myApp-v1337-web-10001 app=myApp version=v1337 process=web port=10001
+
+       The app, version, process and port are recorded as the dyno metadata,
+       the container name is never parsed.
+
+       Options:
+
+           check=/path       HTTP health-check path which must respond successfully
+           checkTimeout=N    Seconds to wait for the health-check to pass (default: 60)
+           index=N           Stable number of the dyno within its process type, e.g. 1 for web.1
+           memory=512MB      Container memory limit
+           cpus=N            Number of CPU cores available to the container
+           cpu=N%            Share of CPU time available to the container under contention
+           processes=N       Maximum number of processes in the container
+'''.format(argv[0], argv[0])
     print(message)
     sys.exit(0)
 
@@ -587,15 +625,60 @@ def requireRoot(argv):
         sys.exit(1)
 
 def validateMainArgs(argv):
-    if len(argv) != 2:
+    if len(argv) < 2:
         sys.stderr.write('{} error: missing required argument: container-name\n'.format(sys.argv))
         sys.exit(1)
+    for arg in argv[2:]:
+        if '=' not in arg or arg.split('=', 1)[0] not in optionNames:
+            sys.stderr.write('{} error: unrecognized option: {}\n'.format(sys.argv, arg))
+            sys.exit(1)
+    options = dict(arg.split('=', 1) for arg in argv[2:])
+    missing = [key for key in identityKeys if not options.get(key)]
+    if missing:
+        sys.stderr.write('{} error: missing required option(s): {}\n'.format(sys.argv, ', '.join(missing)))
+        sys.exit(1)
 
 def parseMainArgs(argv):
     validateMainArgs(argv)
     container = argv[1]
-    app, version, process, port = container.rsplit(dynoDelimiter, 3) # Format is app-version-process-port.
-    return (container, app, version, process, port)
+    options = dict(arg.split('=', 1) for arg in argv[2:])
+    return (container, options['app'], options['version'], options['process'], options['port'], options)
+
+def setLimits(container, options):
+    for option, key in limitKeys:
+        if option in options:
+            log('setting {0}={1}'.format(key, options[option]))
+            subprocess.check_call(
+                [lxcBin, 'config', 'set', container, key, options[option]],
+                stdout=sys.stdout,
+                stderr=sys.stderr,
+            )
+
+def setMetadata(container, app, version, process, port, index):
+    """
+    Record the dyno identity passed in the options in the container config, so
+    it need not be parsed from the container name.
+    """
+    # The release is the fingerprint of the image the container was created
+    # from.
+    release = subprocess.check_output([lxcBin, 'config', 'get', container, 'volatile.base_image']).strip()
+    metadata = (
+        ('app', app),
+        ('version', version),
+        ('process', process),
+        ('port', port),
+        ('index', index),
+        ('release', release),
+        ('started', time.strftime('%Y-%m-%dT%H:%M:%SZ', time.gmtime())),
+    )
+    for key, value in metadata:
+        if not value:
+            continue
+        subprocess.check_call(
+            [lxcBin, 'config', 'set', container, dynoMetadataPrefix + key, value],
+            stdout=sys.stdout,
+            stderr=sys.stderr,
+        )
 
 def main(argv):
     global container
@@ -605,7 +688,7 @@ def main(argv):
 
     requireRoot(argv)
 
-    container, app, version, process, port = parseMainArgs(argv)
+    container, app, version, process, port, options = parseMainArgs(argv)
 
     # For safety, even though it's unlikely, try to kill/shutdown any existing container with the same name.
     subprocess.call([lxcBin + ' stop --force {0} 1>&2 2>/dev/null'.format(container)], shell=True)
@@ -614,6 +697,10 @@ def main(argv):
     # Clone the specified container.
     cloneContainer(app, container, version)
 
+    setLimits(container, options)
+
+    setMetadata(container, app, version, process, port, options.get('index'))
+
     log('creating run script for app "{0}" with process type={1}'.format(app, process))
     # NB: The curly braces are kinda crazy here, to get a single '{' or '}' with python.format(), use double curly
     # braces.
@@ -642,8 +729,7 @@ fi
 
 echo '{port}' > ../env/PORT
 while read line || [ -n "${{line}}" ]; do
-    # Convert app process name from snake to camelCase.
-    process="$(echo "${{line%%:*}}" | sed 's/[_-]\+\([a-zA-Z0-9]\)/\U\1/g')"
+    process="${{line%%:*}}"
     command="${{line#*: }}"
     if [ "${{process}}" = "{process}" ]; then
         envdir {envDir} /bin/bash -c "${{__DEBUG}}export PATH=\"$(find /app/.shipbuilder -type d -wholename '*bin' -maxdepth 2):${{PATH}}\" ; set -o errexit ; set -o pipefail ; ( ${{command}} ) 2>&1 | /app/` + BINARY + ` logger --host={host} --app={app} --process={process}.{port}"
@@ -683,10 +769,12 @@ done < Procfile'''.format(port=port, host=host.split('@')[-1], process=process,
         portForward('remove', container, '', port)
         portForward('add', container, ip, port)
 
-        if process == 'web':
+        if process == 'web' or 'check' in options:
             log('waiting for web-server to start up')
             startedTs = time.time()
-            maxSeconds = 60
+            maxSeconds = int(options.get('checkTimeout', 60))
+            # Declared checks must respond with a successful status code.
+            failFlag = ['--fail'] if 'check' in options else []
             while True:
                 try:
                     subprocess.check_call([
@@ -694,21 +782,22 @@ done < Procfile'''.format(port=port, host=host.split('@')[-1], process=process,
                         '--silent',
                         '--output', '/dev/null',
                         '--write-out', '%{http_code} %{url_effective}\n',
-                        '{0}:{1}/'.format(ip, port),
+                    ] + failFlag + [
+                        '{0}:{1}{2}'.format(ip, port, options.get('check', '/')),
                     ], stderr=sys.stderr, stdout=sys.stdout)
                     break
 
                 except subprocess.CalledProcessError, e:
                     if time.time() - startedTs > maxSeconds:
                         sys.stderr.write('- error: curl http check failed, {0}\n'.format(e))
-                        subprocess.check_call(['/tmp/shutdown_container.py', container, 'skip-stop'])
+                        subprocess.check_call(['/tmp/shutdown_container.py', container, 'skip-stop', 'app=' + app, 'version=' + version, 'port=' + port])
                         sys.exit(1)
                     else:
                         time.sleep(1)
 
     else:
         sys.stderr.write('- error: failed to retrieve container ip\n')
-        subprocess.check_call(['/tmp/shutdown_container.py', container, 'skip-stop'])
+        subprocess.check_call(['/tmp/shutdown_container.py', container, 'skip-stop', 'app=' + app, 'version=' + version, 'port=' + port])
         sys.exit(1)
 
 main(sys.argv)`
@@ -717,6 +806,7 @@ var SHUTDOWN_CONTAINER = `#!/usr/bin/python -u
 # -*- coding: utf-8 -*-
 
 import os
+import re
 import subprocess
 import sys
 import time
@@ -725,6 +815,7 @@ lxcBin='''` + LXC_BIN + `'''
 DefaultLXCFS = '''` + DefaultLXCFS + `'''
 DefaultZFSPool = '''` + DefaultZFSPool + `'''
 dynoDelimiter = '''` + DYNO_DELIMITER + `'''
+dynoMetadataPrefix = '''` + DYNO_METADATA_PREFIX + `'''
 container = None
 log = lambda message: sys.stdout.write('[{0}] {1}\n'.format(container, message))
 
@@ -742,12 +833,20 @@ def retriableCommand(*command):
                 raise e
 
 def showHelpAndExit(argv, ok=True):
-    message = '''usage: {} [container-name] [?"skip-stop", ?"iptables-only"]
-       where "container-name" is of the form: [app]-v[version]-[process]-[port]
+    message = '''usage: {} [container-name] [?"app=[app]", ?"version=[version]", ?"process=[process]", ?"port=[port]"] [?"skip-stop", ?"iptables-only", ?"grace-period=[seconds]"]
+
+       The app, version and port are taken from the options when given, then
+       from the dyno metadata recorded in the container config.  Only legacy
+       containers without metadata fall back to parsing a container name of
+       the form: [app]-v[version]-[process]-[port]
 
        "skip-stop" will only go about destroying the container (not shutting
         it down).
 
+       "grace-period=[seconds]" stops the app service, which sends it SIGTERM,
+        and waits up to the given number of seconds for it to exit before the
+        container is forcibly stopped.
+
        "iptables-only" will skip stop / destroy steps and only attempt to purge
         the iptables rules corresponding with the container.
 
@@ -761,7 +860,7 @@ def showHelpAndExit(argv, ok=True):
                "port-forward": "10001"
            }}
 
-       $ {} myApp-v1337-web-10001
+       $ {} myApp-v1337-web-10001 app=myApp version=v1337 port=10001
 '''.format(argv[0], argv[0])
     print(message)
     sys.exit(0 if ok else 1)
@@ -773,14 +872,39 @@ def requireRoot(argv):
         sys.exit(1)
 
 def validateMainArgs(argv):
-    if len(argv) < 2 or (len(argv) > 2 and not all(map(lambda arg: arg in ('skip-stop', 'iptables-only'), argv[2:]))):
+    if len(argv) < 2 or (len(argv) > 2 and not all(map(lambda arg: arg in ('skip-stop', 'iptables-only') or re.match(r'^(grace-period=[0-9]+|(app|version|process)=.+|port=[0-9]+)$', arg), argv[2:]))):
         showHelpAndExit(argv, False)
 
+def dynoMetadata(container, key):
+    try:
+        return subprocess.check_output([lxcBin, 'config', 'get', container, dynoMetadataPrefix + key], stderr=open(os.devnull, 'w')).strip()
+    except (subprocess.CalledProcessError, OSError):
+        return ''
+
 def parseMainArgs(argv):
     validateMainArgs(argv)
     container = argv[1]
-    app, version, process, port = container.rsplit(dynoDelimiter, 3) # Format is app-version-process-port.
-    return (container, app, version, process, port)
+    options = dict(arg.split('=', 1) for arg in argv[2:] if '=' in arg)
+    identity = dict((key, options.get(key) or dynoMetadata(container, key)) for key in ('app', 'version', 'port'))
+    if not all(identity.values()):
+        # Legacy containers without metadata.
+        try:
+            app, version, _, port = container.rsplit(dynoDelimiter, 3) # Format is app-version-process-port.
+        except ValueError:
+            sys.stderr.write('FATAL: unable to identify dyno {0}, pass the app, version and port options\n'.format(container))
+            sys.exit(1)
+        identity = dict(app=identity['app'] or app, version=identity['version'] or version, port=identity['port'] or port)
+    return (container, identity['app'], identity['version'], identity['port'], options)
+
+def stopApp(gracePeriod):
+    """
+    Stop the app service, giving it up to gracePeriod seconds to exit after
+    SIGTERM.
+    """
+    log('stopping app service with a {0}s grace period'.format(gracePeriod))
+    rc = subprocess.call(['timeout', str(gracePeriod), lxcBin, 'exec', container, '--', 'systemctl', 'stop', 'app'], stdout=sys.stdout, stderr=sys.stderr)
+    if rc == 124:
+        log('app service still running after {0}s, forcing stop'.format(gracePeriod))
 
 def main(argv):
     global container
@@ -790,7 +914,7 @@ def main(argv):
 
     requireRoot(argv)
 
-    container, app, version, process, port = parseMainArgs(argv)
+    container, app, version, port, options = parseMainArgs(argv)
     skipStop = len(argv) > 2 and 'skip-stop' in argv[2:]
     iptablesOnly = len(argv) > 2 and 'iptables-only' in argv[2:]
 
@@ -831,6 +955,9 @@ def main(argv):
                 #     if not skipStop:
                 #         raise e # Otherwise ignore.
 
+                gracePeriod = int(options.get('grace-period', '0'))
+                if gracePeriod > 0 and not skipStop:
+                    stopApp(gracePeriod)
                 subprocess.call([lxcBin, 'stop', '--force', container])
                 subprocess.check_call([
                     'bash',
@@ -861,6 +988,7 @@ main(sys.argv)`
 var (
 	UPSTART        = template.New("UPSTART")
 	HAPROXY_CONFIG = template.New("HAPROXY_CONFIG")
+	NGINX_CONFIG   = template.New("NGINX_CONFIG")
 	BUILD_PACKS    = map[string]*template.Template{}
 )
 
@@ -897,6 +1025,11 @@ exec start-stop-daemon --start --chuid ubuntu --exec /bin/sh -- -c "exec envdir
 		return fmt.Errorf("parsing HAPROXY_CONFIG template: %s", err)
 	}
 
+	NGINX_CONFIG, err = template.New("NGINX_CONFIG").Parse(scripts.NginxSrc)
+	if err != nil {
+		return fmt.Errorf("parsing NGINX_CONFIG template: %s", err)
+	}
+
 	// // Discover all available build-packs.
 	// listing, err := ioutil.ReadDir(DIRECTORY + "/build-packs")
 	// if err != nil {
@@ -936,10 +1069,15 @@ set -o nounset
 cd /app/src
 git checkout -q -f {{ .Revision }}
 
-# Convert references to submodules to be read-only.
 if [[ -f '.gitmodules' ]] ; then 
+{{- if .GitSSHCommand }}
+    # Authenticate submodule access with the app's private key.
+    export GIT_SSH_COMMAND='{{ .GitSSHCommand }}'
+{{- else }}
+    # Convert references to submodules to be read-only.
     echo 'git: converting submodule refs to be read-only'
     sed -i 's,git@github.com:,git://github.com/,g' .gitmodules
+{{- end }}
 
     # Update the submodules.
     git submodule init
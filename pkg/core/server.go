@@ -33,17 +33,19 @@ var (
 
 // Server struct encapsulates the entirety of Shipbuilder Server.
 type Server struct {
-	ListenAddr                string
-	LogServerListenAddr       string
-	LogServer                 *logserver.Server
-	BuildpacksProvider        domain.BuildpacksProvider
-	ReleasesProvider          domain.ReleasesProvider
-	GlobalPortTracker         *GlobalPortTracker
-	Name                      string // Name of server to use when posting external messages (e.g. deploy announcements)..
-	ImageURL                  string // Image to use when posting external messages (e.g. deploy announcements).
-	currentLoadBalancerConfig string
-	deployHooksMap            map[string]DeployHookFunc
-	ConfigFile                string // Path to ShipBuilder config.json.
+	ListenAddr                   string
+	LogServerListenAddr          string
+	LogServer                    *logserver.Server
+	BuildpacksProvider           domain.BuildpacksProvider
+	ReleasesProvider             domain.ReleasesProvider
+	Name                         string              // Name of server to use when posting external messages (e.g. deploy announcements)..
+	ImageURL                     string              // Image to use when posting external messages (e.g. deploy announcements).
+	desiredLoadBalancerConfigs   map[string]string   // Rendered LB config per driver name, as of the last sync.
+	loadBalancerRoutes           map[string]LBRoutes // Routes served by each load-balancer, as of its last sync or read.
+	currentLoadBalancerStructure string              // Rendered LB config without servers, as of the last full sync.
+	loadBalancerSyncs            int                 // Number of LB syncs started, so routes read meanwhile don't replace newer ones.
+	deployHooksMap               map[string]DeployHookFunc
+	ConfigFile                   string // Path to ShipBuilder config.json.
 }
 
 func run(name string, args ...string) error {
@@ -187,23 +189,15 @@ func (server *Server) handleCall(conn net.Conn, body string) error {
 				needsLock = cmd.LongName == "pre-receive" || cmd.LongName == "post-receive"
 			}
 			if needsLock {
-				globalLock.Lock()
 				if len(args) > 1 && args[1] != "" {
 					app := args[1].(string)
-					active, ok := appLocks[app]
-					if ok && active {
-						globalLock.Unlock()
+					if !tryLockApp(app) {
 						return fmt.Errorf("a command is already running for app=%q", app)
 					}
-					appLocks[app] = true
 					// Remove lock when we're done.
-					defer func() {
-						globalLock.Lock()
-						delete(appLocks, app)
-						globalLock.Unlock()
-					}()
-					globalLock.Unlock()
+					defer unlockApp(app)
 				} else {
+					globalLock.Lock()
 					defer func() {
 						globalLock.Unlock()
 					}()
@@ -225,6 +219,25 @@ func (server *Server) handleCall(conn net.Conn, body string) error {
 	return fmt.Errorf("unknown command: %v", args)
 }
 
+// tryLockApp marks a command as running for an app, unless one already is.
+// Returns true when the lock was acquired.
+func tryLockApp(app string) bool {
+	globalLock.Lock()
+	defer globalLock.Unlock()
+	if active, ok := appLocks[app]; ok && active {
+		return false
+	}
+	appLocks[app] = true
+	return true
+}
+
+// unlockApp releases a lock acquired by tryLockApp.
+func unlockApp(app string) {
+	globalLock.Lock()
+	delete(appLocks, app)
+	globalLock.Unlock()
+}
+
 func (server *Server) handleConnection(conn net.Conn) {
 	defer conn.Close()
 
@@ -268,14 +281,12 @@ func (server *Server) Start() error {
 		return err
 	}
 
-	server.GlobalPortTracker = &GlobalPortTracker{
-		Min: MinDynoPort,
-		Max: MaxDynoPort,
-	}
-
 	initDrains(server)
 	go server.monitorNodes()
 	go server.startCrons()
+	go server.autoscale()
+	go server.scheduler()
+	go server.reconcile()
 
 	log.Infof("Starting server on %v", server.ListenAddr)
 	ln, err := net.Listen("tcp", server.ListenAddr)
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"encoding/json"
 	"fmt"
 	"os/exec"
 	"strconv"
@@ -10,17 +11,56 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-const statusMonitorCheckCommand = `echo $(free -m | grep '^Mem:' | grep --only '[0-9]\+$') $(sudo lxc list --format json | jq -r '.[] | select(.status == "Running") | "\(.name)` + DYNO_DELIMITER + `\(.status)"' | tr $'\n' ' ')`
+// statusMonitorCheckCommand returns the shell command which reports a node's
+// resources and running containers as JSON.
+func statusMonitorCheckCommand() string {
+	zfsCmd := "true"
+	if DefaultLXCFS == "zfs" {
+		zfsCmd = `sudo zfs list -H -p -o avail ` + DefaultZFSPool + ` 2>/dev/null | awk '{ printf "%d", $1 / 1048576 }'`
+	}
+	return strings.Join([]string{
+		`free_mb="$(free -m | awk '/^Mem:/ { print $NF }')"`,
+		`disk_mb="$(df -m --output=avail / | tail -n 1 | tr -d ' ')"`,
+		`zfs_mb="$(` + zfsCmd + `)"`,
+		`lxd_version="$(sudo ` + LXC_BIN + ` info | awk '/server_version:/ { print $2 }')"`,
+		`sudo ` + LXC_BIN + ` list --format json | jq -c` +
+			` --argjson free "${free_mb:-0}" --argjson disk "${disk_mb:-0}" --argjson zfs "${zfs_mb:--1}" --argjson cpus "$(nproc)"` +
+			` --arg load "$(cut -d ' ' -f 1-3 /proc/loadavg)" --arg lxd "${lxd_version}" --arg prefix "` + DYNO_METADATA_PREFIX + `"` +
+			` '{freeMemoryMb: $free, freeDiskMb: $disk, freeZfsMb: $zfs, cpus: $cpus, loadAverage: ($load | split(" ") | map(tonumber)), lxdVersion: $lxd, containers: [.[] | select(.status == "Running") | {name: .name, status: .status, memoryBytes: (.state.memory.usage // 0), cpuNs: (.state.cpu.usage // 0), metadata: ((.config // {}) | with_entries(select(.key | startswith($prefix)) | .key |= ltrimstr($prefix)))}]}'`,
+	}, " ; ")
+}
 
 var nodeStatusRequestChannel = make(chan NodeStatusRequest)
 
 type NodeStatus struct {
-	Host         string
-	FreeMemoryMb int
-	Containers   []string
-	DeployMarker int
-	Ts           time.Time // No need to specify, will be automatically filled by `.Parse()'.
-	Err          error
+	Host           string
+	FreeMemoryMb   int
+	FreeDiskMb     int // Free space on the root filesystem.
+	FreeZFSMb      int // Free space in the ZFS pool, -1 when not applicable.
+	NumCPUs        int
+	LoadAverage    []float64 // 1, 5 and 15 minute load averages.
+	LXDVersion     string
+	Containers     []string
+	ContainerStats map[string]ContainerStats    // Keyed by container name, without the state.
+	DynoMetadata   map[string]map[string]string // Dyno metadata from LXC config keyed by container name, see DYNO_METADATA_PREFIX.
+	DeployMarker   int
+	Ts             time.Time // No need to specify, will be automatically filled by `.Parse()'.
+	Err            error
+}
+
+// ContainerStats is the resource usage of a running container.
+type ContainerStats struct {
+	MemoryMb   int
+	CPUSeconds float64 // Cumulative CPU time consumed.
+	CPUPercent float64 // CPU utilization since the previous status check, -1 when unknown.
+}
+
+func (stats ContainerStats) String() string {
+	cpu := "unknown"
+	if stats.CPUPercent >= 0 {
+		cpu = fmt.Sprintf("%.1f%%", stats.CPUPercent)
+	}
+	return fmt.Sprintf("%vMB memory, %v cpu", stats.MemoryMb, cpu)
 }
 
 type NodeStatusRequest struct {
@@ -28,28 +68,124 @@ type NodeStatusRequest struct {
 	resultChannel chan NodeStatus
 }
 
+// Summary describes the resources of a node.
+func (ns NodeStatus) Summary() string {
+	summary := []string{fmt.Sprintf("%vMB free", ns.FreeMemoryMb)}
+	if len(ns.LoadAverage) == 3 {
+		summary = append(summary, fmt.Sprintf("load %.2f %.2f %.2f on %v cpus", ns.LoadAverage[0], ns.LoadAverage[1], ns.LoadAverage[2], ns.NumCPUs))
+	}
+	if ns.FreeDiskMb > 0 {
+		summary = append(summary, fmt.Sprintf("%vMB disk free", ns.FreeDiskMb))
+	}
+	if ns.FreeZFSMb >= 0 {
+		summary = append(summary, fmt.Sprintf("%vMB zfs pool free", ns.FreeZFSMb))
+	}
+	if len(ns.LXDVersion) > 0 {
+		summary = append(summary, "lxd "+ns.LXDVersion)
+	}
+	return strings.Join(summary, ", ")
+}
+
+// nodeStatusReport is the JSON emitted by statusMonitorCheckCommand.
+type nodeStatusReport struct {
+	FreeMemoryMb *int      `json:"freeMemoryMb"`
+	FreeDiskMb   int       `json:"freeDiskMb"`
+	FreeZFSMb    int       `json:"freeZfsMb"`
+	NumCPUs      int       `json:"cpus"`
+	LoadAverage  []float64 `json:"loadAverage"`
+	LXDVersion   string    `json:"lxdVersion"`
+	Containers   []struct {
+		Name        string            `json:"name"`
+		Status      string            `json:"status"`
+		MemoryBytes int64             `json:"memoryBytes"`
+		CPUNs       int64             `json:"cpuNs"`
+		Metadata    map[string]string `json:"metadata"`
+	} `json:"containers"`
+}
+
 func (ns *NodeStatus) Parse(input string, err error) {
 	if err != nil {
 		ns.Err = err
 		return
 	}
 
-	tokens := strings.Fields(strings.TrimSpace(input))
+	input = strings.TrimSpace(input)
+	if !strings.HasPrefix(input, "{") {
+		ns.parseLegacy(input)
+		return
+	}
+
+	report := nodeStatusReport{}
+	if err := json.Unmarshal([]byte(input), &report); err != nil {
+		ns.Err = fmt.Errorf("status parse failed for input %q: %s", input, err)
+		return
+	}
+	if report.FreeMemoryMb == nil {
+		ns.Err = fmt.Errorf("status parse failed for input %q: missing free memory", input)
+		return
+	}
+
+	ns.FreeMemoryMb = *report.FreeMemoryMb
+	ns.FreeDiskMb = report.FreeDiskMb
+	ns.FreeZFSMb = report.FreeZFSMb
+	ns.NumCPUs = report.NumCPUs
+	ns.LoadAverage = report.LoadAverage
+	ns.LXDVersion = report.LXDVersion
+	ns.Containers = []string{}
+	ns.ContainerStats = map[string]ContainerStats{}
+	ns.DynoMetadata = map[string]map[string]string{}
+	for _, container := range report.Containers {
+		if len(container.Metadata) > 0 {
+			ns.DynoMetadata[container.Name] = container.Metadata
+		}
+		ns.Containers = append(ns.Containers, container.Name+DYNO_DELIMITER+container.Status)
+		ns.ContainerStats[container.Name] = ContainerStats{
+			MemoryMb:   int(container.MemoryBytes / 1024 / 1024),
+			CPUSeconds: float64(container.CPUNs) / float64(time.Second),
+			CPUPercent: -1,
+		}
+	}
+	ns.Ts = time.Now()
+}
+
+// parseLegacy parses the original status format of free memory followed by
+// running container names.
+func (ns *NodeStatus) parseLegacy(input string) {
+	tokens := strings.Fields(input)
 	if len(tokens) == 0 {
 		ns.Err = fmt.Errorf("status parse failed for input %q", input)
 		return
 	}
 
+	var err error
 	ns.FreeMemoryMb, err = strconv.Atoi(tokens[0])
 	if err != nil {
 		ns.Err = fmt.Errorf("integer conversion failed for token %q (tokens=%v)", tokens[0], tokens)
 		return
 	}
 
+	ns.FreeZFSMb = -1
 	ns.Containers = tokens[1:]
 	ns.Ts = time.Now()
 }
 
+// updateCPUPercent derives container CPU utilization from the cumulative CPU
+// time reported by the previous status of the node.
+func (ns *NodeStatus) updateCPUPercent(previous NodeStatus) {
+	elapsed := ns.Ts.Sub(previous.Ts).Seconds()
+	if previous.Err != nil || elapsed <= 0 {
+		return
+	}
+	for name, stats := range ns.ContainerStats {
+		prev, ok := previous.ContainerStats[name]
+		if !ok || stats.CPUSeconds < prev.CPUSeconds {
+			continue
+		}
+		stats.CPUPercent = (stats.CPUSeconds - prev.CPUSeconds) / elapsed * 100
+		ns.ContainerStats[name] = stats
+	}
+}
+
 func RemoteCommand(DefaultSSHHost string, sshArgs ...string) (string, error) {
 	frontArgs := append([]string{"1m", "ssh", DEFAULT_NODE_USERNAME + "@" + DefaultSSHHost}, defaultSSHParametersList...)
 	combinedArgs := append(frontArgs, sshArgs...)
@@ -76,7 +212,7 @@ func checkServer(DefaultSSHHost string, currentDeployMarker int, ch chan NodeSta
 			DeployMarker: currentDeployMarker,
 			Err:          nil,
 		}
-		result.Parse(RemoteCommand(DefaultSSHHost, statusMonitorCheckCommand))
+		result.Parse(RemoteCommand(DefaultSSHHost, statusMonitorCheckCommand()))
 		done <- result
 	}()
 
@@ -118,11 +254,21 @@ func (server *Server) monitorNodes() {
 	for {
 		select {
 		case <-repeater:
+			// Heal any missing dynos based on the results of the previous round.
+			requestReconcile(hostStatusMap)
 			server.checkNodes(nodeStatusChan)
 
 		case result := <-nodeStatusChan:
 			if deployLock.validateLatest(result.DeployMarker) {
+				if previous, ok := hostStatusMap[result.Host]; ok && result.Err == nil {
+					result.updateCPUPercent(previous)
+				}
 				hostStatusMap[result.Host] = result
+				if result.Err == nil {
+					if err := portRegistry.Reconcile(&result, result.Ts); err != nil {
+						log.Errorf("Problem reconciling port allocations for host=%v: %s", result.Host, err)
+					}
+				}
 				if err := server.pruneDynos(result, &hostStatusMap); err != nil {
 					log.Errorf("Problem pruning dynos: %s", err)
 				}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"testing"
+	"time"
 )
 
 func TestNodeStatusParse(t *testing.T) {
@@ -31,3 +32,36 @@ func TestNodeStatusParse(t *testing.T) {
 		}
 	}
 }
+
+func TestNodeStatusParseJSON(t *testing.T) {
+	input := `{"freeMemoryMb":9638,"freeDiskMb":51200,"freeZfsMb":-1,"cpus":4,"loadAverage":[0.5,0.25,0.1],"lxdVersion":"3.0.3","containers":[{"name":"fancypie-v5-web-10001","status":"Running","memoryBytes":134217728,"cpuNs":2000000000}]}`
+	ns := &NodeStatus{}
+	ns.Parse(input, nil)
+	if ns.Err != nil {
+		t.Fatalf("Unexpected error parsing input=%q: %s", input, ns.Err)
+	}
+	if ns.FreeMemoryMb != 9638 || ns.NumCPUs != 4 || ns.FreeDiskMb != 51200 || ns.LXDVersion != "3.0.3" || len(ns.LoadAverage) != 3 {
+		t.Errorf("Unexpected node status fields: %+v", ns)
+	}
+	if expected, actual := []string{"fancypie-v5-web-10001-Running"}, ns.Containers; len(actual) != 1 || actual[0] != expected[0] {
+		t.Errorf("Expected containers=%v but actual=%v", expected, actual)
+	}
+	stats := ns.ContainerStats["fancypie-v5-web-10001"]
+	if stats.MemoryMb != 128 || stats.CPUPercent != -1 {
+		t.Errorf("Unexpected container stats: %+v", stats)
+	}
+
+	// CPU utilization is derived from the previous status.
+	previous := *ns
+	previous.Ts = ns.Ts.Add(-10 * time.Second)
+	previous.ContainerStats = map[string]ContainerStats{"fancypie-v5-web-10001": {CPUSeconds: 1.5}}
+	ns.updateCPUPercent(previous)
+	if expected, actual := 5.0, ns.ContainerStats["fancypie-v5-web-10001"].CPUPercent; actual < expected-0.001 || actual > expected+0.001 {
+		t.Errorf("Expected cpu percent=%v but actual=%v", expected, actual)
+	}
+
+	ns = &NodeStatus{}
+	if ns.Parse(`{"containers":[]}`, nil); ns.Err == nil {
+		t.Errorf("Expected error for status missing free memory")
+	}
+}
@@ -1,7 +1,31 @@
 package core
 
+import (
+	"sort"
+)
+
 // TODO: Relocate this to an appropriate package.
 
 func isTruthy(v string) bool {
 	return v == "1" || v == "true" || v == "t" || v == "TRUE" || v == "T" || v == "yes" || v == "y" || v == "YES" || v == "Y"
 }
+
+// sortedStringKeys returns the keys of a map in sorted order.
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedIntKeys returns the keys of a map in sorted order.
+func sortedIntKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -85,6 +85,10 @@ backend {{ .Name }}
     {{- range $app.Servers }}
     server {{ .Host }}-{{ .Port }} {{ .Host}}:{{ .Port}} check port {{ .Port}} observe layer7
     {{- end }}
+    {{- if gt $context.ServerSlots 0 }}
+    # Spare slots for servers added through the runtime API.
+    server-template {{ $app.Name }}-slot {{ $context.ServerSlots }} 127.0.0.1:1 check observe layer7 disabled
+    {{- end }}
     {{- if and $context.HaProxyStatsEnabled $context.HaProxyCredentials }}
     stats enable
     stats uri /haproxy
@@ -0,0 +1,107 @@
+package scripts
+
+// NginxSrc is the Shipbuilder nginx load-balancer template, in golang.  It
+// routes the same way as HAProxySrc, see the "Load-Balancer Drivers" section of
+// SERVER.md for the differences.
+const NginxSrc = `
+user www-data;
+worker_processes auto;
+pid /run/nginx.pid;
+error_log syslog:server={{ .LogServerIpAndPort }},facility=local1 warn;
+
+events {
+    worker_connections 32000;
+}
+
+http {
+    log_format shipbuilder '$remote_addr [$time_local] "$host" "$request" $status $body_bytes_sent $request_time "$upstream_addr"';
+    access_log syslog:server={{ .LogServerIpAndPort }},facility=local1 shipbuilder;
+
+    default_type application/octet-stream;
+    server_tokens off;
+    keepalive_timeout 30s;
+    client_max_body_size 0;
+
+    proxy_http_version 1.1;
+    proxy_set_header Connection "";
+    proxy_set_header Host $host;
+    proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+    proxy_set_header X-Forwarded-Proto $scheme;
+    proxy_connect_timeout 5s;
+    proxy_send_timeout 30s;
+    proxy_read_timeout 30s;
+    proxy_next_upstream error timeout;
+    proxy_next_upstream_tries 4;
+
+    # NB: Certificates are looked up by SNI name, e.g.
+    # /etc/nginx/certs.d/example.com.pem holding both the key and the chain.
+    ssl_protocols TLSv1.2;
+    ssl_ciphers ECDH+AESGCM:DH+AESGCM:ECDH+AES256:DH+AES256:ECDH+AES128:DH+AES:RSA+AESGCM:RSA+AES:!aNULL:!MD5:!DSS;
+    ssl_prefer_server_ciphers on;
+
+    # Requests for unknown domains.
+    server {
+        listen 80 default_server;
+        return 503;
+    }
+
+{{- range $app := .Applications }}
+
+
+    # app: {{ .Name }}
+    upstream {{ .Name }} {
+        {{- range $app.Servers }}
+        server {{ .Host }}:{{ .Port }} max_fails=3 fail_timeout=10s;
+        {{- else }}
+        # NB: An upstream needs at least one server.
+        server 127.0.0.1:1 down;
+        {{- end }}
+        keepalive 16;
+    }
+
+    server {
+        listen 80;
+        {{- if .SSL }}
+        listen 443 ssl;
+        ssl_certificate /etc/nginx/certs.d/$ssl_server_name.pem;
+        ssl_certificate_key /etc/nginx/certs.d/$ssl_server_name.pem;
+        {{- end }}
+        server_name{{ range .Domains }} {{ . }}{{ end }};
+        {{- if and .SSL .SSLForwarding }}
+
+        # SSL redirect.
+        if ($scheme = http) {
+            return 301 https://$host$request_uri;
+        }
+        {{- end }}
+        {{- if .Maintenance }}
+
+        error_page 503 @maintenance;
+        location / {
+            return 503;
+        }
+        location ~* \.(gif|jpe?g|png|css)$ {
+            rewrite ^ {{ .MaintenancePageBasePath }}$uri break;
+            proxy_set_header Host {{ .MaintenancePageDomain }};
+            proxy_pass http://{{ .MaintenancePageDomain }};
+        }
+        location @maintenance {
+            rewrite ^ {{ .MaintenancePageFullPath }} break;
+            proxy_set_header Host {{ .MaintenancePageDomain }};
+            proxy_set_header Cache-Control "no-cache, no-store, must-revalidate";
+            proxy_set_header Pragma no-cache;
+            proxy_set_header Expires 0;
+            add_header Retry-After 60 always;
+            proxy_pass http://{{ .MaintenancePageDomain }};
+        }
+        {{- else }}
+
+        location / {
+            proxy_pass http://{{ .Name }};
+        }
+        {{- end }}
+    }
+
+{{- end }}
+}
+`
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 
@@ -411,6 +412,56 @@ func main() {
 
 			// HERE
 
+			////////////////////////////////////////////////////////////////////
+			// autoscale:*
+			appCommand(
+				cliutil.PermuteCmds([]string{"autoscale"}, []string{"show"}, true, "Autoscale_Show"),
+				"Show autoscale policies and recent autoscaling decisions for an app",
+			),
+			&cli.Command{
+				Name:        cliutil.PermuteCmds([]string{"autoscale"}, suffixes["set"], false, "Autoscale_Set")[0],
+				Aliases:     cliutil.PermuteCmds([]string{"autoscale"}, suffixes["set"], false, "Autoscale_Set")[1:],
+				Description: "Set the autoscale policy for a process type in the form of min=2 max=10 rate=50 queue=10 memory=512MB cooldown=5m",
+				Flags: []cli.Flag{
+					appFlag,
+				},
+				Action: func(ctx *cli.Context) error {
+					var (
+						app    = ctx.String("app")
+						args   = ctx.Args().Slice()
+						mapped = map[string]string{}
+						errs   = []error{}
+					)
+					if len(app) == 0 {
+						return errors.New("app flag is required")
+					}
+					if len(args) < 2 {
+						return errors.New("process type and one or more key=value settings are required")
+					}
+					for _, arg := range args[1:] {
+						if pieces := strings.SplitN(arg, "=", 2); len(pieces) == 2 {
+							mapped[pieces[0]] = pieces[1]
+						} else {
+							errs = append(errs, fmt.Errorf("malformed arg %q; must be of the form key=value", arg))
+						}
+					}
+					if err := errorlib.Merge(errs); err != nil {
+						return err
+					}
+					return (&core.Client{}).RemoteExec("Autoscale_Set", app, args[0], mapped)
+				},
+			},
+			appCommand(
+				cliutil.PermuteCmds([]string{"autoscale"}, []string{"disable", "off"}, false, "Autoscale_Disable"),
+				"Disable autoscaling for one or more process types of an app (all when none are given)",
+				flagSpec{
+					names: []string{"process-types"},
+					usage: "Process types to disable autoscaling for",
+					args:  true,
+					typ:   "slice",
+				},
+			),
+
 			////////////////////////////////////////////////////////////////////
 			// config:*
 			appCommand(
@@ -483,6 +534,21 @@ func main() {
 				},
 			},
 
+			////////////////////////////////////////////////////////////////////
+			// constraints:*
+			appCommand(
+				cliutil.PermuteCmds([]string{"constraints", "constraint"}, suffixes["list"], true, "Constraints_List"),
+				"Show node label placement constraints for an app",
+			),
+			constraintsAppCommand(
+				cliutil.PermuteCmds([]string{"constraints", "constraint"}, suffixes["add"], false, "Constraints_Add"),
+				"Add node label placement constraints for an app or process type, e.g. worker requires disk=ssd",
+			),
+			constraintsAppCommand(
+				cliutil.PermuteCmds([]string{"constraints", "constraint"}, suffixes["remove"], false, "Constraints_Remove"),
+				"Remove node label placement constraints for an app or process type",
+			),
+
 			////////////////////////////////////////////////////////////////////
 			// domains:*
 			appCommand(
@@ -607,6 +673,54 @@ func main() {
 				},
 			),
 
+			&cli.Command{
+				Name:        "deploy:archive",
+				Aliases:     []string{"Deploy_Archive"},
+				Description: "Deploy an app from a local .tar.gz, .tgz or .tar archive of its source or build artifacts",
+				Flags: []cli.Flag{
+					appFlag,
+				},
+				Action: func(ctx *cli.Context) error {
+					var (
+						app     = ctx.String("app")
+						archive = ctx.Args().First()
+					)
+					if len(app) == 0 {
+						return errors.New("app flag is required")
+					}
+					if len(archive) == 0 {
+						return errors.New("archive path argument is required")
+					}
+					f, err := os.Open(archive)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					return (&core.Client{Upload: f}).RemoteExec("Deploy_Archive", app, filepath.Base(archive))
+				},
+			},
+
+			appCommand(
+				[]string{"deploy:resume", "Deploy_Resume"},
+				"Resume the most recent failed deployment of an app from the step which failed",
+			),
+
+			////////////////////////////////////////////////////////////////////
+			// deploys:*
+			appCommand(
+				cliutil.PermuteCmds([]string{"deploys"}, suffixes["list"], true, "Deploys_List"),
+				"Show app deploy history, including failed deploys",
+			),
+			appCommand(
+				cliutil.PermuteCmds([]string{"deploys"}, []string{"log", "logs"}, false, "Deploys_Log"),
+				"Show the full output of a previous deploy",
+				flagSpec{
+					names:    []string{"version", "v"},
+					usage:    "Version of the deploy to show",
+					required: true,
+				},
+			),
+
 			////////////////////////////////////////////////////////////////////
 			// reset
 			appCommand(
@@ -614,6 +728,52 @@ func main() {
 				"Reset all build artifacts for an app so the next deployment will build from scratch",
 			),
 
+			////////////////////////////////////////////////////////////////////
+			// limits:*
+			appCommand(
+				cliutil.PermuteCmds([]string{"limits", "limit"}, suffixes["list"], true, "Limits_List"),
+				"Show dyno resource limits per process type for an app",
+			),
+			&cli.Command{
+				Name:        cliutil.PermuteCmds([]string{"limits", "limit"}, suffixes["set"], false, "Limits_Set")[0],
+				Aliases:     cliutil.PermuteCmds([]string{"limits", "limit"}, suffixes["set"], false, "Limits_Set")[1:],
+				Description: "Set dyno resource limits for a process type in the form of memory=512MB cpus=2 cpu=50% processes=200",
+				Flags: []cli.Flag{
+					appFlag,
+				},
+				Action: func(ctx *cli.Context) error {
+					var (
+						app    = ctx.String("app")
+						args   = ctx.Args().Slice()
+						mapped = map[string]string{}
+						errs   = []error{}
+					)
+					if len(app) == 0 {
+						return errors.New("app flag is required")
+					}
+					if len(args) < 2 {
+						return errors.New("process type and one or more key=value limits are required")
+					}
+					for _, arg := range args[1:] {
+						if pieces := strings.SplitN(arg, "=", 2); len(pieces) == 2 {
+							mapped[pieces[0]] = pieces[1]
+						} else {
+							errs = append(errs, fmt.Errorf("malformed arg %q; must be of the form key=value", arg))
+						}
+					}
+					if err := errorlib.Merge(errs); err != nil {
+						return err
+					}
+					return (&core.Client{}).RemoteExec("Limits_Set", app, args[0], mapped)
+				},
+			},
+			argsOrFlagAppCommand(
+				cliutil.PermuteCmds([]string{"limits", "limit"}, suffixes["remove"], false, "Limits_Remove"),
+				"Remove dyno resource limits for one or more process types of an app",
+				[]string{"process-types"},
+				"Specify flag multiple times for multiple process types",
+			),
+
 			////////////////////////////////////////////////////////////////////
 			// logs:*
 			appCommand(
@@ -681,8 +841,50 @@ func main() {
 					required: true,
 				},
 			),
+			argsOrFlagAppCommand(
+				[]string{"run:detached", "Run_Detached"},
+				"Run a command in a one-off dyno on a cluster node without staying attached",
+				[]string{"command", "c"},
+				"Command to run, e.g. run:detached -a myapp -- ./bin/task",
+			),
+			appCommand(
+				[]string{"run:list", "runs", "Run_List"},
+				"List an app's one-off runs and their exit statuses",
+			),
 			// TODO: consider adding command to attach to a running container.
 
+			////////////////////////////////////////////////////////////////////
+			// scheduler:*
+			appCommand(
+				cliutil.PermuteCmds([]string{"scheduler", "schedules", "cron"}, suffixes["list"], true, "Scheduler_List"),
+				"Show an app's scheduled jobs",
+			),
+			&cli.Command{
+				Name:        cliutil.PermuteCmds([]string{"scheduler", "schedules", "cron"}, suffixes["add"], false, "Scheduler_Add")[0],
+				Aliases:     cliutil.PermuteCmds([]string{"scheduler", "schedules", "cron"}, suffixes["add"], false, "Scheduler_Add")[1:],
+				Description: `Run a command periodically in a one-off dyno, e.g. scheduler:add -a myapp "0 3 * * *" -- ./bin/cleanup`,
+				Flags:       []cli.Flag{appFlag},
+				Action: func(ctx *cli.Context) error {
+					var (
+						app  = ctx.String("app")
+						args = ctx.Args().Slice()
+					)
+					if len(app) == 0 {
+						return errors.New("app flag is required")
+					}
+					if len(args) < 2 {
+						return errors.New("a cron schedule and a command are required")
+					}
+					return (&core.Client{}).RemoteExec("Scheduler_Add", app, args[0], args[1:])
+				},
+			},
+			argsOrFlagAppCommand(
+				cliutil.PermuteCmds([]string{"scheduler", "schedules", "cron"}, suffixes["remove"], false, "Scheduler_Remove"),
+				"Remove one or more scheduled jobs from an app",
+				[]string{"id", "ids"},
+				"Specify flag multiple times for multiple scheduled job ids",
+			),
+
 			////////////////////////////////////////////////////////////////////
 			// maint:*
 			appCommand(
@@ -707,6 +909,38 @@ func main() {
 				"Deactivates maintenance mode for an app",
 			),
 
+			////////////////////////////////////////////////////////////////////
+			// reconcile:*
+			appCommand(
+				cliutil.PermuteCmds([]string{"reconcile"}, suffixes["status"], true, "Reconcile_Status"),
+				"Show whether missing dynos are automatically replaced for an app, and any which are missing",
+			),
+			appCommand(
+				cliutil.PermuteCmds([]string{"reconcile"}, []string{"on", "+"}, false, "Reconcile_On"),
+				"Enable automatic replacement of missing dynos for an app",
+			),
+			appCommand(
+				cliutil.PermuteCmds([]string{"reconcile"}, []string{"off", "-"}, false, "Reconcile_Off"),
+				"Disable automatic replacement of missing dynos for an app",
+			),
+
+			////////////////////////////////////////////////////////////////////
+			// placement:*
+			appCommand(
+				cliutil.PermuteCmds([]string{"placement"}, []string{"get"}, true, "Placement_Get"),
+				"Show the dyno placement strategy for app",
+			),
+			appCommand(
+				cliutil.PermuteCmds([]string{"placement"}, suffixes["set"], false, "Placement_Set"),
+				"Set the dyno placement strategy for app",
+				flagSpec{
+					names:       []string{"strategy", "s"},
+					usage:       "Placement strategy",
+					required:    true,
+					allowedVals: core.PlacementStrategies(),
+				},
+			),
+
 			////////////////////////////////////////////////////////////////////
 			// privatekey:*
 			appCommand(
@@ -715,7 +949,7 @@ func main() {
 			),
 			appCommand(
 				cliutil.PermuteCmds([]string{"privatekey", "privkey"}, suffixes["set"], false, "PrivateKey_Set"),
-				"Set the maintenance redirect URL for an app",
+				"Set SSH private key to use for accessing private submodules and dependencies while building app",
 				flagSpec{
 					names:    []string{"private-key"},
 					usage:    "Private SSH keystring",
@@ -737,18 +971,83 @@ func main() {
 				[]string{"ps:scale", "scale", "Ps_Scale"},
 				"Scale app processes up or down",
 			),
+			appCommand(
+				[]string{"ps:stats", "Ps_Stats"},
+				"Show memory and CPU usage of an app's running container processes",
+			),
+			&cli.Command{
+				Name:        "ps:exec",
+				Aliases:     []string{"exec", "Ps_Exec"},
+				Description: "Run a command inside a running dyno of an app, e.g. ps:exec -a myapp web.2 -- env",
+				Flags: []cli.Flag{
+					appFlag,
+					&cli.BoolFlag{
+						Name:    "tty",
+						Aliases: []string{"t"},
+						Usage:   "Attach an interactive terminal, running a shell when no command is given",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					var (
+						app  = ctx.String("app")
+						tty  = ctx.Bool("tty")
+						args = ctx.Args().Slice()
+					)
+					if len(app) == 0 {
+						return errors.New("app flag is required")
+					}
+					if len(args) == 0 {
+						return errors.New("a dyno name of the form process.N is required, e.g. web.1")
+					}
+					if len(args) == 1 && !tty {
+						return errors.New("a command is required unless --tty is given")
+					}
+					return (&core.Client{}).RemoteExec("Ps_Exec", app, args[0], tty, args[1:])
+				},
+			},
 			argsOrFlagAppCommand(
 				cliutil.PermuteCmds([]string{"ps"}, suffixes["status"], false, "Ps_Status"),
 				"Get the status of one or more container processes for an app",
 				[]string{"process-types"},
 				"Specify flag multiple times for multiple process types",
 			),
-			argsOrFlagAppCommand(
-				[]string{"ps:restart", "restart", "Ps_Restart"},
-				"Restart one or more container processes for an app",
-				[]string{"process-types"},
-				"Specify flag multiple times for multiple process types",
-			),
+			&cli.Command{
+				Name:        "ps:restart",
+				Aliases:     []string{"restart", "Ps_Restart"},
+				Description: "Restart one or more process types or individual dynos (e.g. web.1) for an app",
+				Flags: []cli.Flag{
+					appFlag,
+					&cli.StringSliceFlag{
+						Name:  "process-types",
+						Usage: "Specify flag multiple times for multiple process types or dyno names",
+					},
+					&cli.BoolFlag{
+						Name:  "rolling",
+						Usage: "Restart one dyno at a time, taking web dynos out of the load-balancers until they pass their health check",
+					},
+					&cli.StringFlag{
+						Name:  "interval",
+						Usage: "Pause between dynos during a rolling restart, e.g. 10s",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					var (
+						app   = ctx.String("app")
+						names = ctx.StringSlice("process-types")
+					)
+					if len(app) == 0 {
+						return errors.New("app flag is required")
+					}
+					// NB: Notice the precedence here - flag is respected above args.
+					if ctx.Args().Present() && len(names) == 0 {
+						names = ctx.Args().Slice()
+					}
+					if len(names) == 0 {
+						return errors.New("process-types flag or args values are required")
+					}
+					return (&core.Client{}).RemoteExec("Ps_Restart", app, ctx.Bool("rolling"), ctx.String("interval"), names)
+				},
+			},
 			argsOrFlagAppCommand(
 				[]string{"ps:stop", "stop", "Ps_Stop"},
 				"Stop one or more container processes for an app",
@@ -806,6 +1105,10 @@ func main() {
 			command(
 				cliutil.PermuteCmds([]string{"lb", "lbs"}, suffixes["add"], false, "LoadBalancer_Add"),
 				"Add one or more load-balancers to shipbuilder instance",
+				flagSpec{
+					names: []string{"driver"},
+					usage: "Load-balancer software the hosts run, one of: " + strings.Join(core.LBDrivers, ", ") + " (default: " + core.LBDriverHAProxy + ")",
+				},
 				flagSpec{
 					names:    []string{"hostname", "hostnames"},
 					usage:    "Specify flag multiple times for multiple load-balancer hostnames",
@@ -829,6 +1132,10 @@ func main() {
 				cliutil.PermuteCmds([]string{"lb", "lbs"}, []string{"sync"}, false, "LoadBalancer_Sync"),
 				"Sync internal apps and domains state to physical LB configuration",
 			),
+			command(
+				cliutil.PermuteCmds([]string{"lb", "lbs"}, []string{"diff"}, false, "LoadBalancer_Diff"),
+				"Show differences between the desired and each LB's installed configuration",
+			),
 
 			////////////////////////////////////////////////////////////////////
 			// nodes:*
@@ -858,6 +1165,74 @@ func main() {
 					typ:      "slice",
 				},
 			),
+			&cli.Command{
+				Name:        cliutil.PermuteCmds([]string{"nodes", "node", "slaves", "slave"}, []string{"label"}, false, "Node_Label")[0],
+				Aliases:     cliutil.PermuteCmds([]string{"nodes", "node", "slaves", "slave"}, []string{"label"}, false, "Node_Label")[1:],
+				Description: "Set or remove (with an empty value) labels on a slave node in the form of disk=ssd zone=a",
+				Action: func(ctx *cli.Context) error {
+					var (
+						args   = ctx.Args().Slice()
+						mapped = map[string]string{}
+						errs   = []error{}
+					)
+					if len(args) < 2 {
+						return errors.New("node hostname and one or more key=value labels are required")
+					}
+					for _, arg := range args[1:] {
+						if pieces := strings.SplitN(arg, "=", 2); len(pieces) == 2 {
+							mapped[pieces[0]] = pieces[1]
+						} else {
+							errs = append(errs, fmt.Errorf("malformed arg %q; must be of the form key=value", arg))
+						}
+					}
+					if err := errorlib.Merge(errs); err != nil {
+						return err
+					}
+					return (&core.Client{}).RemoteExec("Node_Label", args[0], mapped)
+				},
+			},
+			command(
+				cliutil.PermuteCmds([]string{"nodes", "node", "slaves", "slave"}, []string{"ports"}, false, "Node_Ports"),
+				"Show dyno port allocations on a slave node",
+				flagSpec{
+					names:    []string{"hostname"},
+					usage:    "Slave node hostname",
+					required: true,
+					args:     true,
+				},
+			),
+			command(
+				cliutil.PermuteCmds([]string{"nodes", "node", "slaves", "slave"}, []string{"cordon"}, false, "Node_Cordon"),
+				"Stop placing new dynos on one or more slave nodes",
+				flagSpec{
+					names:    []string{"hostname", "hostnames"},
+					usage:    "Specify flag multiple times for multiple slave node hostnames",
+					required: true,
+					args:     true,
+					typ:      "slice",
+				},
+			),
+			command(
+				cliutil.PermuteCmds([]string{"nodes", "node", "slaves", "slave"}, []string{"uncordon"}, false, "Node_Uncordon"),
+				"Resume placing new dynos on one or more cordoned slave nodes",
+				flagSpec{
+					names:    []string{"hostname", "hostnames"},
+					usage:    "Specify flag multiple times for multiple slave node hostnames",
+					required: true,
+					args:     true,
+					typ:      "slice",
+				},
+			),
+			command(
+				cliutil.PermuteCmds([]string{"nodes", "node", "slaves", "slave"}, []string{"drain"}, false, "Node_Drain"),
+				"Cordon a slave node, start replacements elsewhere for its dynos, then remove its dynos from the load-balancers and shut them down",
+				flagSpec{
+					names:    []string{"hostname"},
+					usage:    "Slave node hostname",
+					required: true,
+					args:     true,
+				},
+			),
 
 			////////////////////////////////////////////////////////////////////
 			// runtime:*
@@ -1263,6 +1638,46 @@ func deferredMappedAppCommand(names []string, description string) *cli.Command {
 	}
 }
 
+// constraintsAppCommand generates an app command taking placement constraint
+// arguments of the form `[process-type] requires|avoids key[=value]..'.
+//
+// The names parameter must be non-empty and end with a value which corresponds
+// to a valid shipbuilder command function.
+func constraintsAppCommand(names []string, description string) *cli.Command {
+	if len(names) == 0 {
+		panic("name / aliases slice must not be empty!")
+	}
+	return &cli.Command{
+		Name:        names[0],
+		Aliases:     names[1:],
+		Description: description,
+		Flags: []cli.Flag{
+			appFlag,
+		},
+		Action: func(ctx *cli.Context) error {
+			var (
+				app    = ctx.String("app")
+				args   = []string{}
+				mapped = map[string]string{}
+			)
+			if len(app) == 0 {
+				return errors.New("app flag is required")
+			}
+			for _, arg := range ctx.Args().Slice() {
+				if pieces := strings.SplitN(arg, "=", 2); len(pieces) == 2 {
+					mapped[pieces[0]] = pieces[1]
+				} else {
+					args = append(args, arg)
+				}
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("constraint operator is required, must be one of: %v, %v", core.ConstraintRequires, core.ConstraintAvoids)
+			}
+			return (&core.Client{}).RemoteExec(names[len(names)-1], app, args, mapped)
+		},
+	}
+}
+
 func scriptSubcommands() []*cli.Command {
 	// genPrintAction generates a cli action function which prints the passed
 	// content.